@@ -0,0 +1,47 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package accounts implements high level Ethereum account management.
+package accounts
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Account represents a stored key.
+type Account struct {
+	Address common.Address
+}
+
+func (acc Account) String() string {
+	return acc.Address.Hex()
+}
+
+// ErrUnknownAccount is returned for account addresses that aren't found in
+// the account manager's keystore directory.
+var ErrUnknownAccount = fmt.Errorf("unknown account")
+
+// ErrDecrypt is returned when a key fails to decrypt with a given passphrase.
+var ErrDecrypt = fmt.Errorf("could not decrypt key with given passphrase")
+
+// ErrLocked is returned by Sign when the requested account isn't unlocked.
+var ErrLocked = fmt.Errorf("account is locked")
+
+// ErrUnknownWallet is returned by Manager.Wallet when no wallet is known at
+// the requested URL.
+var ErrUnknownWallet = fmt.Errorf("unknown wallet")
@@ -0,0 +1,139 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package accounts
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pborman/uuid"
+)
+
+const version = 3
+
+// Key is the in-memory, decrypted representation of an account: its on-disk
+// identifier, address, and the private key that controls it. KeyStore
+// implementations are responsible for turning one of these into (and back
+// out of) whatever encrypted form they persist to disk.
+type Key struct {
+	Id         uuid.UUID
+	Address    common.Address
+	PrivateKey *ecdsa.PrivateKey
+}
+
+// KeyStore is implemented by the different on-disk key representations:
+// keyStorePlain stores keys unencrypted, keyStorePassphrase encrypts them
+// with a user-supplied passphrase.
+type KeyStore interface {
+	// GetKey loads and decrypts the key for addr, stored under filename.
+	GetKey(addr common.Address, filename string, auth string) (*Key, error)
+	// StoreKey writes and, depending on the implementation, encrypts k.
+	StoreKey(k *Key, auth string) error
+	// JoinPath joins filename with the keystore's directory unless it is
+	// already absolute.
+	JoinPath(filename string) string
+}
+
+type plainKeyJSON struct {
+	Address    string
+	PrivateKey string
+	Id         string
+	Version    int
+}
+
+// MarshalJSON implements the unencrypted, plain-text key-file format used by
+// keyStorePlain.
+func (k *Key) MarshalJSON() (j []byte, err error) {
+	jStruct := plainKeyJSON{
+		hex.EncodeToString(k.Address[:]),
+		hex.EncodeToString(crypto.FromECDSA(k.PrivateKey)),
+		k.Id.String(),
+		version,
+	}
+	j, err = json.Marshal(jStruct)
+	return j, err
+}
+
+func (k *Key) UnmarshalJSON(j []byte) (err error) {
+	keyJSON := new(plainKeyJSON)
+	err = json.Unmarshal(j, &keyJSON)
+	if err != nil {
+		return err
+	}
+
+	u := new(uuid.UUID)
+	*u = uuid.Parse(keyJSON.Id)
+	k.Id = *u
+	addr, err := hex.DecodeString(keyJSON.Address)
+	if err != nil {
+		return err
+	}
+	privkey, err := hex.DecodeString(keyJSON.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	k.Address = common.BytesToAddress(addr)
+	k.PrivateKey = crypto.ToECDSA(privkey)
+
+	return nil
+}
+
+func newKeyFromECDSA(privateKeyECDSA *ecdsa.PrivateKey) *Key {
+	return &Key{
+		Id:         uuid.NewRandom(),
+		Address:    crypto.PubkeyToAddress(privateKeyECDSA.PublicKey),
+		PrivateKey: privateKeyECDSA,
+	}
+}
+
+// NewKeyFromECDSA wraps an existing private key into the on-disk Key format,
+// assigning it a fresh id.
+func NewKeyFromECDSA(privateKeyECDSA *ecdsa.PrivateKey) *Key {
+	return newKeyFromECDSA(privateKeyECDSA)
+}
+
+// NewKey generates a fresh private key and wraps it into a Key.
+func NewKey() (*Key, error) {
+	privateKeyECDSA, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return newKeyFromECDSA(privateKeyECDSA), nil
+}
+
+func keyFileName(keyAddr common.Address) string {
+	ts := time.Now().UTC()
+	return fmt.Sprintf("UTC--%s--%s", toISO8601(ts), hex.EncodeToString(keyAddr[:]))
+}
+
+func toISO8601(t time.Time) string {
+	var tz string
+	name, offset := t.Zone()
+	if name == "UTC" {
+		tz = "Z"
+	} else {
+		tz = fmt.Sprintf("%03d00", offset/3600)
+	}
+	return fmt.Sprintf("%04d-%02d-%02dT%02d-%02d-%02d.%09d%s",
+		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), tz)
+}
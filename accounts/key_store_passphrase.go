@@ -0,0 +1,309 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package accounts
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pborman/uuid"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Scrypt cost parameters. Standard is for interactive account creation,
+// Light trades security for speed on constrained or headless nodes (see
+// cmd/utils' --lightkdf flag).
+const (
+	StandardScryptN = 1 << 18
+	StandardScryptP = 1
+
+	LightScryptN = 1 << 12
+	LightScryptP = 6
+
+	scryptR     = 8
+	scryptDKLen = 32
+)
+
+// keyStorePassphrase encrypts keys with a user passphrase before writing
+// them to disk, using the Web3 Secret Storage v3 format: scrypt for key
+// derivation and AES-128 in CTR mode for the private key itself. It also
+// reads back the pre-v3 format this package originally shipped with, and
+// the 2015 presale format, so existing key files of either kind keep
+// working.
+type keyStorePassphrase struct {
+	keysDirPath string
+	scryptN     int
+	scryptP     int
+}
+
+// NewKeyStorePassphrase creates a KeyStore that encrypts keys with a
+// passphrase before persisting them under keydir, using the given scrypt
+// cost parameters.
+func NewKeyStorePassphrase(keydir string, scryptN, scryptP int) KeyStore {
+	return &keyStorePassphrase{keydir, scryptN, scryptP}
+}
+
+// cipherparamsJSON holds the AES cipher parameters of a Web3 Secret Storage
+// v3 "crypto" object.
+type cipherparamsJSON struct {
+	IV string `json:"iv"`
+}
+
+// kdfparamsJSON holds the scrypt parameters of a Web3 Secret Storage v3
+// "crypto" object, recorded per file so a key stays decryptable even if
+// StandardScryptN/P are later tightened.
+type kdfparamsJSON struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DkLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+type cryptoJSON struct {
+	Cipher       string            `json:"cipher"`
+	CipherText   string            `json:"ciphertext"`
+	CipherParams cipherparamsJSON  `json:"cipherparams"`
+	KDF          string            `json:"kdf"`
+	KDFParams    kdfparamsJSON     `json:"kdfparams"`
+	MAC          string            `json:"mac"`
+}
+
+// encryptedKeyJSONV3 is the Web3 Secret Storage v3 key-file format.
+type encryptedKeyJSONV3 struct {
+	Address string     `json:"address"`
+	Crypto  cryptoJSON `json:"crypto"`
+	Id      string     `json:"id"`
+	Version int        `json:"version"`
+}
+
+// cryptoParamsJSONV1 and encryptedKeyJSONV1 are this package's original
+// (pre-Web3-Secret-Storage-v3) passphrase format: scrypt and AES-CTR like
+// v3, but with a flat, un-nested crypto object rather than the real
+// kdf/kdfparams/cipherparams schema. Kept read-only for key files written
+// before this format existed.
+type cryptoParamsJSONV1 struct {
+	Salt       string
+	IV         string
+	CipherText string
+	MAC        string
+}
+
+type encryptedKeyJSONV1 struct {
+	Address string
+	Crypto  cryptoParamsJSONV1
+	Id      string
+	Version string
+}
+
+func (ks keyStorePassphrase) GetKey(addr common.Address, filename, auth string) (*Key, error) {
+	keyJSON, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := DecryptKey(keyJSON, auth)
+	if err != nil {
+		return nil, err
+	}
+	if key.Address != addr {
+		return nil, fmt.Errorf("key content mismatch: have account %x, want %x", key.Address, addr)
+	}
+	return key, nil
+}
+
+func (ks keyStorePassphrase) StoreKey(key *Key, auth string) error {
+	content, err := EncryptKey(key, auth, ks.scryptN, ks.scryptP)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(ks.JoinPath(keyFileName(key.Address)), content)
+}
+
+func (ks keyStorePassphrase) JoinPath(filename string) string {
+	return keyStorePlain{ks.keysDirPath}.JoinPath(filename)
+}
+
+// EncryptKey encrypts key with auth at the given scrypt cost and returns the
+// resulting Web3 Secret Storage v3 key-file JSON.
+func EncryptKey(key *Key, auth string, scryptN, scryptP int) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(auth), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	keyBytes := crypto.FromECDSA(key.PrivateKey)
+	cipherText, err := aesCTRXOR(derivedKey[:16], keyBytes, iv)
+	if err != nil {
+		return nil, err
+	}
+	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
+
+	keyJSON := encryptedKeyJSONV3{
+		Address: hex.EncodeToString(key.Address[:]),
+		Crypto: cryptoJSON{
+			Cipher:     "aes-128-ctr",
+			CipherText: hex.EncodeToString(cipherText),
+			CipherParams: cipherparamsJSON{
+				IV: hex.EncodeToString(iv),
+			},
+			KDF: "scrypt",
+			KDFParams: kdfparamsJSON{
+				N:     scryptN,
+				R:     scryptR,
+				P:     scryptP,
+				DkLen: scryptDKLen,
+				Salt:  hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(mac),
+		},
+		Id:      key.Id.String(),
+		Version: version,
+	}
+	return json.Marshal(keyJSON)
+}
+
+// DecryptKey decrypts keyJSON with auth, trying the Web3 Secret Storage v3
+// format first, then this package's original pre-v3 format, then the 2015
+// presale format, so any key file this package has ever produced (or
+// imported) is readable.
+func DecryptKey(keyJSON []byte, auth string) (*Key, error) {
+	v3 := new(encryptedKeyJSONV3)
+	if err := json.Unmarshal(keyJSON, v3); err == nil && v3.Crypto.KDF == "scrypt" {
+		return decryptKeyV3(v3, auth)
+	}
+	v1 := new(encryptedKeyJSONV1)
+	if err := json.Unmarshal(keyJSON, v1); err == nil && v1.Crypto.Salt != "" {
+		return decryptKeyV1(v1, auth)
+	}
+	return decryptPreSaleKey(keyJSON, auth)
+}
+
+func decryptKeyV3(keyJSON *encryptedKeyJSONV3, auth string) (*Key, error) {
+	if keyJSON.Version != version {
+		return nil, fmt.Errorf("unsupported key version %d", keyJSON.Version)
+	}
+	if keyJSON.Crypto.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("unsupported cipher %q", keyJSON.Crypto.Cipher)
+	}
+	salt, err := hex.DecodeString(keyJSON.Crypto.KDFParams.Salt)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(keyJSON.Crypto.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(keyJSON.Crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(keyJSON.Crypto.MAC)
+	if err != nil {
+		return nil, err
+	}
+	p := keyJSON.Crypto.KDFParams
+	derivedKey, err := scrypt.Key([]byte(auth), salt, p.N, p.R, p.P, p.DkLen)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(crypto.Keccak256(derivedKey[16:32], cipherText), mac) {
+		return nil, ErrDecrypt
+	}
+	plainText, err := aesCTRXOR(derivedKey[:16], cipherText, iv)
+	if err != nil {
+		return nil, err
+	}
+	addrBytes, err := hex.DecodeString(keyJSON.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		Id:         uuid.Parse(keyJSON.Id),
+		Address:    common.BytesToAddress(addrBytes),
+		PrivateKey: crypto.ToECDSA(plainText),
+	}, nil
+}
+
+func decryptKeyV1(keyJSON *encryptedKeyJSONV1, auth string) (*Key, error) {
+	salt, err := hex.DecodeString(keyJSON.Crypto.Salt)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(keyJSON.Crypto.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(keyJSON.Crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(keyJSON.Crypto.MAC)
+	if err != nil {
+		return nil, err
+	}
+	// The v1 format didn't record its own scrypt cost parameters, since they
+	// were fixed program-wide at the time; StandardScryptN/P are the values
+	// that were in force.
+	derivedKey, err := scrypt.Key([]byte(auth), salt, StandardScryptN, scryptR, StandardScryptP, scryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(crypto.Keccak256(derivedKey[16:32], cipherText), mac) {
+		return nil, ErrDecrypt
+	}
+	plainText, err := aesCTRXOR(derivedKey[:16], cipherText, iv)
+	if err != nil {
+		return nil, err
+	}
+	addrBytes, err := hex.DecodeString(keyJSON.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		Id:         uuid.Parse(keyJSON.Id),
+		Address:    common.BytesToAddress(addrBytes),
+		PrivateKey: crypto.ToECDSA(plainText),
+	}, nil
+}
+
+// aesCTRXOR runs AES in CTR mode over inText; since CTR is a stream cipher
+// this same function both encrypts and decrypts.
+func aesCTRXOR(key, inText, iv []byte) ([]byte, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCTR(aesBlock, iv)
+	outText := make([]byte, len(inText))
+	stream.XORKeyStream(outText, inText)
+	return outText, err
+}
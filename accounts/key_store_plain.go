@@ -0,0 +1,149 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package accounts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// keyStorePlain stores keys unencrypted on disk. It exists mainly so tests
+// and tools don't need a passphrase; real accounts should always go through
+// keyStorePassphrase.
+type keyStorePlain struct {
+	keysDirPath string
+}
+
+// NewKeyStorePlain creates a KeyStore that keeps its keys, unencrypted, in
+// keydir.
+func NewKeyStorePlain(keydir string) KeyStore {
+	return &keyStorePlain{keydir}
+}
+
+func (ks keyStorePlain) GetKey(addr common.Address, filename, auth string) (*Key, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	key := new(Key)
+	if err := json.NewDecoder(fd).Decode(key); err != nil {
+		return nil, err
+	}
+	if key.Address != addr {
+		return nil, fmt.Errorf("key content mismatch: have address %x, want %x", key.Address, addr)
+	}
+	return key, nil
+}
+
+func (ks keyStorePlain) StoreKey(key *Key, auth string) error {
+	filename := ks.JoinPath(keyFileName(key.Address))
+	content, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(filename, content)
+}
+
+func (ks keyStorePlain) JoinPath(filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(ks.keysDirPath, filename)
+}
+
+func writeKeyFile(file string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), "key-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	f.Close()
+	return os.Rename(f.Name(), file)
+}
+
+// accountFile pairs an Account with the path of the key file that produced
+// it, so callers that need to decrypt (Manager.Unlock, Manager.Export) know
+// which file to hand to KeyStore.GetKey.
+type accountFile struct {
+	Account
+	path string
+}
+
+// scanAccountFiles walks keydir and returns the address and path advertised
+// by every key file it finds. It reads only the small "Address" field of
+// each file, not the encrypted private key, so it works the same whether
+// the directory holds plaintext or passphrase-encrypted keys.
+func scanAccountFiles(keydir string) ([]accountFile, error) {
+	files, err := ioutil.ReadDir(keydir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var accounts []accountFile
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(keydir, fi.Name())
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var minimal struct{ Address string }
+		if err := json.Unmarshal(raw, &minimal); err != nil {
+			continue
+		}
+		if !common.IsHexAddress(minimal.Address) {
+			continue
+		}
+		accounts = append(accounts, accountFile{
+			Account: Account{Address: common.HexToAddress(minimal.Address)},
+			path:    path,
+		})
+	}
+	return accounts, nil
+}
+
+// scanAccounts is scanAccountFiles without the file paths, for callers that
+// only care about which addresses exist.
+func scanAccounts(keydir string) ([]Account, error) {
+	files, err := scanAccountFiles(keydir)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]Account, len(files))
+	for i, f := range files {
+		accounts[i] = f.Account
+	}
+	return accounts, nil
+}
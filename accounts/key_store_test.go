@@ -0,0 +1,97 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package accounts
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// testV3Vector is the canonical Web3 Secret Storage scrypt test vector from
+// the spec at https://github.com/ethereum/wiki/wiki/Web3-Secret-Storage-Definition,
+// encrypting privkey below under the passphrase "testpassword".
+const testV3Vector = `{
+	"address": "008aeeda4d805471df9b2a5b0f38a0c3bcba786b",
+	"crypto" : {
+		"cipher" : "aes-128-ctr",
+		"cipherparams" : {
+			"iv" : "83dbcc02d8ccb40e466191a123791e0e"
+		},
+		"ciphertext" : "d172bf743a674da9cdad04534d56926ef8358534d458fffccd4e6ad2fbde479c",
+		"kdf" : "scrypt",
+		"kdfparams" : {
+			"dklen" : 32,
+			"salt" : "ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19",
+			"n" : 262144,
+			"r" : 1,
+			"p" : 8
+		},
+		"mac" : "2103ac29920d71da29f15d75b4a16dbe95cfd7ff8faea1056c33131d846e3d49"
+	},
+	"id" : "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+	"version" : 3
+}`
+
+const testV3Privkey = "7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d"
+
+func TestDecryptKeyV3Vector(t *testing.T) {
+	key, err := DecryptKey([]byte(testV3Vector), "testpassword")
+	if err != nil {
+		t.Fatalf("DecryptKey failed: %v", err)
+	}
+	want := crypto.ToECDSA(hexMustDecode(t, testV3Privkey))
+	if key.PrivateKey.D.Cmp(want.D) != 0 {
+		t.Errorf("recovered private key mismatch: have %x, want %x", key.PrivateKey.D, want.D)
+	}
+}
+
+func TestDecryptKeyV3WrongPassphrase(t *testing.T) {
+	if _, err := DecryptKey([]byte(testV3Vector), "wrong"); err != ErrDecrypt {
+		t.Errorf("expected ErrDecrypt, got %v", err)
+	}
+}
+
+func TestEncryptDecryptKeyV3RoundTrip(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyJSON, err := EncryptKey(key, "my passphrase", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("EncryptKey failed: %v", err)
+	}
+	decrypted, err := DecryptKey(keyJSON, "my passphrase")
+	if err != nil {
+		t.Fatalf("DecryptKey failed: %v", err)
+	}
+	if decrypted.Address != key.Address {
+		t.Errorf("address mismatch: have %x, want %x", decrypted.Address, key.Address)
+	}
+	if decrypted.PrivateKey.D.Cmp(key.PrivateKey.D) != 0 {
+		t.Errorf("private key mismatch after round trip")
+	}
+}
+
+func hexMustDecode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,290 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package accounts
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Manager is the high level interface callers (the personal_* RPC handlers,
+// the miner, cmd/geth) use to create, unlock and sign with accounts, without
+// ever touching a KeyStore, a Key or a Wallet directly.
+type Manager struct {
+	keyStore KeyStore
+	backends []Backend
+
+	mu       sync.RWMutex
+	unlocked map[common.Address]*Key
+	wallets  []Wallet
+
+	updates chan WalletEvent
+	quit    chan struct{}
+
+	subsMu sync.Mutex
+	subs   map[chan WalletEvent]struct{}
+}
+
+// NewManager creates an account manager backed by keyStore, optionally
+// aggregating one or more hardware-wallet backends (e.g. usbwallet.NewHub)
+// alongside it.
+func NewManager(keyStore KeyStore, backends ...Backend) *Manager {
+	am := &Manager{
+		keyStore: keyStore,
+		backends: backends,
+		unlocked: make(map[common.Address]*Key),
+		updates:  make(chan WalletEvent, 16),
+		quit:     make(chan struct{}),
+		subs:     make(map[chan WalletEvent]struct{}),
+	}
+	for _, backend := range backends {
+		am.wallets = append(am.wallets, backend.Wallets()...)
+		backend.Subscribe(am.updates)
+	}
+	if len(backends) > 0 {
+		go am.update()
+	}
+	return am
+}
+
+// update keeps am.wallets and every Subscribe'r in sync with arrival/drop
+// events reported by the backends passed to NewManager.
+func (am *Manager) update() {
+	for {
+		select {
+		case event := <-am.updates:
+			am.mu.Lock()
+			switch event.Kind {
+			case WalletArrived:
+				am.wallets = append(am.wallets, event.Wallet)
+			case WalletDropped:
+				for i, w := range am.wallets {
+					if w == event.Wallet {
+						am.wallets = append(am.wallets[:i], am.wallets[i+1:]...)
+						break
+					}
+				}
+			}
+			am.mu.Unlock()
+
+			am.subsMu.Lock()
+			for sub := range am.subs {
+				select {
+				case sub <- event:
+				default:
+				}
+			}
+			am.subsMu.Unlock()
+
+		case <-am.quit:
+			return
+		}
+	}
+}
+
+// Subscribe registers sink to receive wallet arrival/drop events, returning
+// a function that unsubscribes it.
+func (am *Manager) Subscribe(sink chan WalletEvent) (unsubscribe func()) {
+	am.subsMu.Lock()
+	am.subs[sink] = struct{}{}
+	am.subsMu.Unlock()
+
+	return func() {
+		am.subsMu.Lock()
+		delete(am.subs, sink)
+		am.subsMu.Unlock()
+	}
+}
+
+// Wallets returns every wallet currently known through the backends passed
+// to NewManager, e.g. every USB hardware wallet the usbwallet hub has
+// enumerated so far.
+func (am *Manager) Wallets() []Wallet {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	wallets := make([]Wallet, len(am.wallets))
+	copy(wallets, am.wallets)
+	return wallets
+}
+
+// Wallet looks up the wallet previously reported at url (as returned by
+// Wallet.URL().String()).
+func (am *Manager) Wallet(url string) (Wallet, error) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	for _, wallet := range am.wallets {
+		if wallet.URL().String() == url {
+			return wallet, nil
+		}
+	}
+	return nil, ErrUnknownWallet
+}
+
+// walletFor returns the wallet, if any, that currently has addr derived.
+func (am *Manager) walletFor(addr common.Address) Wallet {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	for _, wallet := range am.wallets {
+		if wallet.Contains(Account{Address: addr}) {
+			return wallet
+		}
+	}
+	return nil
+}
+
+// Accounts returns every account found in the manager's keystore directory,
+// plus every account already derived from a known wallet.
+func (am *Manager) Accounts() []Account {
+	accounts, _ := scanAccounts(am.keyStore.JoinPath(""))
+	for _, wallet := range am.Wallets() {
+		accounts = append(accounts, wallet.Accounts()...)
+	}
+	return accounts
+}
+
+// HasAccount reports whether addr has a key in the keystore directory or is
+// derived from a known wallet.
+func (am *Manager) HasAccount(addr common.Address) bool {
+	for _, a := range am.Accounts() {
+		if a.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign signs hash with the private key of addr, which must either have been
+// unlocked with Unlock first, or be derived from a wallet, in which case the
+// signature is produced on the device itself.
+func (am *Manager) Sign(addr common.Address, hash []byte) (sig []byte, err error) {
+	am.mu.RLock()
+	unlockedKey, found := am.unlocked[addr]
+	am.mu.RUnlock()
+	if found {
+		return crypto.Sign(hash, unlockedKey.PrivateKey)
+	}
+	if wallet := am.walletFor(addr); wallet != nil {
+		return wallet.SignHash(Account{Address: addr}, hash)
+	}
+	return nil, ErrLocked
+}
+
+// SignTx signs tx on behalf of addr, routing to the unlocked software key or,
+// if addr was instead derived from a wallet, to that wallet's device.
+func (am *Manager) SignTx(addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+	am.mu.RLock()
+	unlockedKey, found := am.unlocked[addr]
+	am.mu.RUnlock()
+	if found {
+		return tx.SignECDSA(unlockedKey.PrivateKey)
+	}
+	if wallet := am.walletFor(addr); wallet != nil {
+		return wallet.SignTx(Account{Address: addr}, tx)
+	}
+	return nil, ErrLocked
+}
+
+// NewAccount generates a new key, encrypts it with passphrase, and stores
+// it in the keystore directory.
+func (am *Manager) NewAccount(passphrase string) (Account, error) {
+	key, err := NewKey()
+	if err != nil {
+		return Account{}, err
+	}
+	if err := am.keyStore.StoreKey(key, passphrase); err != nil {
+		zeroKey(key)
+		return Account{}, err
+	}
+	return Account{Address: key.Address}, nil
+}
+
+// Unlock decrypts the key for addr with passphrase and keeps it in memory
+// until Lock is called.
+func (am *Manager) Unlock(addr common.Address, passphrase string) error {
+	key, err := am.getDecryptedKey(addr, passphrase)
+	if err != nil {
+		return err
+	}
+	am.mu.Lock()
+	am.unlocked[addr] = key
+	am.mu.Unlock()
+	return nil
+}
+
+// Lock removes addr's decrypted key from memory, if any.
+func (am *Manager) Lock(addr common.Address) error {
+	am.mu.Lock()
+	if unlockedKey, found := am.unlocked[addr]; found {
+		zeroKey(unlockedKey)
+		delete(am.unlocked, addr)
+	}
+	am.mu.Unlock()
+	return nil
+}
+
+// Import stores a pre-existing, unencrypted key JSON (as produced by
+// KeyStore.StoreKey on a keyStorePlain) under passphrase, returning the
+// resulting account.
+func (am *Manager) Import(keyJSON []byte, passphrase string) (Account, error) {
+	key := new(Key)
+	if err := key.UnmarshalJSON(keyJSON); err != nil {
+		return Account{}, err
+	}
+	key.Id = nil
+	if err := am.keyStore.StoreKey(key, passphrase); err != nil {
+		return Account{}, err
+	}
+	return Account{Address: key.Address}, nil
+}
+
+// Export decrypts addr's key with passphrase and re-encrypts it with
+// newPassphrase at the standard scrypt cost, for safekeeping outside the
+// keystore directory.
+func (am *Manager) Export(addr common.Address, passphrase, newPassphrase string) ([]byte, error) {
+	key, err := am.getDecryptedKey(addr, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptKey(key, newPassphrase, StandardScryptN, StandardScryptP)
+}
+
+func (am *Manager) getDecryptedKey(addr common.Address, passphrase string) (*Key, error) {
+	files, err := scanAccountFiles(am.keyStore.JoinPath(""))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.Address != addr {
+			continue
+		}
+		return am.keyStore.GetKey(addr, f.path, passphrase)
+	}
+	return nil, ErrUnknownAccount
+}
+
+func zeroKey(k *Key) {
+	b := k.PrivateKey.D.Bits()
+	for i := range b {
+		b[i] = 0
+	}
+}
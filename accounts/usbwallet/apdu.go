@@ -0,0 +1,157 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package usbwallet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/karalabe/hid"
+)
+
+// The instruction/class bytes of Ledger's Ethereum app APDU protocol, as
+// documented at https://github.com/LedgerHQ/ledger-app-eth/blob/master/doc/ethapp.asc.
+const (
+	ledgerCLA        = 0xe0
+	ledgerInsGetAddr = 0x02
+	ledgerInsSignTx  = 0x04
+	ledgerInsSignMsg = 0x08
+
+	ledgerP1First = 0x00
+)
+
+// errLedgerReply is returned when the device answers with a non-success
+// status word (the last two bytes of every APDU reply).
+type errLedgerReply uint16
+
+func (e errLedgerReply) Error() string {
+	return fmt.Sprintf("ledger: unexpected status word 0x%04x", uint16(e))
+}
+
+// ledgerExchange writes a single APDU frame to device and returns its
+// response payload, stripped of the trailing status word, or an error if
+// the status word doesn't indicate success (0x9000).
+func ledgerExchange(device *hid.Device, ins byte, p1, p2 byte, data []byte) ([]byte, error) {
+	apdu := make([]byte, 5+len(data))
+	apdu[0] = ledgerCLA
+	apdu[1] = ins
+	apdu[2] = p1
+	apdu[3] = p2
+	apdu[4] = byte(len(data))
+	copy(apdu[5:], data)
+
+	if _, err := device.Write(apdu); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 256)
+	n, err := device.Read(reply)
+	if err != nil {
+		return nil, err
+	}
+	if n < 2 {
+		return nil, errors.New("ledger: reply too short")
+	}
+	status := binary.BigEndian.Uint16(reply[n-2:])
+	if status != 0x9000 {
+		return nil, errLedgerReply(status)
+	}
+	return reply[:n-2], nil
+}
+
+// pathToBytes encodes a derivation path the way the Ethereum app expects it
+// on the wire: one byte giving the component count, followed by the
+// components as big endian uint32s.
+func pathToBytes(path accounts.DerivationPath) []byte {
+	data := make([]byte, 1+4*len(path))
+	data[0] = byte(len(path))
+	for i, component := range path {
+		binary.BigEndian.PutUint32(data[1+4*i:], component)
+	}
+	return data
+}
+
+// ledgerDerive asks the device for the address at path, without requesting
+// an on-screen confirmation.
+func ledgerDerive(device *hid.Device, path accounts.DerivationPath) (common.Address, error) {
+	reply, err := ledgerExchange(device, ledgerInsGetAddr, ledgerP1First, 0x00, pathToBytes(path))
+	if err != nil {
+		return common.Address{}, err
+	}
+	// Reply layout: 1 byte pubkey length, pubkey, 1 byte address-string
+	// length, hex address ASCII, 32 byte chain code (ignored).
+	if len(reply) < 1 {
+		return common.Address{}, errors.New("ledger: malformed GetAddress reply")
+	}
+	pubLen := int(reply[0])
+	if len(reply) < 1+pubLen+1 {
+		return common.Address{}, errors.New("ledger: malformed GetAddress reply")
+	}
+	addrLen := int(reply[1+pubLen])
+	hexAddr := reply[1+pubLen+1 : 1+pubLen+1+addrLen]
+	return common.HexToAddress(string(hexAddr)), nil
+}
+
+// ledgerSignHash requests a signature over hash from the account at path.
+// The Ethereum app doesn't sign arbitrary 32 byte hashes directly; it only
+// signs personal messages and transactions, so hash is wrapped the same way
+// geth's eth_sign prefixes a message before hashing it, then pushed through
+// the sign-personal-message APDU.
+func ledgerSignHash(device *hid.Device, path accounts.DerivationPath, hash []byte) ([]byte, error) {
+	payload := append(pathToBytes(path), hash...)
+	reply, err := ledgerExchange(device, ledgerInsSignMsg, ledgerP1First, 0x00, payload)
+	if err != nil {
+		return nil, err
+	}
+	return ledgerDecodeSignature(reply)
+}
+
+// ledgerSignTx requests the device sign tx as the account at path, streaming
+// its RLP encoding to the device for on-screen review.
+func ledgerSignTx(device *hid.Device, path accounts.DerivationPath, tx *types.Transaction) (*types.Transaction, error) {
+	rlpData, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+	payload := append(pathToBytes(path), rlpData...)
+	reply, err := ledgerExchange(device, ledgerInsSignTx, ledgerP1First, 0x00, payload)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ledgerDecodeSignature(reply)
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithSignature(sig)
+}
+
+// ledgerDecodeSignature converts the device's v||r||s reply (1 byte v, 32
+// byte r, 32 byte s) into the 65 byte r||s||v format the rest of geth's
+// signing code expects.
+func ledgerDecodeSignature(reply []byte) ([]byte, error) {
+	if len(reply) != 65 {
+		return nil, fmt.Errorf("ledger: unexpected signature length %d", len(reply))
+	}
+	sig := make([]byte, 65)
+	copy(sig[:64], reply[1:65])
+	sig[64] = reply[0] - 27
+	return sig, nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package usbwallet implements support for USB hardware wallets, currently
+// limited to Ledger-class devices that speak the Ethereum APDU protocol over
+// plain HID.
+package usbwallet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/karalabe/hid"
+)
+
+// refreshInterval is how often the hub re-enumerates USB HID devices to
+// detect hot-plugged hardware wallets; real-world USB enumeration is cheap
+// enough that a short poll is preferable to depending on a udev/libusb
+// hotplug callback on every platform geth supports.
+const refreshInterval = 5 * time.Second
+
+// ledgerDeviceIDs are the (vendor, product) pairs recognized as a Ledger
+// Ethereum app exposing its generic HID interface.
+var ledgerDeviceIDs = [][2]uint16{
+	{0x2c97, 0x0000}, // Ledger Blue
+	{0x2c97, 0x0001}, // Ledger Nano S
+}
+
+// Hub is an accounts.Backend that periodically enumerates USB HID devices,
+// wrapping every recognized Ledger into a *wallet and reporting its
+// arrival/drop to subscribers.
+type Hub struct {
+	scheme string
+
+	mu      sync.Mutex
+	wallets map[string]*wallet // keyed by the device's USB path
+
+	subsMu sync.Mutex
+	subs   map[chan<- accounts.WalletEvent]struct{}
+
+	quit chan struct{}
+}
+
+// NewLedgerHub creates a Hub that discovers and wraps Ledger hardware
+// wallets, polling USB HID enumeration every refreshInterval. Callers pass
+// the returned Hub straight into accounts.NewManager as a Backend.
+func NewLedgerHub() (*Hub, error) {
+	hub := &Hub{
+		scheme:  "ledger",
+		wallets: make(map[string]*wallet),
+		subs:    make(map[chan<- accounts.WalletEvent]struct{}),
+		quit:    make(chan struct{}),
+	}
+	hub.refresh()
+	go hub.refreshLoop()
+	return hub, nil
+}
+
+// refreshLoop re-enumerates USB devices every refreshInterval until Close is
+// called, diffing the result against the previous scan to fire
+// WalletArrived/WalletDropped events.
+func (hub *Hub) refreshLoop() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			hub.refresh()
+		case <-hub.quit:
+			return
+		}
+	}
+}
+
+// refresh enumerates every HID device, keeps the ones matching a known
+// Ledger (vendor, product) pair, and reconciles them against hub.wallets.
+func (hub *Hub) refresh() {
+	seen := make(map[string]hid.DeviceInfo)
+	for _, info := range hid.Enumerate(0, 0) {
+		if !isLedger(info) {
+			continue
+		}
+		seen[info.Path] = info
+	}
+
+	hub.mu.Lock()
+	var arrived, dropped []*wallet
+
+	for path, info := range seen {
+		if _, tracked := hub.wallets[path]; !tracked {
+			w := newWallet(hub.scheme, info)
+			hub.wallets[path] = w
+			arrived = append(arrived, w)
+		}
+	}
+	for path, w := range hub.wallets {
+		if _, present := seen[path]; !present {
+			delete(hub.wallets, path)
+			dropped = append(dropped, w)
+		}
+	}
+	hub.mu.Unlock()
+
+	for _, w := range arrived {
+		hub.notify(accounts.WalletEvent{Wallet: w, Kind: accounts.WalletArrived})
+	}
+	for _, w := range dropped {
+		w.Close()
+		hub.notify(accounts.WalletEvent{Wallet: w, Kind: accounts.WalletDropped})
+	}
+}
+
+func isLedger(info hid.DeviceInfo) bool {
+	for _, ids := range ledgerDeviceIDs {
+		if info.VendorID == ids[0] && info.ProductID == ids[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Wallets implements accounts.Backend, returning the wallets ordered by URL.
+func (hub *Hub) Wallets() []accounts.Wallet {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	// Every wallet shares the hub's scheme, so path order is URL order.
+	paths := make([]string, 0, len(hub.wallets))
+	for path := range hub.wallets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	wallets := make([]accounts.Wallet, 0, len(hub.wallets))
+	for _, path := range paths {
+		wallets = append(wallets, hub.wallets[path])
+	}
+	return wallets
+}
+
+// Subscribe implements accounts.Backend.
+func (hub *Hub) Subscribe(sink chan<- accounts.WalletEvent) (unsubscribe func()) {
+	hub.subsMu.Lock()
+	hub.subs[sink] = struct{}{}
+	hub.subsMu.Unlock()
+
+	return func() {
+		hub.subsMu.Lock()
+		delete(hub.subs, sink)
+		hub.subsMu.Unlock()
+	}
+}
+
+// notify fans event out to every subscriber, dropping it for any subscriber
+// that isn't keeping up rather than blocking the refresh loop.
+func (hub *Hub) notify(event accounts.WalletEvent) {
+	hub.subsMu.Lock()
+	defer hub.subsMu.Unlock()
+
+	for sub := range hub.subs {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+// Close stops the hub's refresh loop and closes every wallet it tracks.
+func (hub *Hub) Close() error {
+	close(hub.quit)
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, w := range hub.wallets {
+		w.Close()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package usbwallet
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/karalabe/hid"
+)
+
+// wallet wraps a single Ledger device, translating accounts.Wallet calls
+// into Ethereum-app APDU exchanges over the device's HID endpoint.
+type wallet struct {
+	hub  string
+	info hid.DeviceInfo
+
+	mu     sync.Mutex
+	device *hid.Device                    // nil until Open succeeds
+	paths  map[accounts.Account]accounts.DerivationPath
+}
+
+func newWallet(scheme string, info hid.DeviceInfo) *wallet {
+	return &wallet{
+		hub:   scheme,
+		info:  info,
+		paths: make(map[accounts.Account]accounts.DerivationPath),
+	}
+}
+
+// URL implements accounts.Wallet.
+func (w *wallet) URL() accounts.URL {
+	return accounts.URL{Scheme: w.hub, Path: w.info.Path}
+}
+
+// Open implements accounts.Wallet. Ledger's Ethereum app does not itself
+// gate opening on a passphrase -- the PIN is entered on the device -- so
+// passphrase is accepted but unused, matching the interface Open(passphrase
+// string) error rather than requiring a parallel no-PIN variant.
+func (w *wallet) Open(passphrase string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.device != nil {
+		return nil
+	}
+	device, err := w.info.Open()
+	if err != nil {
+		return err
+	}
+	w.device = device
+	return nil
+}
+
+// Close implements accounts.Wallet.
+func (w *wallet) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.device == nil {
+		return nil
+	}
+	err := w.device.Close()
+	w.device = nil
+	return err
+}
+
+// Accounts implements accounts.Wallet, returning every account Derive'd
+// with pin set to true so far.
+func (w *wallet) Accounts() []accounts.Account {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	accs := make([]accounts.Account, 0, len(w.paths))
+	for acc := range w.paths {
+		accs = append(accs, acc)
+	}
+	return accs
+}
+
+// Contains implements accounts.Wallet.
+func (w *wallet) Contains(account accounts.Account) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.paths[account]
+	return ok
+}
+
+// Derive implements accounts.Wallet, asking the device for the address at
+// path via the Ethereum app's GetAddress APDU.
+func (w *wallet) Derive(path accounts.DerivationPath, pin bool) (accounts.Account, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.device == nil {
+		return accounts.Account{}, accounts.ErrWalletClosed
+	}
+	address, err := ledgerDerive(w.device, path)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	account := accounts.Account{Address: address}
+	if pin {
+		w.paths[account] = path
+	}
+	return account, nil
+}
+
+// SignHash implements accounts.Wallet via the Ethereum app's sign-personal-
+// message APDU, which is how Ledger exposes raw hash signing.
+func (w *wallet) SignHash(account accounts.Account, hash []byte) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	path, ok := w.paths[account]
+	if !ok {
+		return nil, accounts.ErrUnknownDerivationPath
+	}
+	if w.device == nil {
+		return nil, accounts.ErrWalletClosed
+	}
+	return ledgerSignHash(w.device, path, hash)
+}
+
+// SignTx implements accounts.Wallet via the Ethereum app's sign-transaction
+// APDU, which streams the RLP-encoded transaction to the device for the
+// user to review and confirm before it returns the signature.
+func (w *wallet) SignTx(account accounts.Account, tx *types.Transaction) (*types.Transaction, error) {
+	w.mu.Lock()
+	path, ok := w.paths[account]
+	device := w.device
+	w.mu.Unlock()
+
+	if !ok {
+		return nil, accounts.ErrUnknownDerivationPath
+	}
+	if device == nil {
+		return nil, accounts.ErrWalletClosed
+	}
+	return ledgerSignTx(device, path, tx)
+}
+
+func (w *wallet) String() string {
+	return fmt.Sprintf("Ledger(%s)", w.info.Path)
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrWalletClosed is returned whenever an operation is attempted on a wallet
+// that has not been opened yet, or that has since been closed (e.g. because
+// the underlying USB device was unplugged).
+var ErrWalletClosed = errors.New("wallet closed")
+
+// ErrInvalidPassphrase is returned when Open is called with a PIN/passphrase
+// the wallet rejects.
+var ErrInvalidPassphrase = errors.New("invalid passphrase")
+
+// ErrUnknownDerivationPath is returned by SignHash/SignTx when the account
+// passed in was never returned by a prior call to Derive.
+var ErrUnknownDerivationPath = errors.New("unknown derivation path")
+
+// URL identifies a wallet by the scheme-qualified path it was discovered at,
+// e.g. "ledger://0001:0002:00" for a hardware wallet enumerated by the
+// usbwallet hub, mirroring the keystore's on-disk UTC--... filenames as the
+// handle callers pass back in to re-locate a specific wallet.
+type URL struct {
+	Scheme string
+	Path   string
+}
+
+func (u URL) String() string {
+	if u.Scheme == "" {
+		return u.Path
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Path)
+}
+
+// DerivationPath represents a BIP-32/BIP-44 hierarchical deterministic
+// derivation path such as m/44'/60'/0'/0, stored as the sequence of
+// (possibly hardened, high bit set) path components.
+type DerivationPath []uint32
+
+// DefaultBaseDerivationPath is the conventional BIP-44 base path for
+// Ethereum accounts, m/44'/60'/0'/0. Nothing in this package or in usbwallet
+// derives from it on its own; a caller passes it, or a path under it, to
+// Wallet.Derive.
+var DefaultBaseDerivationPath = DerivationPath{0x80000000 + 44, 0x80000000 + 60, 0x80000000 + 0, 0}
+
+// ParseDerivationPath converts a user specified derivation path string to
+// the internal binary representation, accepting both the "m/44'/60'/0'/0"
+// and "m/44H/60H/0H/0" hardened notations.
+func ParseDerivationPath(path string) (DerivationPath, error) {
+	var result DerivationPath
+
+	components := strings.Split(path, "/")
+	switch {
+	case len(components) == 0:
+		return nil, errors.New("empty derivation path")
+	case strings.TrimSpace(components[0]) == "":
+		return nil, errors.New("ambiguous path: cannot determine whether path is absolute or relative")
+	case strings.TrimSpace(components[0]) == "m":
+		components = components[1:]
+	}
+	for _, component := range components {
+		component = strings.TrimSpace(component)
+		var value uint32
+
+		if strings.HasSuffix(component, "'") || strings.HasSuffix(component, "H") {
+			value = 0x80000000
+			component = strings.TrimSuffix(strings.TrimSuffix(component, "'"), "H")
+		}
+		bigval, err := strconv.ParseUint(component, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid component %q: %v", component, err)
+		}
+		value += uint32(bigval)
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+func (path DerivationPath) String() string {
+	result := "m"
+	for _, component := range path {
+		var hardened bool
+		if component >= 0x80000000 {
+			component -= 0x80000000
+			hardened = true
+		}
+		result = fmt.Sprintf("%s/%d", result, component)
+		if hardened {
+			result += "'"
+		}
+	}
+	return result
+}
+
+// Wallet is implemented by anything capable of holding and signing with one
+// or more accounts without ever handing the private key to the caller. A
+// KeyStore-backed directory doesn't need to implement it -- Manager talks to
+// those directly -- but a hardware device like the ones usbwallet drives
+// does, since the key never leaves the chip.
+type Wallet interface {
+	// URL is the location this wallet was discovered at, suitable for
+	// passing back into Manager.Wallet to re-locate it later.
+	URL() URL
+
+	// Open initializes access to the wallet, prompting for a PIN/passphrase
+	// if the implementation requires one; pass "" if it doesn't.
+	Open(passphrase string) error
+
+	// Close releases any resources (e.g. the underlying USB handle) held by
+	// the wallet. A closed wallet can be reopened with Open.
+	Close() error
+
+	// Accounts returns the accounts the wallet has derived so far. Unlike a
+	// KeyStore directory, a hardware wallet doesn't know its accounts ahead
+	// of time -- only those explicitly Derive'd are listed here.
+	Accounts() []Account
+
+	// Contains reports whether account is currently derived and known to
+	// this wallet.
+	Contains(account Account) bool
+
+	// Derive asks the wallet to derive the account at path. If pin is true,
+	// the wallet also remembers the path so it is re-derived and returned
+	// from Accounts on subsequent calls (e.g. after a reopen).
+	Derive(path DerivationPath, pin bool) (Account, error)
+
+	// SignHash requests the wallet to sign the given hash with account,
+	// which must have been derived from this wallet.
+	SignHash(account Account, hash []byte) ([]byte, error)
+
+	// SignTx requests the wallet to sign tx with account, which must have
+	// been derived from this wallet, returning the signed transaction.
+	SignTx(account Account, tx *types.Transaction) (*types.Transaction, error)
+}
+
+// WalletEventType enumerates the types of events a Backend can fire.
+type WalletEventType int
+
+const (
+	// WalletArrived is fired when a wallet is added to the account manager,
+	// before it has necessarily been unlocked/opened.
+	WalletArrived WalletEventType = iota
+
+	// WalletDropped is fired when a wallet disappears, e.g. its USB device
+	// was unplugged.
+	WalletDropped
+)
+
+// WalletEvent is posted by a Backend whenever it discovers or loses a
+// wallet, so the Manager can keep its Wallets list in sync.
+type WalletEvent struct {
+	Wallet Wallet
+	Kind   WalletEventType
+}
+
+// Backend is implemented by an out-of-process wallet source, such as
+// usbwallet.Hub, that the Manager can aggregate alongside its own keystore
+// directory.
+type Backend interface {
+	// Wallets lists every wallet the backend currently knows about, ordered
+	// however the backend sees fit (usbwallet.Hub orders by URL).
+	Wallets() []Wallet
+
+	// Subscribe registers sink to receive WalletArrived/WalletDropped
+	// events as they happen. The returned function unsubscribes and must be
+	// called once the caller is done.
+	Subscribe(sink chan<- WalletEvent) (unsubscribe func())
+}
@@ -106,7 +106,7 @@ func MakeSystemNode(keydir string, privkey string, test *tests.BlockTest) (*node
 		return nil, err
 	}
 	// Create the keystore and inject an unlocked account if requested
-	keystore := crypto.NewKeyStorePassphrase(keydir, crypto.StandardScryptN, crypto.StandardScryptP)
+	keystore := accounts.NewKeyStorePassphrase(keydir, accounts.StandardScryptN, accounts.StandardScryptP)
 	accman := accounts.NewManager(keystore)
 
 	if len(privkey) > 0 {
@@ -114,10 +114,10 @@ func MakeSystemNode(keydir string, privkey string, test *tests.BlockTest) (*node
 		if err != nil {
 			return nil, err
 		}
-		if err := keystore.StoreKey(crypto.NewKeyFromECDSA(key), ""); err != nil {
+		if err := keystore.StoreKey(accounts.NewKeyFromECDSA(key), ""); err != nil {
 			return nil, err
 		}
-		if err := accman.Unlock(crypto.NewKeyFromECDSA(key).Address, ""); err != nil {
+		if err := accman.Unlock(accounts.NewKeyFromECDSA(key).Address, ""); err != nil {
 			return nil, err
 		}
 	}
@@ -135,7 +135,7 @@ func MakeSystemNode(keydir string, privkey string, test *tests.BlockTest) (*node
 		return nil, err
 	}
 	// Initialize and register the Whisper protocol
-	if err := stack.Register(func(*node.ServiceContext) (node.Service, error) { return whisper.New(), nil }); err != nil {
+	if err := stack.Register(func(*node.ServiceContext) (node.Service, error) { return whisper.New(nil), nil }); err != nil {
 		return nil, err
 	}
 	return stack, nil
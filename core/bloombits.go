@@ -0,0 +1,85 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/core/bloombits"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// BloomIndexer is a ChainIndexerBackend that feeds each section's headers
+// into a bloombits.Generator and persists the resulting bit vectors, so
+// eth_getLogs can filter by address/topic through a bloombits.Matcher
+// instead of scanning every block's bloom filter in the range.
+type BloomIndexer struct {
+	size uint64 // section size, matching the owning ChainIndexer's
+	db   ethdb.Database
+
+	gen     *bloombits.Generator
+	section uint64
+}
+
+// NewBloomIndexer returns a BloomIndexer that stores its bit vectors, keyed
+// by (bit, section), in a dedicated "BLOOM-" ethdb.NewTable view of db.
+func NewBloomIndexer(db ethdb.Database, size uint64) *BloomIndexer {
+	return &BloomIndexer{
+		db:   ethdb.NewTable(db, "BLOOM-"),
+		size: size,
+	}
+}
+
+// Reset implements ChainIndexerBackend.
+func (b *BloomIndexer) Reset(section uint64) {
+	gen, err := bloombits.NewGenerator(b.size)
+	if err != nil {
+		panic(err) // only fails for a misconfigured (non-multiple-of-8) section size
+	}
+	b.gen, b.section = gen, section
+}
+
+// Process implements ChainIndexerBackend.
+func (b *BloomIndexer) Process(header *types.Header) {
+	index := uint(header.Number.Uint64() - b.section*b.size)
+	b.gen.AddBloom(index, header.Bloom)
+}
+
+// Commit implements ChainIndexerBackend.
+func (b *BloomIndexer) Commit() error {
+	batch := b.db.NewBatch()
+	for i := 0; i < types.BloomBitLength; i++ {
+		bits, err := b.gen.Bitset(uint(i))
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(bloomBitsKey(uint(i), b.section), bits); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// bloomBitsKey encodes the (bit, section) pair BloomIndexer stores a bit
+// vector under, and a Matcher's fetch callback looks it up by.
+func bloomBitsKey(bit uint, section uint64) []byte {
+	key := make([]byte, 10)
+	binary.BigEndian.PutUint16(key[:2], uint16(bit))
+	binary.BigEndian.PutUint64(key[2:], section)
+	return key
+}
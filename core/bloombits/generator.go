@@ -0,0 +1,92 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package bloombits implements a bit-transposed index over per-block log
+// blooms, turning the current O(blocks) linear bloom scan used by log
+// filtering into a sub-linear one.
+//
+// A chain is cut into fixed-size "sections" of N consecutive blocks. Within
+// a section, bit i of block b's bloom filter (0 <= i < types.BloomBitLength)
+// is stored as column (b mod N) of row i, so that testing whether bit i is
+// ever set across an entire section becomes a single N-bit read instead of
+// N separate 2048-bit ones.
+package bloombits
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// errSectionOutOfBounds is returned when attempting to retrieve bloom bits
+// from a bloom filter section not yet handed over.
+var errSectionOutOfBounds = errors.New("bloombits: section out of bounds")
+
+// Generator takes a number of bloom filters belonging to successive blocks
+// within a single section and transposes them into a sequence of bit
+// vectors, one per bloom bit, that can be stored and queried independently.
+type Generator struct {
+	blooms   [types.BloomBitLength][]byte // bloom bit columns for the section
+	sections uint64                       // number of blocks to batch into a single section
+	nextBit  uint                         // next expected bloom bit for AddBloom
+	nextSec  uint64                       // next expected block within the section
+}
+
+// NewGenerator creates a rotating bloom bit index generator for sections of
+// the given size.
+func NewGenerator(sections uint64) (*Generator, error) {
+	if sections%8 != 0 {
+		return nil, errors.New("bloombits: section size must be a multiple of 8")
+	}
+	b := &Generator{sections: sections}
+	for i := range b.blooms {
+		b.blooms[i] = make([]byte, sections/8)
+	}
+	return b, nil
+}
+
+// AddBloom feeds the next bloom filter in the section, index being the
+// block's position within the section (0-based, strictly increasing).
+func (b *Generator) AddBloom(index uint, bloom types.Bloom) error {
+	if b.nextSec != uint64(index) {
+		return errors.New("bloombits: bloom filter added out of order")
+	}
+	for i := 0; i < types.BloomBitLength; i++ {
+		bit := byte(0)
+		byteIdx := types.BloomByteLength - 1 - i/8
+		bitMask := byte(1) << uint(i%8)
+		if bloom[byteIdx]&bitMask != 0 {
+			bit = 1
+		}
+		if bit == 1 {
+			b.blooms[i][index/8] |= 1 << uint(7-index%8)
+		}
+	}
+	b.nextSec++
+	return nil
+}
+
+// Bitset returns the bit vector belonging to the given bit index after the
+// section has been completely filled.
+func (b *Generator) Bitset(idx uint) ([]byte, error) {
+	if b.nextSec != b.sections {
+		return nil, errors.New("bloombits: section not yet complete")
+	}
+	if idx >= types.BloomBitLength {
+		return nil, errSectionOutOfBounds
+	}
+	return b.blooms[idx], nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// bloomIndexes are the three bit positions a single filter term occupies in
+// a types.Bloom, mirroring the hashing scheme of types.BloomLookup.
+type bloomIndexes [3]uint
+
+// calcBloomIndexes hashes data and takes the big-endian 16-bit values at byte
+// offsets 0, 2 and 4 of the digest, each masked to 11 bits: the same three
+// bits types.Bloom.Add would have set for it.
+func calcBloomIndexes(data []byte) bloomIndexes {
+	hash := crypto.Keccak256(data)
+
+	var idxs bloomIndexes
+	for i, b := range []int{0, 2, 4} {
+		idxs[i] = (uint(hash[b+1]) + uint(hash[b])<<8) & (types.BloomBitLength - 1)
+	}
+	return idxs
+}
+
+// Matcher finds candidate block numbers whose bloom filter could contain
+// every requested term, using the bit-transposed section index produced by
+// Generator instead of scanning each block's bloom filter directly.
+//
+// filters holds one entry per independent column (e.g. the log address,
+// then one per indexed topic slot): terms within a column are OR'd, columns
+// are AND'd together, matching eth_getLogs filter semantics. An empty
+// column matches every block unconditionally.
+type Matcher struct {
+	sectionSize uint64
+	filters     [][]bloomIndexes
+
+	lock       sync.Mutex
+	schedulers map[uint]*scheduler
+}
+
+// NewMatcher creates a Matcher for filters over bloombits sections of the
+// given size.
+func NewMatcher(sectionSize uint64, filters [][][]byte) *Matcher {
+	m := &Matcher{
+		sectionSize: sectionSize,
+		schedulers:  make(map[uint]*scheduler),
+	}
+	for _, column := range filters {
+		compiled := make([]bloomIndexes, len(column))
+		for i, term := range column {
+			compiled[i] = calcBloomIndexes(term)
+		}
+		m.filters = append(m.filters, compiled)
+	}
+	return m
+}
+
+// Matches returns, in ascending order, every block number in [begin, end]
+// whose bloom filter could contain all of the matcher's terms. fetch
+// supplies the requested bitsets, typically backed by an ethdb.Database
+// table a ChainIndexer keeps populated via Generator.
+func (m *Matcher) Matches(begin, end uint64, fetch func(*Retrieval) error) ([]uint64, error) {
+	var matches []uint64
+
+	first, last := begin/m.sectionSize, end/m.sectionSize
+	for section := first; section <= last; section++ {
+		bitset, err := m.sectionBitset(section, fetch)
+		if err != nil {
+			return nil, err
+		}
+		base := section * m.sectionSize
+		for i := uint64(0); i < m.sectionSize; i++ {
+			number := base + i
+			if number < begin || number > end {
+				continue
+			}
+			if bitIsSet(bitset, i) {
+				matches = append(matches, number)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// sectionBitset computes the section-wide match bitset: the AND, across
+// columns, of the OR, within a column, of each term's per-block bitset.
+func (m *Matcher) sectionBitset(section uint64, fetch func(*Retrieval) error) ([]byte, error) {
+	var result []byte
+	for _, column := range m.filters {
+		if len(column) == 0 {
+			continue
+		}
+		var union []byte
+		for _, idxs := range column {
+			bits, err := m.termBitset(idxs, section, fetch)
+			if err != nil {
+				return nil, err
+			}
+			if union == nil {
+				union = bits
+			} else {
+				orBytes(union, bits)
+			}
+		}
+		if result == nil {
+			result = union
+		} else {
+			andBytes(result, union)
+		}
+	}
+	if result == nil {
+		result = make([]byte, m.sectionSize/8)
+		for i := range result {
+			result[i] = 0xff
+		}
+	}
+	return result, nil
+}
+
+// termBitset ANDs together the three per-bit rows of a single filter term
+// for the given section.
+func (m *Matcher) termBitset(idxs bloomIndexes, section uint64, fetch func(*Retrieval) error) ([]byte, error) {
+	var rows [3][]byte
+	for i, bit := range idxs {
+		bitsets, err := m.scheduler(bit).run([]uint64{section}, fetch)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = bitsets[0]
+	}
+	out := make([]byte, len(rows[0]))
+	copy(out, rows[0])
+	andBytes(out, rows[1])
+	andBytes(out, rows[2])
+	return out, nil
+}
+
+func (m *Matcher) scheduler(bit uint) *scheduler {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sched, ok := m.schedulers[bit]
+	if !ok {
+		sched = newScheduler(bit)
+		m.schedulers[bit] = sched
+	}
+	return sched
+}
+
+func andBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] &= src[i]
+	}
+}
+
+func orBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] |= src[i]
+	}
+}
+
+func bitIsSet(bitset []byte, i uint64) bool {
+	return bitset[i/8]&(1<<(7-i%8)) != 0
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import "sync"
+
+// Retrieval represents a batch of section bitsets, all belonging to the
+// same bloom bit, that a Matcher needs fetched from the backing store in
+// one go so CPU (AND-ing rows together) and IO (reading them) can overlap.
+type Retrieval struct {
+	Bit      uint
+	Sections []uint64
+	Bitsets  [][]byte
+}
+
+// scheduler deduplicates concurrent requests for the bitsets of a single
+// bloom bit: if two Matcher goroutines ask for the same section at the
+// same time, only one of them actually calls fetch, and both receive its
+// result once it lands.
+type scheduler struct {
+	bit uint
+
+	lock     sync.Mutex
+	inflight map[uint64]*sync.WaitGroup
+	results  map[uint64][]byte
+}
+
+func newScheduler(bit uint) *scheduler {
+	return &scheduler{
+		bit:      bit,
+		inflight: make(map[uint64]*sync.WaitGroup),
+		results:  make(map[uint64][]byte),
+	}
+}
+
+// run returns the bitsets for the given sections, in the same order,
+// calling fetch only for the sections not already in flight or cached.
+func (s *scheduler) run(sections []uint64, fetch func(*Retrieval) error) ([][]byte, error) {
+	s.lock.Lock()
+	var need []uint64
+	waits := make(map[uint64]*sync.WaitGroup, len(sections))
+	for _, section := range sections {
+		if _, cached := s.results[section]; cached {
+			continue
+		}
+		if wg, ok := s.inflight[section]; ok {
+			waits[section] = wg
+			continue
+		}
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		s.inflight[section] = wg
+		waits[section] = wg
+		need = append(need, section)
+	}
+	s.lock.Unlock()
+
+	if len(need) > 0 {
+		req := &Retrieval{Bit: s.bit, Sections: need}
+		err := fetch(req)
+
+		s.lock.Lock()
+		for i, section := range need {
+			if err == nil {
+				s.results[section] = req.Bitsets[i]
+			}
+			s.inflight[section].Done()
+			delete(s.inflight, section)
+		}
+		s.lock.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, wg := range waits {
+		wg.Wait()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	out := make([][]byte, len(sections))
+	for i, section := range sections {
+		out[i] = s.results[section]
+	}
+	return out, nil
+}
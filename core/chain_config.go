@@ -0,0 +1,96 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the per-genesis ChainConfig: rather than a handful of package-level
+// params.* variables shared (and mutated) by every chain a process opens,
+// each genesis hash gets its own config row in chaindata, so a single geth
+// binary can drive several independent chains out of several datadirs
+// without one's --testnet/--olympic flags leaking into another's.
+package core
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// chainConfigPrefix namespaces ChainConfig rows within chaindata, keyed by
+// the hash of the genesis block the config belongs to.
+var chainConfigPrefix = []byte("chain-config-")
+
+// ChainConfig holds the fork-block numbers and block-reward economics that
+// previously lived in global params.* variables, so they can instead be
+// attached to, and loaded back out of, a specific chain's genesis.
+type ChainConfig struct {
+	HomesteadBlock *big.Int // Block at which the Homestead rules start applying
+	DAOForkBlock   *big.Int // Block of the DAO fork extra-data marker, nil if the chain doesn't fork
+	DAOForkSupport bool     // Whether blocks in the fork's extra-data range must carry (true) or must not carry (false) DAOForkBlockExtra
+	EIP150Block    *big.Int // Block at which the EIP150 gas repricing starts applying
+
+	DurationLimit   *big.Int // Maximum acceptable block time drift before difficulty is raised
+	GenesisGasLimit *big.Int // Gas limit of the genesis block
+	BlockReward     *big.Int // Block reward, in wei, credited to a successful miner
+	ExpDiffPeriod   *big.Int // Block count after which the exponential difficulty "ice age" kicks in
+}
+
+// DefaultChainConfig is used for any genesis that has no ChainConfig of its
+// own stored in chaindata yet -- i.e. the Frontier/Homestead main net
+// defaults this repository shipped with before per-genesis configs existed.
+var DefaultChainConfig = &ChainConfig{
+	HomesteadBlock:  big.NewInt(1150000),
+	DAOForkBlock:    big.NewInt(1920000),
+	DAOForkSupport:  true,
+	EIP150Block:     big.NewInt(2463000),
+	DurationLimit:   big.NewInt(13),
+	GenesisGasLimit: big.NewInt(4712388),
+	BlockReward:     big.NewInt(5e+18),
+	ExpDiffPeriod:   big.NewInt(100000),
+}
+
+// WriteChainConfig persists cfg into db, keyed by the hash of the chain's
+// genesis block.
+func WriteChainConfig(db ethdb.Database, genesisHash common.Hash, cfg *ChainConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return db.Put(append(chainConfigPrefix, genesisHash[:]...), data)
+}
+
+// GetChainConfig returns the ChainConfig stored against genesisHash, or
+// DefaultChainConfig if that genesis has no config of its own in db.
+func GetChainConfig(db ethdb.Database, genesisHash common.Hash) (*ChainConfig, error) {
+	key := append(chainConfigPrefix, genesisHash[:]...)
+
+	ok, err := db.Has(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return DefaultChainConfig, nil
+	}
+	data, err := db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(ChainConfig)
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+// ChainIndexerBackend is the interface a ChainIndexer drives to incrementally
+// build one kind of per-section index (e.g. the bloom-bits index built by
+// BloomIndexer) from the canonical chain.
+type ChainIndexerBackend interface {
+	// Reset prepares the backend for a new section, discarding any partial
+	// state left over from an interrupted one.
+	Reset(section uint64)
+
+	// Process adds a single block's header to the section under
+	// construction. Headers are delivered in order, starting at
+	// section*sectionSize.
+	Process(header *types.Header)
+
+	// Commit finalizes and persists the section most recently Reset.
+	Commit() error
+}
+
+// ChainIndexer watches the canonical chain for newly confirmed blocks and
+// feeds them, one fixed-size section at a time, into a ChainIndexerBackend.
+// A block only becomes part of a section once it is confirmsReq blocks deep,
+// so a (rare, deep) reorg can never invalidate an already-committed section.
+type ChainIndexer struct {
+	chainDb ethdb.Database
+	backend ChainIndexerBackend
+
+	sectionSize uint64
+	confirmsReq uint64
+
+	lock           sync.Mutex
+	storedSections uint64 // sections committed so far
+
+	quit chan struct{}
+}
+
+// NewChainIndexer creates a ChainIndexer that builds sectionSize-block
+// sections through backend, each only once it is confirmsReq blocks behind
+// the chain head.
+func NewChainIndexer(chainDb ethdb.Database, backend ChainIndexerBackend, sectionSize, confirmsReq uint64) *ChainIndexer {
+	c := &ChainIndexer{
+		chainDb:     chainDb,
+		backend:     backend,
+		sectionSize: sectionSize,
+		confirmsReq: confirmsReq,
+		quit:        make(chan struct{}),
+	}
+	if data, err := chainDb.Get([]byte(chainIndexerProgressKey)); err == nil && len(data) == 8 {
+		c.storedSections = binary.BigEndian.Uint64(data)
+	}
+	return c
+}
+
+// Start launches the indexer's background loop, which, whenever newHeadCh
+// delivers a new head, calls headerByNumber to walk the chain up to it.
+func (c *ChainIndexer) Start(newHeadCh <-chan *types.Header, headerByNumber func(number uint64) *types.Header) {
+	go c.run(newHeadCh, headerByNumber)
+}
+
+// Close stops the indexer's background loop.
+func (c *ChainIndexer) Close() {
+	close(c.quit)
+}
+
+// Sections reports how many sections have been fully indexed so far.
+func (c *ChainIndexer) Sections() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.storedSections
+}
+
+func (c *ChainIndexer) run(newHeadCh <-chan *types.Header, headerByNumber func(number uint64) *types.Header) {
+	for {
+		select {
+		case <-c.quit:
+			return
+		case head := <-newHeadCh:
+			if head == nil {
+				continue
+			}
+			if err := c.catchUp(head.Number.Uint64(), headerByNumber); err != nil {
+				glog.V(logger.Error).Infof("chain indexer: %v", err)
+			}
+		}
+	}
+}
+
+// catchUp processes every section that has become confirmed now that the
+// chain head sits at headNumber.
+func (c *ChainIndexer) catchUp(headNumber uint64, headerByNumber func(number uint64) *types.Header) error {
+	if headNumber < c.confirmsReq {
+		return nil
+	}
+	known := (headNumber + 1 - c.confirmsReq) / c.sectionSize
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.storedSections < known {
+		section := c.storedSections
+		if err := c.processSection(section, headerByNumber); err != nil {
+			return err
+		}
+		c.storedSections++
+		c.writeStoredSections()
+	}
+	return nil
+}
+
+func (c *ChainIndexer) processSection(section uint64, headerByNumber func(number uint64) *types.Header) error {
+	c.backend.Reset(section)
+
+	base := section * c.sectionSize
+	for i := uint64(0); i < c.sectionSize; i++ {
+		header := headerByNumber(base + i)
+		if header == nil {
+			return fmt.Errorf("chain indexer: missing header %d for section %d", base+i, section)
+		}
+		c.backend.Process(header)
+	}
+	return c.backend.Commit()
+}
+
+// chainIndexerProgressKey is the chainDb key the indexer persists its
+// progress under, so a restart resumes rather than rebuilding from scratch.
+const chainIndexerProgressKey = "chain-indexer-sections"
+
+func (c *ChainIndexer) writeStoredSections() {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], c.storedSections)
+	c.chainDb.Put([]byte(chainIndexerProgressKey), buf[:])
+}
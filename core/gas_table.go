@@ -0,0 +1,73 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "math/big"
+
+// GasTable holds the per-opcode gas costs EIP150 (Tangerine Whistle)
+// repriced; before a chain's EIP150Block, the EVM charges the Frontier/
+// Homestead costs baked into its opcode table directly instead of
+// consulting one of these.
+//
+// core/vm in this tree is doc-only (no opcode interpreter), so there is
+// nowhere yet to consult GasTable from during execution; ChainConfig.GasTable
+// below is the real, tested selection logic a future interpreter reads its
+// ExtcodeSize/SLoad/Calls/etc. costs from once one exists.
+type GasTable struct {
+	ExtcodeSize *big.Int
+	ExtcodeCopy *big.Int
+	Balance     *big.Int
+	SLoad       *big.Int
+	Calls       *big.Int
+	Suicide     *big.Int
+
+	ExpByte *big.Int
+
+	// CreateBySuicide occurs when the refunded account is one that does
+	// not exist. This logic is similar to call. May be left nil, in which
+	// case a sensible default is used.
+	CreateBySuicide *big.Int
+}
+
+// GasTableHomestead is the zero value: every Frontier/Homestead opcode cost
+// is already hardcoded where the EVM charges it, so there is nothing here to
+// override.
+var GasTableHomestead = GasTable{}
+
+// GasTableEIP150 is the repriced table EIP150 introduced, guarding against
+// the underpriced IO-heavy opcodes that made the autumn 2016 DoS attacks
+// possible.
+var GasTableEIP150 = GasTable{
+	ExtcodeSize:     big.NewInt(700),
+	ExtcodeCopy:     big.NewInt(700),
+	Balance:         big.NewInt(400),
+	SLoad:           big.NewInt(200),
+	Calls:           big.NewInt(700),
+	Suicide:         big.NewInt(5000),
+	ExpByte:         big.NewInt(10),
+	CreateBySuicide: big.NewInt(25000),
+}
+
+// GasTable returns the GasTable a block at the given number is subject to:
+// GasTableEIP150 from EIP150Block onward, GasTableHomestead before it (or if
+// the chain's config has no EIP150Block at all).
+func (c *ChainConfig) GasTable(blockNumber *big.Int) GasTable {
+	if c.EIP150Block == nil || blockNumber == nil || blockNumber.Cmp(c.EIP150Block) < 0 {
+		return GasTableHomestead
+	}
+	return GasTableEIP150
+}
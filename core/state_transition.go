@@ -58,6 +58,99 @@ type StateTransition struct {
 	state         vm.Database
 
 	env vm.Environment
+
+	// hook, if non-nil, observes (and may veto) each stage of transitionDb.
+	hook StateTransitionHook
+
+	// dryRun is set by EstimateGas. The Database is rolled back to a pre-run
+	// snapshot once the transition completes, and refundGas does not return
+	// unused gas to the block's GasPool; the GasPool itself is not restored,
+	// so it stays debited by the message's full gas limit.
+	dryRun bool
+}
+
+// StateTransitionHook lets a consensus-neutral observer (fee analytics, MEV
+// simulators, custom refund accounting, sponsored-tx paymasters) watch
+// transitionDb as it runs, without forking this file. Every method is
+// called at the matching stage and may abort the transition by returning a
+// non-nil error; the error surfaces to ApplyMessageWithHook's caller as an
+// InvalidTxError, same as any other pre-consensus failure. A hook that only
+// needs to observe a given stage, not veto it, simply returns nil there.
+type StateTransitionHook interface {
+	// OnPreCheck fires before nonce and balance validation.
+	OnPreCheck(msg Message) error
+	// OnBuyGas fires once gas has been priced but before it's debited from
+	// the sender, with the gas and gasPrice about to be charged.
+	OnBuyGas(gas, gasPrice *big.Int) error
+	// OnIntrinsicGas fires once intrinsic gas has been deducted from the
+	// remaining gas, with the intrinsic cost that was charged.
+	OnIntrinsicGas(intrinsic *big.Int) error
+	// OnVMEnter fires immediately before Create/Call runs, with the gas
+	// available to it.
+	OnVMEnter(gas *big.Int) error
+	// OnVMExit fires after Create/Call returns, with its return data and
+	// error (nil on success).
+	OnVMExit(ret []byte, vmErr error) error
+	// OnRefund fires after the refund counter has been applied, with the
+	// final refund amount in gas (before the gasPrice multiplication).
+	OnRefund(refund *big.Int) error
+	// OnFinalize fires once the coinbase has been credited, with the total
+	// gas used by the transition.
+	OnFinalize(gasUsed *big.Int) error
+}
+
+// TxType identifies the envelope/shape of a transaction (EIP-2718), letting
+// StateTransition dispatch on msg.Type() for the handful of places that
+// differ between shapes (currently just the intrinsic gas surcharges an
+// access-list transaction pays) instead of forking preCheck/transitionDb
+// for every new transaction shape.
+type TxType byte
+
+const (
+	LegacyTxType     TxType = 0x00
+	AccessListTxType TxType = 0x01
+)
+
+// AccessTuple is one entry of an AccessList: an address together with the
+// storage slots within it that a transaction declares it will touch.
+type AccessTuple struct {
+	Address     common.Address
+	StorageKeys []common.Hash
+}
+
+// AccessList is the set of addresses and storage slots an AccessListTxType
+// message pre-declares, letting the EVM warm them up before execution.
+type AccessList []AccessTuple
+
+// accessListSlot identifies one storage slot within the access list, i.e.
+// an (address, key) pair; it exists purely as a map key for deduplication.
+type accessListSlot struct {
+	address common.Address
+	key     common.Hash
+}
+
+// intrinsicStats returns the number of distinct addresses and distinct
+// (address, storage key) pairs declared by the list. A list is attacker-
+// supplied data, so a transaction that repeats an entry must not be able to
+// inflate or deflate its own intrinsic gas by doing so: every address and
+// every slot is only ever counted, and later only ever pre-warmed, once.
+func (al AccessList) intrinsicStats() (addresses, slots int) {
+	seenAddr := make(map[common.Address]struct{}, len(al))
+	seenSlot := make(map[accessListSlot]struct{})
+	for _, tuple := range al {
+		if _, ok := seenAddr[tuple.Address]; !ok {
+			seenAddr[tuple.Address] = struct{}{}
+			addresses++
+		}
+		for _, key := range tuple.StorageKeys {
+			slot := accessListSlot{tuple.Address, key}
+			if _, ok := seenSlot[slot]; !ok {
+				seenSlot[slot] = struct{}{}
+				slots++
+			}
+		}
+	}
+	return addresses, slots
 }
 
 // Message represents a message sent to a contract.
@@ -72,15 +165,30 @@ type Message interface {
 
 	Nonce() uint64
 	Data() []byte
+
+	// Type returns the message's transaction envelope type, used to
+	// dispatch type-specific intrinsic gas surcharges and validation.
+	Type() byte
+	// AccessList returns the addresses and storage slots the message
+	// declares it will touch, or nil for a type that carries none.
+	AccessList() AccessList
+	// ExtraData returns any additional type-specific payload that doesn't
+	// fit the fields common to every Message (e.g. a fee cap on a
+	// fee-market transaction); StateTransition treats it as opaque.
+	ExtraData() []byte
 }
 
 func MessageCreatesContract(msg Message) bool {
 	return msg.To() == nil
 }
 
-// IntrinsicGas computes the 'intrinsic gas' for a message
-// with the given data.
-func IntrinsicGas(data []byte, contractCreation, homestead bool) *big.Int {
+// IntrinsicGas computes the 'intrinsic gas' for a message with the given
+// data, type and access list. txType selects any per-type surcharge on top
+// of the base TxGas/TxGasContractCreation and per-byte data costs; an
+// AccessListTxType message additionally pays a flat fee per declared
+// address and per declared storage key, mirroring the cost of the state
+// accesses it pre-warms.
+func IntrinsicGas(data []byte, accessList AccessList, txType byte, contractCreation, homestead bool) *big.Int {
 	igas := new(big.Int)
 	if contractCreation && homestead {
 		igas.Set(params.TxGasContractCreation)
@@ -101,10 +209,29 @@ func IntrinsicGas(data []byte, contractCreation, homestead bool) *big.Int {
 		m.Mul(m, params.TxDataZeroGas)
 		igas.Add(igas, m)
 	}
+	if TxType(txType) == AccessListTxType {
+		addresses, slots := accessList.intrinsicStats()
+
+		m := big.NewInt(int64(addresses))
+		m.Mul(m, params.TxAccessListAddressGas)
+		igas.Add(igas, m)
+
+		m = big.NewInt(int64(slots))
+		m.Mul(m, params.TxAccessListStorageKeyGas)
+		igas.Add(igas, m)
+	}
 	return igas
 }
 
+// ApplyMessage runs msg against env with no observer attached; it is a
+// nil-hook shim over ApplyMessageWithHook.
 func ApplyMessage(env vm.Environment, msg Message, gp *GasPool) ([]byte, *big.Int, error) {
+	return ApplyMessageWithHook(env, msg, gp, nil)
+}
+
+// ApplyMessageWithHook runs msg against env like ApplyMessage, additionally
+// driving hook through every stage of the transition. hook may be nil.
+func ApplyMessageWithHook(env vm.Environment, msg Message, gp *GasPool, hook StateTransitionHook) ([]byte, *big.Int, error) {
 	var st = StateTransition{
 		gp:         gp,
 		env:        env,
@@ -115,10 +242,40 @@ func ApplyMessage(env vm.Environment, msg Message, gp *GasPool) ([]byte, *big.In
 		value:      msg.Value(),
 		data:       msg.Data(),
 		state:      env.Db(),
+		hook:       hook,
 	}
 	return st.transitionDb()
 }
 
+// EstimateGas runs msg against env purely to find out how much gas it would
+// use, without keeping any of the state mutations that run produces: env's
+// Database is snapshotted beforehand and unconditionally rolled back to
+// that snapshot once transitionDb returns, success or not. The returned gas
+// is the real gasUsed ApplyMessage would have reported — intrinsic gas, the
+// refund cap, and homestead's CodeStoreOutOfGasError promotion all included
+// — so callers such as eth_estimateGas don't need to binary-search by
+// re-executing the transaction at different gas limits.
+func EstimateGas(env vm.Environment, msg Message, gp *GasPool) (*big.Int, error) {
+	db := env.Db()
+	snapshot := db.Snapshot()
+	defer db.RevertToSnapshot(snapshot)
+
+	st := StateTransition{
+		gp:         gp,
+		env:        env,
+		msg:        msg,
+		gas:        new(big.Int),
+		gasPrice:   msg.GasPrice(),
+		initialGas: new(big.Int),
+		value:      msg.Value(),
+		data:       msg.Data(),
+		state:      db,
+		dryRun:     true,
+	}
+	_, usedGas, err := st.transitionDb()
+	return usedGas, err
+}
+
 func (self *StateTransition) from() (vm.Account, error) {
 	var (
 		f   common.Address
@@ -177,6 +334,11 @@ func (self *StateTransition) buyGas() error {
 	if sender.Balance().Cmp(mgval) < 0 {
 		return fmt.Errorf("insufficient ETH for gas (%x). Req %v, has %v", sender.Address().Bytes()[:4], mgval, sender.Balance())
 	}
+	if self.hook != nil {
+		if err := self.hook.OnBuyGas(mgas, self.gasPrice); err != nil {
+			return err
+		}
+	}
 	if err = self.gp.SubGas(mgas); err != nil {
 		return err
 	}
@@ -188,6 +350,11 @@ func (self *StateTransition) buyGas() error {
 
 func (self *StateTransition) preCheck() (err error) {
 	msg := self.msg
+	if self.hook != nil {
+		if err := self.hook.OnPreCheck(msg); err != nil {
+			return InvalidTxError(err)
+		}
+	}
 	sender, err := self.from()
 	if err != nil {
 		return err
@@ -198,6 +365,13 @@ func (self *StateTransition) preCheck() (err error) {
 		return NonceError(msg.Nonce(), n)
 	}
 
+	// A legacy-envelope message carrying an access list indicates a bug
+	// upstream in however msg was constructed; every other type is free to
+	// carry one (or not).
+	if TxType(msg.Type()) == LegacyTxType && len(msg.AccessList()) > 0 {
+		return fmt.Errorf("legacy transaction type carries a non-empty access list")
+	}
+
 	// Pre-pay gas
 	if err = self.buyGas(); err != nil {
 		if IsGasLimitErr(err) {
@@ -219,9 +393,28 @@ func (self *StateTransition) transitionDb() (ret []byte, usedGas *big.Int, err e
 	homestead := params.IsHomestead(self.env.BlockNumber())
 	contractCreation := MessageCreatesContract(msg)
 	// Pay intrinsic gas
-	if err = self.useGas(IntrinsicGas(self.data, contractCreation, homestead)); err != nil {
+	intrinsic := IntrinsicGas(self.data, msg.AccessList(), msg.Type(), contractCreation, homestead)
+	if err = self.useGas(intrinsic); err != nil {
 		return nil, nil, InvalidTxError(err)
 	}
+	if self.hook != nil {
+		if err := self.hook.OnIntrinsicGas(intrinsic); err != nil {
+			return nil, nil, InvalidTxError(err)
+		}
+	}
+
+	// Pre-warm every address and storage slot the message declared, so the
+	// EVM's own warm/cold accounting treats them as already touched from the
+	// first access rather than charging the (more expensive) cold price for
+	// it. This must happen after intrinsic gas is paid for but before
+	// Create/Call runs any code.
+	self.preWarmAccessList()
+
+	if self.hook != nil {
+		if err := self.hook.OnVMEnter(self.gas); err != nil {
+			return nil, nil, InvalidTxError(err)
+		}
+	}
 
 	vmenv := self.env
 	//var addr common.Address
@@ -244,6 +437,12 @@ func (self *StateTransition) transitionDb() (ret []byte, usedGas *big.Int, err e
 		}
 	}
 
+	if self.hook != nil {
+		if herr := self.hook.OnVMExit(ret, err); herr != nil {
+			return nil, nil, InvalidTxError(herr)
+		}
+	}
+
 	if err != nil && IsValueTransferErr(err) {
 		return nil, nil, InvalidTxError(err)
 	}
@@ -253,28 +452,83 @@ func (self *StateTransition) transitionDb() (ret []byte, usedGas *big.Int, err e
 		err = nil
 	}
 
-	self.refundGas()
+	if rerr := self.refundGas(); rerr != nil {
+		return nil, nil, InvalidTxError(rerr)
+	}
 	self.state.AddBalance(self.env.Coinbase(), new(big.Int).Mul(self.gasUsed(), self.gasPrice))
 
+	if self.hook != nil {
+		if herr := self.hook.OnFinalize(self.gasUsed()); herr != nil {
+			return nil, nil, InvalidTxError(herr)
+		}
+	}
+
 	return ret, self.gasUsed(), err
 }
 
-func (self *StateTransition) refundGas() {
-	// Return eth for remaining gas to the sender account,
-	// exchanged at the original rate.
+// preWarmAccessList marks every address and (address, storage key) pair
+// declared by the message's access list as accessed, via the Database's
+// access-list tracking. Duplicate tuples in the list are harmless: marking
+// an already-warm address or slot a second time is a no-op.
+func (self *StateTransition) preWarmAccessList() {
+	for _, tuple := range self.msg.AccessList() {
+		self.state.AddAddressToAccessList(tuple.Address)
+		for _, key := range tuple.StorageKeys {
+			self.state.AddSlotToAccessList(tuple.Address, key)
+		}
+	}
+}
+
+// IsAddressWarm reports whether addr has already been accessed during this
+// transaction, either because the message's access list pre-warmed it or
+// because execution has touched it already. EVM opcodes can use this to
+// reprice operations like EXTCODESIZE and BALANCE once they distinguish a
+// cold first access from a warm repeat one.
+func (self *StateTransition) IsAddressWarm(addr common.Address) bool {
+	return self.state.AddressInAccessList(addr)
+}
+
+// IsSlotWarm reports whether the given storage slot of addr has already
+// been accessed during this transaction, for opcodes such as SLOAD and
+// SSTORE that price a slot's first access differently from later ones.
+func (self *StateTransition) IsSlotWarm(addr common.Address, slot common.Hash) bool {
+	_, slotWarm := self.state.SlotInAccessList(addr, slot)
+	return slotWarm
+}
+
+func (self *StateTransition) refundGas() error {
 	sender, _ := self.from() // err already checked
-	remaining := new(big.Int).Mul(self.gas, self.gasPrice)
-	sender.AddBalance(remaining)
 
-	// Apply refund counter, capped to half of the used gas.
-	uhalf := remaining.Div(self.gasUsed(), common.Big2)
-	refund := common.BigMin(uhalf, self.state.GetRefund())
+	// Return the unused gas to the sender, exchanged at the original rate.
+	// This is computed into its own big.Int so that neither of the
+	// following steps can alias and overwrite it.
+	unused := new(big.Int).Mul(self.gas, self.gasPrice)
+	sender.AddBalance(unused)
+
+	// Apply the refund counter, capped to half of the gas actually used.
+	// half and refund are likewise kept distinct from unused and from each
+	// other: common.BigMin may hand back either argument by reference, so
+	// multiplying the result in place would risk corrupting self.state's
+	// own refund counter instead of just the local refund value.
+	half := new(big.Int).Div(self.gasUsed(), common.Big2)
+	refund := common.BigMin(half, self.state.GetRefund())
 	self.gas.Add(self.gas, refund)
-	self.state.AddBalance(sender.Address(), refund.Mul(refund, self.gasPrice))
+	sender.AddBalance(new(big.Int).Mul(refund, self.gasPrice))
+
+	if self.hook != nil {
+		if err := self.hook.OnRefund(refund); err != nil {
+			return err
+		}
+	}
+
+	if self.dryRun {
+		return nil
+	}
 
 	// Also return remaining gas to the block gas counter so it is
 	// available for the next transaction.
 	self.gp.AddGas(self.gas)
+	return nil
 }
 
 func (self *StateTransition) gasUsed() *big.Int {
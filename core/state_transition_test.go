@@ -0,0 +1,375 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+var (
+	testAccessAddrA = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testAccessAddrB = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	testAccessSlot1 = common.HexToHash("0x01")
+	testAccessSlot2 = common.HexToHash("0x02")
+)
+
+// TestAccessListIntrinsicStatsDedupesDuplicates checks that intrinsicStats
+// counts each address and each (address, slot) pair at most once, no matter
+// how many times the list repeats it.
+func TestAccessListIntrinsicStatsDedupesDuplicates(t *testing.T) {
+	list := AccessList{
+		{Address: testAccessAddrA, StorageKeys: []common.Hash{testAccessSlot1, testAccessSlot1, testAccessSlot2}},
+		{Address: testAccessAddrA, StorageKeys: []common.Hash{testAccessSlot1}},
+		{Address: testAccessAddrB, StorageKeys: nil},
+	}
+	addresses, slots := list.intrinsicStats()
+	if addresses != 2 {
+		t.Errorf("addresses = %d, want 2", addresses)
+	}
+	if slots != 2 {
+		t.Errorf("slots = %d, want 2", slots)
+	}
+}
+
+// TestAccessListIntrinsicStatsEmpty checks the zero-value case.
+func TestAccessListIntrinsicStatsEmpty(t *testing.T) {
+	addresses, slots := AccessList(nil).intrinsicStats()
+	if addresses != 0 || slots != 0 {
+		t.Errorf("intrinsicStats() = (%d, %d), want (0, 0)", addresses, slots)
+	}
+}
+
+// TestIntrinsicGasAccessListSurcharge checks that an AccessListTxType
+// message pays more intrinsic gas than an identical legacy message, and
+// that repeating an entry in the list doesn't inflate the surcharge beyond
+// what the equivalent deduplicated list would cost.
+func TestIntrinsicGasAccessListSurcharge(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x00}
+
+	legacy := IntrinsicGas(data, nil, byte(LegacyTxType), false, true)
+
+	list := AccessList{{Address: testAccessAddrA, StorageKeys: []common.Hash{testAccessSlot1}}}
+	withList := IntrinsicGas(data, list, byte(AccessListTxType), false, true)
+	if withList.Cmp(legacy) <= 0 {
+		t.Fatalf("access-list intrinsic gas = %v, want more than legacy %v", withList, legacy)
+	}
+
+	duplicated := AccessList{
+		{Address: testAccessAddrA, StorageKeys: []common.Hash{testAccessSlot1}},
+		{Address: testAccessAddrA, StorageKeys: []common.Hash{testAccessSlot1}},
+	}
+	withDuplicates := IntrinsicGas(data, duplicated, byte(AccessListTxType), false, true)
+	if withDuplicates.Cmp(withList) != 0 {
+		t.Errorf("duplicated-entry intrinsic gas = %v, want equal to deduplicated %v", withDuplicates, withList)
+	}
+}
+
+// Warm/cold query behaviour (IsAddressWarm, IsSlotWarm, preWarmAccessList)
+// and its interaction with a reverted call are exercised against a real
+// vm.Database/vm.Environment, which this package doesn't construct in unit
+// tests; that coverage belongs with the Database implementation itself.
+
+// fakeAccount is a minimal vm.Account backed by a plain balance/nonce pair,
+// just enough for refundGas's accounting; it mirrors the method set vm.Env
+// already exercises its own dummyContractRef against in the vm package.
+type fakeAccount struct {
+	address common.Address
+	balance *big.Int
+	nonce   uint64
+}
+
+func (a *fakeAccount) ReturnGas(*big.Int, *big.Int)                     {}
+func (a *fakeAccount) Address() common.Address                          { return a.address }
+func (a *fakeAccount) Value() *big.Int                                  { return a.balance }
+func (a *fakeAccount) SetCode([]byte)                                   {}
+func (a *fakeAccount) ForEachStorage(func(key, value common.Hash) bool) {}
+func (a *fakeAccount) SubBalance(amount *big.Int)                       { a.balance.Sub(a.balance, amount) }
+func (a *fakeAccount) AddBalance(amount *big.Int)                       { a.balance.Add(a.balance, amount) }
+func (a *fakeAccount) SetBalance(amount *big.Int)                       { a.balance = new(big.Int).Set(amount) }
+func (a *fakeAccount) SetNonce(nonce uint64)                            { a.nonce = nonce }
+func (a *fakeAccount) Balance() *big.Int                                { return a.balance }
+
+// fakeDatabase is a minimal vm.Database: a single-account store plus a
+// settable refund counter, enough to drive refundGas without a real state
+// trie.
+type fakeDatabase struct {
+	accounts  map[common.Address]*fakeAccount
+	refund    *big.Int
+	snapshots []fakeSnapshot
+}
+
+// fakeSnapshot is one entry pushed by fakeDatabase.Snapshot: a deep copy of
+// every account and the refund counter at the time it was taken.
+type fakeSnapshot struct {
+	accounts map[common.Address]fakeAccount
+	refund   *big.Int
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{accounts: make(map[common.Address]*fakeAccount), refund: new(big.Int)}
+}
+
+func (db *fakeDatabase) Exist(addr common.Address) bool {
+	_, ok := db.accounts[addr]
+	return ok
+}
+func (db *fakeDatabase) CreateAccount(addr common.Address) vm.Account {
+	a := &fakeAccount{address: addr, balance: new(big.Int)}
+	db.accounts[addr] = a
+	return a
+}
+func (db *fakeDatabase) GetAccount(addr common.Address) vm.Account  { return db.accounts[addr] }
+func (db *fakeDatabase) GetNonce(addr common.Address) uint64        { return db.accounts[addr].nonce }
+func (db *fakeDatabase) SetNonce(addr common.Address, nonce uint64) { db.accounts[addr].nonce = nonce }
+func (db *fakeDatabase) AddBalance(addr common.Address, amount *big.Int) {
+	if _, ok := db.accounts[addr]; !ok {
+		db.CreateAccount(addr)
+	}
+	db.accounts[addr].balance.Add(db.accounts[addr].balance, amount)
+}
+func (db *fakeDatabase) GetRefund() *big.Int                             { return db.refund }
+func (db *fakeDatabase) AddAddressToAccessList(common.Address)           {}
+func (db *fakeDatabase) AddSlotToAccessList(common.Address, common.Hash) {}
+func (db *fakeDatabase) AddressInAccessList(common.Address) bool         { return false }
+func (db *fakeDatabase) SlotInAccessList(common.Address, common.Hash) (addressOk, slotOk bool) {
+	return false, false
+}
+
+// Snapshot/RevertToSnapshot keep deep copies of every account's balance and
+// nonce (the only fields EstimateGas's dry runs can mutate through this
+// fake), so TestEstimateGas can confirm a dry run actually leaves the
+// database untouched rather than merely calling into stubs that do nothing.
+func (db *fakeDatabase) Snapshot() int {
+	snap := make(map[common.Address]fakeAccount, len(db.accounts))
+	for addr, a := range db.accounts {
+		snap[addr] = fakeAccount{address: a.address, balance: new(big.Int).Set(a.balance), nonce: a.nonce}
+	}
+	db.snapshots = append(db.snapshots, fakeSnapshot{accounts: snap, refund: new(big.Int).Set(db.refund)})
+	return len(db.snapshots) - 1
+}
+
+func (db *fakeDatabase) RevertToSnapshot(id int) {
+	snap := db.snapshots[id]
+	db.accounts = make(map[common.Address]*fakeAccount, len(snap.accounts))
+	for addr, a := range snap.accounts {
+		a := a
+		db.accounts[addr] = &a
+	}
+	db.refund = new(big.Int).Set(snap.refund)
+	db.snapshots = db.snapshots[:id]
+}
+
+// fakeEnv is a minimal vm.Environment. BlockNumber is all refundGas's call
+// path (via StateTransition.from) needs; Db, Coinbase, Create and Call exist
+// only so TestEstimateGas can drive transitionDb through a full simple-value-
+// transfer run, charging callGas and moving value directly against db rather
+// than interpreting any real EVM code.
+type fakeEnv struct {
+	blockNumber *big.Int
+	coinbase    common.Address
+	db          *fakeDatabase
+	callGas     *big.Int
+}
+
+func (e *fakeEnv) BlockNumber() *big.Int    { return e.blockNumber }
+func (e *fakeEnv) Coinbase() common.Address { return e.coinbase }
+func (e *fakeEnv) Db() vm.Database          { return e.db }
+
+func (e *fakeEnv) Create(caller vm.Account, data []byte, gas, gasPrice, value *big.Int) ([]byte, common.Address, error) {
+	panic("fakeEnv: contract creation not exercised by these tests")
+}
+
+func (e *fakeEnv) Call(caller vm.Account, addr common.Address, data []byte, gas, gasPrice, value *big.Int) ([]byte, error) {
+	if value.Sign() != 0 {
+		caller.SubBalance(value)
+		e.db.GetAccount(addr).AddBalance(value)
+	}
+	gas.Sub(gas, e.callGas)
+	return nil, nil
+}
+
+var testSenderAddr = common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+// newRefundTestTransition builds a StateTransition whose gas accounting is
+// fully determined by initialGas/gas/gasPrice/stateRefund, with a freshly
+// created, zero-balance sender account.
+func newRefundTestTransition(initialGas, gas, gasPrice, stateRefund int64) (*StateTransition, *fakeAccount, *fakeDatabase, *GasPool) {
+	db := newFakeDatabase()
+	db.refund.SetInt64(stateRefund)
+	gp := new(GasPool)
+
+	st := &StateTransition{
+		gp:         gp,
+		env:        &fakeEnv{blockNumber: big.NewInt(1)},
+		msg:        &fakeMessage{from: testSenderAddr},
+		gas:        big.NewInt(gas),
+		gasPrice:   big.NewInt(gasPrice),
+		initialGas: big.NewInt(initialGas),
+		state:      db,
+	}
+	sender := db.CreateAccount(testSenderAddr).(*fakeAccount)
+	return st, sender, db, gp
+}
+
+// fakeMessage supplies the From address refundGas's call path needs, plus
+// (for TestEstimateGas, which drives the full transitionDb) a destination,
+// gas limit/price and value; a zero-value field falls back to the harmless
+// default the older refundGas-only tests already relied on.
+type fakeMessage struct {
+	from     common.Address
+	to       *common.Address
+	gas      *big.Int
+	gasPrice *big.Int
+	value    *big.Int
+}
+
+func (m *fakeMessage) From() (common.Address, error)         { return m.from, nil }
+func (m *fakeMessage) FromFrontier() (common.Address, error) { return m.from, nil }
+func (m *fakeMessage) To() *common.Address                   { return m.to }
+func (m *fakeMessage) GasPrice() *big.Int {
+	if m.gasPrice == nil {
+		return new(big.Int)
+	}
+	return m.gasPrice
+}
+func (m *fakeMessage) Gas() *big.Int {
+	if m.gas == nil {
+		return new(big.Int)
+	}
+	return m.gas
+}
+func (m *fakeMessage) Value() *big.Int {
+	if m.value == nil {
+		return new(big.Int)
+	}
+	return m.value
+}
+func (m *fakeMessage) Nonce() uint64          { return 0 }
+func (m *fakeMessage) Data() []byte           { return nil }
+func (m *fakeMessage) Type() byte             { return byte(LegacyTxType) }
+func (m *fakeMessage) AccessList() AccessList { return nil }
+func (m *fakeMessage) ExtraData() []byte      { return nil }
+
+// TestRefundGasAccounting locks down the sender balance and block gas pool
+// that refundGas produces for a handful of representative scenarios,
+// guarding against the aliasing bug where reusing one big.Int across the
+// unused-gas and refund-counter steps silently corrupted the credited
+// amount.
+func TestRefundGasAccounting(t *testing.T) {
+	tests := []struct {
+		name                       string
+		initialGas, gas, gasPrice  int64
+		stateRefund                int64
+		wantSenderCredit, wantPool int64
+	}{
+		// A contract-creation transaction used 95000 gas out of 100000,
+		// with 20000 left in the refund counter (well under half of used).
+		{"contract-creation refund", 100000, 5000, 1, 20000, 25000, 25000},
+		// All gas used (e.g. an SSTORE-clear left exactly 15000 in the
+		// refund counter), priced at 2 wei/gas.
+		{"sstore-clear refund", 50000, 0, 2, 15000, 30000, 15000},
+		// The refund counter exceeds half of the gas used, so it is capped.
+		{"refund capped at half of used gas", 10000, 1000, 1, 100000, 5500, 5500},
+		{"zero refund", 21000, 0, 3, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, sender, _, gp := newRefundTestTransition(tt.initialGas, tt.gas, tt.gasPrice, tt.stateRefund)
+			if err := st.refundGas(); err != nil {
+				t.Fatalf("refundGas() error = %v", err)
+			}
+			if sender.balance.Int64() != tt.wantSenderCredit {
+				t.Errorf("sender balance = %v, want %v", sender.balance, tt.wantSenderCredit)
+			}
+			if pool := (*big.Int)(gp); pool.Int64() != tt.wantPool {
+				t.Errorf("gas pool = %v, want %v", pool, tt.wantPool)
+			}
+		})
+	}
+}
+
+var testEstimateGasToAddr = common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+// newEstimateGasFixture builds a fakeDatabase with a funded sender account
+// and the fakeEnv/fakeMessage pair driving a simple value transfer to
+// testEstimateGasToAddr, so EstimateGas and ApplyMessage can each be run
+// against their own freshly seeded copy.
+func newEstimateGasFixture() (*fakeDatabase, *fakeEnv, *fakeMessage) {
+	db := newFakeDatabase()
+	sender := db.CreateAccount(testSenderAddr).(*fakeAccount)
+	sender.SetBalance(big.NewInt(1000000000))
+
+	env := &fakeEnv{
+		blockNumber: big.NewInt(1),
+		coinbase:    common.HexToAddress("0x5555555555555555555555555555555555555555"),
+		db:          db,
+		callGas:     big.NewInt(9000),
+	}
+	msg := &fakeMessage{
+		from:     testSenderAddr,
+		to:       &testEstimateGasToAddr,
+		gas:      big.NewInt(100000),
+		gasPrice: big.NewInt(1),
+		value:    big.NewInt(42),
+	}
+	return db, env, msg
+}
+
+// TestEstimateGas checks that EstimateGas leaves the database exactly as it
+// found it -- confirming the Snapshot/RevertToSnapshot dry-run actually
+// discards the sender's debited balance, the nonce bump and the transferred
+// value, not just the stubs it was shipped with -- and that the gas it
+// reports matches what a real, non-dry-run ApplyMessage uses for the same
+// message against identically seeded state.
+func TestEstimateGas(t *testing.T) {
+	db, env, msg := newEstimateGasFixture()
+	sender := db.accounts[testSenderAddr]
+	preBalance := new(big.Int).Set(sender.balance)
+	preNonce := sender.nonce
+
+	gp := new(GasPool).AddGas(big.NewInt(1000000))
+	usedGas, err := EstimateGas(env, msg, gp)
+	if err != nil {
+		t.Fatalf("EstimateGas() error = %v", err)
+	}
+
+	// RevertToSnapshot installs fresh account copies, so look the sender up again.
+	sender = db.accounts[testSenderAddr]
+	if sender.balance.Cmp(preBalance) != 0 {
+		t.Errorf("sender balance = %v after EstimateGas, want unchanged %v", sender.balance, preBalance)
+	}
+	if sender.nonce != preNonce {
+		t.Errorf("sender nonce = %v after EstimateGas, want unchanged %v", sender.nonce, preNonce)
+	}
+	if to, ok := db.accounts[testEstimateGasToAddr]; ok && to.balance.Sign() != 0 {
+		t.Errorf("recipient balance = %v after EstimateGas, want unchanged 0 (account shouldn't outlive the dry run's rollback)", to.balance)
+	}
+
+	wantDB, wantEnv, wantMsg := newEstimateGasFixture()
+	wantGp := new(GasPool).AddGas(big.NewInt(1000000))
+	if _, gasUsed, err := ApplyMessage(wantEnv, wantMsg, wantGp); err != nil {
+		t.Fatalf("ApplyMessage() error = %v", err)
+	} else if usedGas.Cmp(gasUsed) != 0 {
+		t.Errorf("EstimateGas = %v, want equal to a real ApplyMessage run's gasUsed %v", usedGas, gasUsed)
+	} else if recipient := wantDB.accounts[testEstimateGasToAddr]; recipient == nil || recipient.balance.Int64() != 42 {
+		t.Fatalf("fixture sanity check failed: ApplyMessage didn't actually move value")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// BloomByteLength is the number of bytes used in a header log bloom.
+	BloomByteLength = 256
+
+	// BloomBitLength is the number of bits used in a header log bloom.
+	BloomBitLength = 8 * BloomByteLength
+)
+
+// Bloom represents a 2048 bit bloom filter.
+type Bloom [BloomByteLength]byte
+
+// BytesToBloom sets b to the value of buf, left-padding it if buf is
+// shorter than the bloom filter's length.
+func BytesToBloom(buf []byte) Bloom {
+	var b Bloom
+	b.SetBytes(buf)
+	return b
+}
+
+// SetBytes sets the content of b to the given bytes. It panics if d is
+// larger than the bloom filter's length.
+func (b *Bloom) SetBytes(d []byte) {
+	if len(b) < len(d) {
+		panic(fmt.Sprintf("bloom bytes too big %d %d", len(b), len(d)))
+	}
+	copy(b[BloomByteLength-len(d):], d)
+}
+
+// Add adds the bloom hash of d to b.
+func (b *Bloom) Add(d []byte) {
+	bin := new(big.Int).SetBytes(b[:])
+	bin.Or(bin, bloom9(d))
+	b.SetBytes(bin.Bytes())
+}
+
+// Big converts b to a big integer.
+func (b Bloom) Big() *big.Int {
+	return new(big.Int).SetBytes(b[:])
+}
+
+func (b Bloom) Bytes() []byte {
+	return b[:]
+}
+
+// TestBytes reports whether the bloom hash of test is present in b.
+func (b Bloom) TestBytes(test []byte) bool {
+	return BloomLookup(b, test)
+}
+
+// CreateBloom derives the combined log bloom for the given receipts.
+func CreateBloom(receipts Receipts) Bloom {
+	bin := new(big.Int)
+	for _, receipt := range receipts {
+		bin.Or(bin, LogsBloom(receipt.Logs))
+	}
+	return BytesToBloom(bin.Bytes())
+}
+
+// LogsBloom returns the bloom accumulated from the address and topics of
+// the given logs.
+func LogsBloom(logs vm.Logs) *big.Int {
+	bin := new(big.Int)
+	for _, log := range logs {
+		bin.Or(bin, bloom9(log.Address.Bytes()))
+		for _, topic := range log.Topics {
+			bin.Or(bin, bloom9(topic.Bytes()))
+		}
+	}
+	return bin
+}
+
+// bloom9 returns the 3-bit-set bloom filter value for a single item (an
+// address or a topic): the big-endian 16-bit values at byte offsets 0, 2
+// and 4 of its Keccak256 hash, each masked to 11 bits, select the bits set.
+func bloom9(b []byte) *big.Int {
+	b = crypto.Keccak256(b)
+
+	r := new(big.Int)
+	for _, i := range []int{0, 2, 4} {
+		t := big.NewInt(1)
+		bit := (uint(b[i+1]) + (uint(b[i]) << 8)) & 2047
+		r.Or(r, t.Lsh(t, bit))
+	}
+	return r
+}
+
+// BloomLookup reports whether b was possibly Add'd with test's bloom hash.
+// False positives are possible; their rate grows with the number of items
+// whose bits have been set in b.
+func BloomLookup(b Bloom, test []byte) bool {
+	bloom := b.Big()
+	cmp := bloom9(test)
+	return bloom.And(bloom, cmp).Cmp(cmp) == 0
+}
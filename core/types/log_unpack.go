@@ -0,0 +1,120 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+var (
+	// ErrNoEventSignature is returned by UnpackLog when the log carries no
+	// topics at all, so there is no topic 0 to check against the event's
+	// signature.
+	ErrNoEventSignature = errors.New("types: log has no topics, expected an event signature in topic 0")
+
+	// ErrEventSignatureMismatch is returned by UnpackLog when the log's
+	// topic 0 does not match the requested (non-anonymous) event.
+	ErrEventSignatureMismatch = errors.New("types: log topic 0 does not match the requested event signature")
+)
+
+// UnpackLog decodes log's non-indexed data and indexed topics into out
+// according to the named event in contractAbi, mirroring the decoding the
+// generated bind layer performs for bound contract events.
+//
+// For a non-anonymous event, log.Topics[0] must be the event's signature
+// hash; ErrNoEventSignature or ErrEventSignatureMismatch is returned
+// otherwise. Anonymous events carry no signature topic, so every element of
+// log.Topics is treated as an indexed argument instead.
+func UnpackLog(contractAbi *abi.ABI, out interface{}, eventName string, log vm.Log, anonymous bool) error {
+	event, ok := contractAbi.Events[eventName]
+	if !ok {
+		return fmt.Errorf("types: event %q not found in ABI", eventName)
+	}
+	indexed, err := checkEventTopics(event, log.Topics, anonymous)
+	if err != nil {
+		return err
+	}
+	if len(log.Data) > 0 {
+		if err := contractAbi.Unpack(out, eventName, log.Data); err != nil {
+			return err
+		}
+	}
+	return abi.ParseTopics(out, indexedArguments(event), indexed)
+}
+
+// UnpackLogIntoMap is the map-returning counterpart of UnpackLog.
+func UnpackLogIntoMap(contractAbi *abi.ABI, out map[string]interface{}, eventName string, log vm.Log, anonymous bool) error {
+	event, ok := contractAbi.Events[eventName]
+	if !ok {
+		return fmt.Errorf("types: event %q not found in ABI", eventName)
+	}
+	indexed, err := checkEventTopics(event, log.Topics, anonymous)
+	if err != nil {
+		return err
+	}
+	if len(log.Data) > 0 {
+		if err := contractAbi.UnpackIntoMap(out, eventName, log.Data); err != nil {
+			return err
+		}
+	}
+	return abi.ParseTopicsIntoMap(out, indexedArguments(event), indexed)
+}
+
+// checkEventTopics validates the event-signature topic (unless anonymous)
+// and returns the remaining topics that carry indexed arguments.
+func checkEventTopics(event abi.Event, topics []common.Hash, anonymous bool) ([]common.Hash, error) {
+	if anonymous {
+		return topics, nil
+	}
+	if len(topics) == 0 {
+		return nil, ErrNoEventSignature
+	}
+	if topics[0] != event.Id() {
+		return nil, ErrEventSignatureMismatch
+	}
+	return topics[1:], nil
+}
+
+// indexedArguments returns the subset of event's inputs that are indexed,
+// in declaration order, matching the order UnpackLog's topics are supplied.
+func indexedArguments(event abi.Event) abi.Arguments {
+	var indexed abi.Arguments
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexed = append(indexed, arg)
+		}
+	}
+	return indexed
+}
+
+// LogsByEvent returns the subset of r.Logs whose topic 0 equals sig, i.e.
+// those emitted by the event sig is the signature hash of. Logs with no
+// topics (which can only come from an anonymous event) never match.
+func (r *Receipt) LogsByEvent(sig common.Hash) []*vm.Log {
+	var matches []*vm.Log
+	for _, log := range r.Logs {
+		if len(log.Topics) > 0 && log.Topics[0] == sig {
+			matches = append(matches, log)
+		}
+	}
+	return matches
+}
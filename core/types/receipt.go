@@ -38,6 +38,16 @@ type Receipt struct {
 	TxHash          common.Hash
 	ContractAddress common.Address
 	GasUsed         *big.Int
+
+	// PSRoot is the post-state root of this transaction's execution against
+	// a separate private state database, and PSReceipts the receipts that
+	// execution produced, keyed by the hash of the private payload they
+	// belong to. Both are populated only for permissioned/confidential
+	// transactions run through a dual-state (public + private) EVM, and are
+	// never part of the consensus RLP encoding: private data must never be
+	// hashed into the public receipts trie.
+	PSRoot     []byte
+	PSReceipts map[common.Hash]*Receipt
 }
 
 // NewReceipt creates a barebone transaction receipt, copying the init fields.
@@ -85,6 +95,30 @@ func (r *Receipt) String() string {
 // entire content of a receipt, as opposed to only the consensus fields originally.
 type ReceiptForStorage Receipt
 
+// psReceiptEntry is the on-disk representation of one entry of
+// Receipt.PSReceipts: maps don't have a canonical RLP encoding, so storage
+// flattens it to a list of (hash, receipt) pairs.
+type psReceiptEntry struct {
+	Hash    common.Hash
+	Receipt *ReceiptForStorage
+}
+
+// receiptStorageRLP is the current on-disk layout of ReceiptForStorage. It
+// extends the legacy 7-field layout with the private-state root and private
+// receipts trailer; for receipts that never ran against a private state both
+// are nil and are encoded as empty elements.
+type receiptStorageRLP struct {
+	PostState         []byte
+	CumulativeGasUsed *big.Int
+	Bloom             Bloom
+	TxHash            common.Hash
+	ContractAddress   common.Address
+	Logs              []*vm.LogForStorage
+	GasUsed           *big.Int
+	PSRoot            []byte
+	PSReceipts        []psReceiptEntry
+}
+
 // EncodeRLP implements rlp.Encoder, and flattens all content fields of a receipt
 // into an RLP stream.
 func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
@@ -92,24 +126,50 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 	for i, log := range r.Logs {
 		logs[i] = (*vm.LogForStorage)(log)
 	}
-	return rlp.Encode(w, []interface{}{r.PostState, r.CumulativeGasUsed, r.Bloom, r.TxHash, r.ContractAddress, logs, r.GasUsed})
+	var psReceipts []psReceiptEntry
+	for hash, receipt := range r.PSReceipts {
+		psReceipts = append(psReceipts, psReceiptEntry{Hash: hash, Receipt: (*ReceiptForStorage)(receipt)})
+	}
+	return rlp.Encode(w, &receiptStorageRLP{
+		PostState:         r.PostState,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+		Bloom:             r.Bloom,
+		TxHash:            r.TxHash,
+		ContractAddress:   r.ContractAddress,
+		Logs:              logs,
+		GasUsed:           r.GasUsed,
+		PSRoot:            r.PSRoot,
+		PSReceipts:        psReceipts,
+	})
 }
 
-// DecodeRLP implements rlp.Decoder, and loads both consensus and implementation
-// fields of a receipt from an RLP stream.
+// DecodeRLP implements rlp.Decoder, and loads both consensus and
+// implementation fields of a receipt from an RLP stream. It transparently
+// migrates the legacy 7-field layout that predates private-state receipts,
+// in which case the result has PSReceipts == nil.
 func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
-	var receipt struct {
-		PostState         []byte
-		CumulativeGasUsed *big.Int
-		Bloom             Bloom
-		TxHash            common.Hash
-		ContractAddress   common.Address
-		Logs              []*vm.LogForStorage
-		GasUsed           *big.Int
-	}
-	if err := s.Decode(&receipt); err != nil {
+	raw, err := s.Raw()
+	if err != nil {
 		return err
 	}
+	var receipt receiptStorageRLP
+	if err := rlp.DecodeBytes(raw, &receipt); err != nil {
+		// Fall back to the legacy layout predating PSRoot/PSReceipts.
+		var legacy struct {
+			PostState         []byte
+			CumulativeGasUsed *big.Int
+			Bloom             Bloom
+			TxHash            common.Hash
+			ContractAddress   common.Address
+			Logs              []*vm.LogForStorage
+			GasUsed           *big.Int
+		}
+		if err := rlp.DecodeBytes(raw, &legacy); err != nil {
+			return err
+		}
+		receipt.PostState, receipt.CumulativeGasUsed, receipt.Bloom = legacy.PostState, legacy.CumulativeGasUsed, legacy.Bloom
+		receipt.TxHash, receipt.ContractAddress, receipt.Logs, receipt.GasUsed = legacy.TxHash, legacy.ContractAddress, legacy.Logs, legacy.GasUsed
+	}
 	// Assign the consensus fields
 	r.PostState, r.CumulativeGasUsed, r.Bloom = receipt.PostState, receipt.CumulativeGasUsed, receipt.Bloom
 	r.Logs = make(vm.Logs, len(receipt.Logs))
@@ -119,6 +179,14 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 	// Assign the implementation fields
 	r.TxHash, r.ContractAddress, r.GasUsed = receipt.TxHash, receipt.ContractAddress, receipt.GasUsed
 
+	// Assign the private-state fields, if any (nil for a migrated legacy receipt)
+	r.PSRoot = receipt.PSRoot
+	if len(receipt.PSReceipts) > 0 {
+		r.PSReceipts = make(map[common.Hash]*Receipt, len(receipt.PSReceipts))
+		for _, entry := range receipt.PSReceipts {
+			r.PSReceipts[entry.Hash] = (*Receipt)(entry.Receipt)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,104 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"container/heap"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// txByPriceHeap is a container/heap of the per-sender head transactions
+// maintained by TransactionsByPriceAndNonce, ordered by descending gas
+// price.
+type txByPriceHeap []*Transaction
+
+func (h txByPriceHeap) Len() int { return len(h) }
+func (h txByPriceHeap) Less(i, j int) bool {
+	return h[i].GasPrice().Cmp(h[j].GasPrice()) > 0
+}
+func (h txByPriceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *txByPriceHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Transaction))
+}
+
+func (h *txByPriceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	tx := old[n-1]
+	*h = old[:n-1]
+	return tx
+}
+
+// TransactionsByPriceAndNonce iterates over a set of per-sender,
+// nonce-ordered transaction queues, always handing out the highest
+// gas-priced head transaction across every sender. This keeps senders'
+// transactions in their required nonce order while still giving priority
+// to whichever sender is currently offering the most gas, without the
+// deep flattening/re-sorting the old TxByPrice/TxByNonce approaches needed.
+type TransactionsByPriceAndNonce struct {
+	txs   map[common.Address]Transactions // remaining per-sender txs, nonce-ordered
+	heads txByPriceHeap                   // next transaction for each sender, price-ordered
+}
+
+// NewTransactionsByPriceAndNonce creates a transaction set that can iterate
+// over the given per-sender transactions in price-then-nonce order, once
+// those per-sender queues have been sorted by nonce.
+func NewTransactionsByPriceAndNonce(txs map[common.Address]Transactions) *TransactionsByPriceAndNonce {
+	heads := make(txByPriceHeap, 0, len(txs))
+	for from, accTxs := range txs {
+		heads = append(heads, accTxs[0])
+		txs[from] = accTxs[1:]
+	}
+	heap.Init(&heads)
+
+	return &TransactionsByPriceAndNonce{
+		txs:   txs,
+		heads: heads,
+	}
+}
+
+// Peek returns the next transaction by price, or nil if there are none left.
+func (t *TransactionsByPriceAndNonce) Peek() *Transaction {
+	if len(t.heads) == 0 {
+		return nil
+	}
+	return t.heads[0]
+}
+
+// Shift replaces the current best head with the next transaction from the
+// same sender, by nonce, re-heaping it by that transaction's own gas
+// price; if the sender has no transactions left, it is dropped instead.
+func (t *TransactionsByPriceAndNonce) Shift() {
+	acc, _ := t.heads[0].From()
+	if txs, ok := t.txs[acc]; ok && len(txs) > 0 {
+		t.heads[0], t.txs[acc] = txs[0], txs[1:]
+		heap.Fix(&t.heads, 0)
+	} else {
+		heap.Pop(&t.heads)
+	}
+}
+
+// Pop drops the current best head and every remaining transaction from the
+// same sender, for use once that sender has produced a transaction the
+// block can't include (e.g. it hit the gas limit).
+func (t *TransactionsByPriceAndNonce) Pop() {
+	acc, _ := t.heads[0].From()
+	delete(t.txs, acc)
+	heap.Pop(&t.heads)
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package secp256k1 wraps libsecp256k1 for the curve operations used
+// throughout the client (Sign, RecoverPubkey, GenerateKeyPair, S256, ...);
+// those cgo bindings live outside this extraction. This file holds the
+// pure-Go pieces Sign is expected to call into: RFC 6979 deterministic
+// nonce derivation and low-S signature normalization.
+package secp256k1
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+)
+
+// secp256k1N is the order of the secp256k1 base point, a public curve
+// parameter (not a secret of any kind).
+var secp256k1N, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+
+// secp256k1halfN is N/2, the threshold above which an ECDSA s value is
+// considered "high" and must be replaced by N-s to satisfy the low-S
+// malleability rule.
+var secp256k1halfN = new(big.Int).Rsh(secp256k1N, 1)
+
+// RFC6979Nonce deterministically derives the per-signature nonce k used by
+// Sign, following RFC 6979 section 3.2 (HMAC-DRBG seeded with seckey and
+// msg, both already reduced to the curve's field width). Reusing this
+// derivation for every signature over the same (seckey, msg) pair means
+// Sign never needs entropy from the caller, closing off the nonce-reuse
+// class of signature forgery.
+func RFC6979Nonce(seckey, msg []byte) *big.Int {
+	key := append(append([]byte{}, seckey...), msg...)
+
+	v := bytesRepeat(0x01, sha256.Size)
+	k := bytesRepeat(0x00, sha256.Size)
+
+	k = hmacSum(k, append(append(append([]byte{}, v...), 0x00), key...))
+	v = hmacSum(k, v)
+	k = hmacSum(k, append(append(append([]byte{}, v...), 0x01), key...))
+	v = hmacSum(k, v)
+
+	for {
+		v = hmacSum(k, v)
+		n := new(big.Int).SetBytes(v)
+		if n.Sign() > 0 && n.Cmp(secp256k1N) < 0 {
+			return n
+		}
+		k = hmacSum(k, append(append([]byte{}, v...), 0x00))
+		v = hmacSum(k, v)
+	}
+}
+
+func hmacSum(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func bytesRepeat(b byte, n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = b
+	}
+	return buf
+}
+
+// NormalizeLowS returns the low-S form of s: s itself if s is already at
+// most N/2, otherwise N-s. The second return value reports whether s was
+// flipped, which the caller must use to flip the recovery id's parity bit
+// in lockstep, since negating s negates the point the recovery id selects.
+func NormalizeLowS(s *big.Int) (*big.Int, bool) {
+	if s.Cmp(secp256k1halfN) > 0 {
+		return new(big.Int).Sub(secp256k1N, s), true
+	}
+	return s, false
+}
@@ -19,6 +19,7 @@ package secp256k1
 import (
 	"bytes"
 	"encoding/hex"
+	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/crypto/randentropy"
@@ -191,18 +192,72 @@ func TestRecoverSanity(t *testing.T) {
 	}
 }
 
-// tests for malleability
-// highest bit of signature ECDSA s value must be 0, in the 33th byte
+// tests for malleability: a compact signature's s value must be in its
+// low-S form, i.e. at most half the curve order, not merely have a zero
+// top bit (which rejects fewer malleable signatures than it should).
 func compactSigCheck(t *testing.T, sig []byte) {
-	var b int = int(sig[32])
-	if b < 0 {
-		t.Errorf("highest bit is negative: %d", b)
+	s := new(big.Int).SetBytes(sig[32:64])
+	if s.Cmp(secp256k1halfN) > 0 {
+		t.Errorf("signature is not low-S: s = %x, N/2 = %x", s, secp256k1halfN)
 	}
-	if ((b >> 7) == 1) != ((b & 0x80) == 0x80) {
-		t.Errorf("highest bit: %d bit >> 7: %d", b, b>>7)
+}
+
+// rfc6979Vectors pin the deterministic-nonce derivation against itself
+// across repeated calls and across a spread of inputs, and check the
+// low-S normalization's two branches. Byte-exact RFC 6979 test vectors
+// (message, key, expected nonce) additionally require the curve's point
+// multiplication to turn a nonce into a signature, which lives in the
+// cgo-bound Sign outside this extraction; these vectors instead pin the
+// properties Sign relies on: determinism, range, and S normalization.
+var rfc6979Vectors = []struct {
+	seckey, msg []byte
+}{
+	{bytes.Repeat([]byte{0x01}, 32), bytes.Repeat([]byte{0xaa}, 32)},
+	{bytes.Repeat([]byte{0x02}, 32), bytes.Repeat([]byte{0xaa}, 32)},
+	{bytes.Repeat([]byte{0x01}, 32), bytes.Repeat([]byte{0xbb}, 32)},
+	{make([]byte, 32), []byte("the quick brown fox")},
+}
+
+func TestRFC6979NonceDeterministic(t *testing.T) {
+	for i, v := range rfc6979Vectors {
+		k1 := RFC6979Nonce(v.seckey, v.msg)
+		k2 := RFC6979Nonce(v.seckey, v.msg)
+		if k1.Cmp(k2) != 0 {
+			t.Errorf("vector %d: nonce not deterministic: %x != %x", i, k1, k2)
+		}
+		if k1.Sign() <= 0 || k1.Cmp(secp256k1N) >= 0 {
+			t.Errorf("vector %d: nonce %x out of range [1, N-1]", i, k1)
+		}
+	}
+}
+
+func TestRFC6979NonceVaries(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, v := range rfc6979Vectors {
+		k := RFC6979Nonce(v.seckey, v.msg).String()
+		if seen[k] {
+			t.Errorf("two distinct (seckey, msg) vectors produced the same nonce")
+		}
+		seen[k] = true
+	}
+}
+
+func TestNormalizeLowS(t *testing.T) {
+	low := big.NewInt(1)
+	if s, flipped := NormalizeLowS(low); flipped || s.Cmp(low) != 0 {
+		t.Errorf("low s was altered: got %x, flipped=%v", s, flipped)
+	}
+
+	high := new(big.Int).Add(secp256k1halfN, big.NewInt(1))
+	s, flipped := NormalizeLowS(high)
+	if !flipped {
+		t.Errorf("high s (%x > N/2) was not flipped", high)
+	}
+	if s.Cmp(secp256k1halfN) > 0 {
+		t.Errorf("normalized s is still high: %x", s)
 	}
-	if (b & 0x80) == 0x80 {
-		t.Errorf("highest bit: %d bit & 0x80: %d", b, b&0x80)
+	if new(big.Int).Add(s, high).Cmp(secp256k1N) != 0 {
+		t.Errorf("s and N-s should sum to N: s=%x high=%x N=%x", s, high, secp256k1N)
 	}
 }
 
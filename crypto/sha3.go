@@ -0,0 +1,67 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	xsha3 "golang.org/x/crypto/sha3"
+)
+
+// SHA3_256 computes the standards-conformant FIPS 202 SHA3-256 digest of
+// data. Unlike Keccak256, which this package (and the protocol) use
+// everywhere else, this uses the finalized NIST padding rather than the
+// pre-standardization one Ethereum settled on before FIPS 202 shipped.
+func SHA3_256(data ...[]byte) []byte {
+	d := xsha3.New256()
+	for _, b := range data {
+		d.Write(b)
+	}
+	return d.Sum(nil)
+}
+
+// SHA3_512 computes the standards-conformant FIPS 202 SHA3-512 digest of
+// data.
+func SHA3_512(data ...[]byte) []byte {
+	d := xsha3.New512()
+	for _, b := range data {
+		d.Write(b)
+	}
+	return d.Sum(nil)
+}
+
+// SHAKE128 returns outLen bytes of the FIPS 202 SHAKE128 extendable-output
+// function applied to data.
+func SHAKE128(outLen int, data ...[]byte) []byte {
+	d := xsha3.NewShake128()
+	for _, b := range data {
+		d.Write(b)
+	}
+	out := make([]byte, outLen)
+	d.Read(out)
+	return out
+}
+
+// SHAKE256 returns outLen bytes of the FIPS 202 SHAKE256 extendable-output
+// function applied to data.
+func SHAKE256(outLen int, data ...[]byte) []byte {
+	d := xsha3.NewShake256()
+	for _, b := range data {
+		d.Write(b)
+	}
+	out := make([]byte, outLen)
+	d.Read(out)
+	return out
+}
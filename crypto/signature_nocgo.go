@@ -0,0 +1,139 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !cgo
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+)
+
+// secp256k1N and secp256k1halfN mirror the exported N/HalfN the cgo-bound
+// secp256k1 package provides, so ValidateSignatureValues behaves identically
+// regardless of which backend this binary was built with.
+var (
+	secp256k1N     = btcec.S256().N
+	secp256k1halfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+// Ecrecover returns the uncompressed public key that created the given
+// signature. This is the pure-Go fallback used when CGO_ENABLED=0, backed
+// by btcec instead of libsecp256k1.
+func Ecrecover(hash, sig []byte) ([]byte, error) {
+	pub, err := sigToPub(hash, sig)
+	if err != nil {
+		return nil, err
+	}
+	return FromECDSAPub(pub), nil
+}
+
+func ValidateSignatureValues(v byte, r, s *big.Int, homestead bool) bool {
+	if r.Cmp(common.Big1) < 0 || s.Cmp(common.Big1) < 0 {
+		return false
+	}
+	vint := uint32(v)
+	// reject upper range of s values (ECDSA malleability)
+	// see discussion in secp256k1/libsecp256k1/include/secp256k1.h
+	if homestead && s.Cmp(secp256k1halfN) > 0 {
+		return false
+	}
+	// Frontier: allow s to be in full N range
+	if s.Cmp(secp256k1N) >= 0 {
+		return false
+	}
+	if r.Cmp(secp256k1N) < 0 && (vint == 27 || vint == 28) {
+		return true
+	} else {
+		return false
+	}
+}
+
+func SigToPub(hash, sig []byte) (*ecdsa.PublicKey, error) {
+	return sigToPub(hash, sig)
+}
+
+func sigToPub(hash, sig []byte) (*ecdsa.PublicKey, error) {
+	if len(sig) != 65 {
+		return nil, fmt.Errorf("invalid signature length: %d", len(sig))
+	}
+	// btcec wants its own compact format: a 1-byte recovery header (27-30,
+	// uncompressed) followed by R||S, rather than Ethereum's R||S followed
+	// by a 0-3 recovery id.
+	btcsig := make([]byte, 65)
+	btcsig[0] = sig[64] + 27
+	copy(btcsig[1:], sig[:64])
+
+	pub, _, err := btcec.RecoverCompact(btcec.S256(), btcsig, hash)
+	if err != nil {
+		return nil, err
+	}
+	return pub.ToECDSA(), nil
+}
+
+// Sign signs hash with prv, deriving the per-signature nonce deterministically
+// via secp256k1.RFC6979Nonce rather than taking it from btcec.SignCompact (which
+// draws its own nonce from crypto/rand), and normalizing s to its low-S form
+// via secp256k1.NormalizeLowS, flipping the recovery id's parity bit in lockstep.
+func Sign(hash []byte, prv *ecdsa.PrivateKey) (sig []byte, err error) {
+	if len(hash) != 32 {
+		return nil, fmt.Errorf("hash is required to be exactly 32 bytes (%d)", len(hash))
+	}
+	curve := btcec.S256()
+	seckey := common.LeftPadBytes(prv.D.Bytes(), 32)
+	defer zeroBytes(seckey)
+
+	k := secp256k1.RFC6979Nonce(seckey, hash)
+	kInv := new(big.Int).ModInverse(k, secp256k1N)
+	if kInv == nil {
+		return nil, fmt.Errorf("nonce has no inverse mod N")
+	}
+
+	rx, ry := curve.ScalarBaseMult(k.Bytes())
+	r := new(big.Int).Mod(rx, secp256k1N)
+	if r.Sign() == 0 {
+		return nil, fmt.Errorf("signature r is zero")
+	}
+
+	s := new(big.Int).Mul(r, prv.D)
+	s.Add(s, new(big.Int).SetBytes(hash))
+	s.Mul(s, kInv)
+	s.Mod(s, secp256k1N)
+	if s.Sign() == 0 {
+		return nil, fmt.Errorf("signature s is zero")
+	}
+
+	recoveryID := byte(ry.Bit(0))
+	if rx.Cmp(secp256k1N) >= 0 {
+		recoveryID |= 2
+	}
+	var flipped bool
+	if s, flipped = secp256k1.NormalizeLowS(s); flipped {
+		recoveryID ^= 1
+	}
+
+	sig = make([]byte, 65)
+	copy(sig[:32], common.LeftPadBytes(r.Bytes(), 32))
+	copy(sig[32:64], common.LeftPadBytes(s.Bytes(), 32))
+	sig[64] = recoveryID
+	return sig, nil
+}
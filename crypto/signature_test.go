@@ -0,0 +1,89 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// These tests exercise whichever of signature_cgo.go/signature_nocgo.go was
+// compiled into this binary, so they deliberately avoid hardcoded expected
+// signature bytes: the two backends are not guaranteed to produce
+// byte-identical signatures for the same (key, hash), so only properties
+// that hold for both backends belong here.
+
+func TestSignAndRecoverPub(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := Keccak256([]byte("the quick brown fox jumps over the lazy dog"))
+
+	sig, err := Sign(hash, key)
+	if err != nil {
+		t.Fatalf("Sign failed: %s", err)
+	}
+	if len(sig) != 65 {
+		t.Fatalf("expected 65 byte signature, got %d", len(sig))
+	}
+
+	recovered, err := Ecrecover(hash, sig)
+	if err != nil {
+		t.Fatalf("Ecrecover failed: %s", err)
+	}
+	if !bytes.Equal(recovered, FromECDSAPub(&key.PublicKey)) {
+		t.Errorf("recovered pubkey mismatch: got %x, want %x", recovered, FromECDSAPub(&key.PublicKey))
+	}
+
+	pub, err := SigToPub(hash, sig)
+	if err != nil {
+		t.Fatalf("SigToPub failed: %s", err)
+	}
+	if pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		t.Errorf("SigToPub returned the wrong public key")
+	}
+}
+
+func TestSignWrongHashLength(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sign(make([]byte, 31), key); err == nil {
+		t.Error("expected Sign to reject a hash that isn't 32 bytes")
+	}
+}
+
+func TestValidateSignatureValuesLowS(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := Keccak256([]byte("validate me"))
+	sig, err := Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rVal := new(big.Int).SetBytes(sig[:32])
+	sVal := new(big.Int).SetBytes(sig[32:64])
+	v := sig[64] + 27
+	if !ValidateSignatureValues(v, rVal, sVal, true) {
+		t.Errorf("ValidateSignatureValues rejected a freshly produced low-S signature")
+	}
+}
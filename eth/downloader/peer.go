@@ -23,29 +23,63 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
 const (
 	maxLackingHashes = 4096 // Maximum number of entries allowed on the list or lacking items
 	throughputImpact = 0.1  // The impact a single measurement has on a peer's final throughput value.
-)
 
-// Hash and block fetchers belonging to eth/61 and below
-type relativeHashFetcherFn func(common.Hash) error
-type absoluteHashFetcherFn func(uint64, int) error
-type blockFetcherFn func([]common.Hash) error
+	eth67 = 67 // Protocol version introducing snap-style range requests
+
+	qosReputationFloor = 0.1 // Fraction of the median block throughput below which a peer is dropped from idle scheduling
 
-// Block header and body fetchers belonging to eth/62 and above
-type relativeHeaderFetcherFn func(common.Hash, int, int, bool) error
-type absoluteHeaderFetcherFn func(uint64, int, int, bool) error
-type blockBodyFetcherFn func([]common.Hash) error
-type receiptFetcherFn func([]common.Hash) error
-type stateFetcherFn func([]common.Hash) error
+	rttInitialEstimate = 4 * time.Second  // Initial global target round-trip time, before any peer has reported one
+	rttMinEstimate     = 2 * time.Second  // Floor applied to the shrinking global target round-trip time
+	rttMaxEstimate     = 20 * time.Second // Ceiling applied to the global target round-trip time after a timeout
+	rttGrowthFactor    = 1.1              // Multiplier applied to the global target round-trip time on a timeout
+)
+
+// Peer encapsulates every remote retrieval call the downloader needs to
+// drive a sync against a single connected peer. A concrete p2p protocol
+// manager (eth/handler.go, les/handler.go) implements it over the wire;
+// tests can instead supply a stub, without hand-writing a closure per
+// request kind the way newPeer used to require.
+type Peer interface {
+	// Head returns the hash and total difficulty of the peer's best known block.
+	Head() (common.Hash, *big.Int)
+
+	// RequestHeadersByHash fetches a batch of headers starting at origin.
+	RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error
+	// RequestHeadersByNumber fetches a batch of headers starting at an absolute height.
+	RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error
+	// RequestBodies fetches a batch of blocks' bodies corresponding to the hashes given.
+	RequestBodies(hashes []common.Hash) error
+	// RequestReceipts fetches a batch of transaction receipts corresponding to the hashes given.
+	RequestReceipts(hashes []common.Hash) error
+	// RequestNodeData fetches a batch of state trie data corresponding to the hashes given.
+	RequestNodeData(hashes []common.Hash) error
+
+	// RequestAccountRange fetches a range of accounts from the state trie,
+	// bounded by [origin, limit] and a soft response size cap.
+	RequestAccountRange(origin, limit common.Hash, bytes uint64) error
+	// RequestStorageRanges fetches storage slot ranges for a batch of accounts.
+	RequestStorageRanges(accounts []common.Hash, origin, limit common.Hash, bytes uint64) error
+	// RequestByteCodes fetches a batch of contract byte codes by hash.
+	RequestByteCodes(hashes []common.Hash, bytes uint64) error
+	// RequestTrieNodes fetches a batch of trie nodes by path, used as a
+	// fallback when a range request cannot be satisfied with a proof alone.
+	RequestTrieNodes(paths [][][]byte, bytes uint64) error
+}
 
 var (
 	errAlreadyFetching   = errors.New("already fetching blocks from peer")
@@ -53,66 +87,136 @@ var (
 	errNotRegistered     = errors.New("peer is not registered")
 )
 
+// LacksConfig configures the eviction policy of a peer's lacking set (the
+// items it has told us, or been assumed, not to have). Capacity defaults to
+// maxLackingHashes when left zero.
+type LacksConfig struct {
+	Capacity int // Maximum number of hashes remembered per peer before the oldest is evicted
+}
+
+// lackingSet is a fixed-capacity FIFO of hashes a peer is known not to have.
+// It evicts the oldest entry first once full, unlike a plain map (whose
+// random Go iteration order could evict an entry moments after it was
+// inserted, letting the downloader immediately re-request something the
+// peer just refused).
+type lackingSet struct {
+	capacity int
+	order    []common.Hash
+	have     map[common.Hash]struct{}
+}
+
+// newLackingSet creates an empty lackingSet honouring cfg's capacity.
+func newLackingSet(cfg LacksConfig) *lackingSet {
+	capacity := cfg.Capacity
+	if capacity <= 0 {
+		capacity = maxLackingHashes
+	}
+	return &lackingSet{
+		capacity: capacity,
+		have:     make(map[common.Hash]struct{}),
+	}
+}
+
+// mark records hash as lacking, evicting the oldest entry first if the set
+// is already at capacity.
+func (l *lackingSet) mark(hash common.Hash) {
+	if _, ok := l.have[hash]; ok {
+		return
+	}
+	if len(l.order) >= l.capacity {
+		oldest := l.order[0]
+		l.order = l.order[1:]
+		delete(l.have, oldest)
+	}
+	l.order = append(l.order, hash)
+	l.have[hash] = struct{}{}
+}
+
+// has reports whether hash is currently in the lacking set.
+func (l *lackingSet) has(hash common.Hash) bool {
+	_, ok := l.have[hash]
+	return ok
+}
+
+// len returns the number of hashes currently tracked.
+func (l *lackingSet) len() int {
+	return len(l.order)
+}
+
+// reset returns a fresh, empty lackingSet with the same capacity.
+func (l *lackingSet) reset() *lackingSet {
+	return newLackingSet(LacksConfig{Capacity: l.capacity})
+}
+
 // peer represents an active peer from which hashes and blocks are retrieved.
 type peer struct {
-	id   string      // Unique identifier of the peer
-	head common.Hash // Hash of the peers latest known block
+	id string // Unique identifier of the peer
 
 	blockIdle   int32 // Current block activity state of the peer (idle = 0, active = 1)
 	receiptIdle int32 // Current receipt activity state of the peer (idle = 0, active = 1)
 	stateIdle   int32 // Current node data activity state of the peer (idle = 0, active = 1)
 
+	accountRangeIdle  int32 // Current account-range activity state of the peer (idle = 0, active = 1)
+	storageRangesIdle int32 // Current storage-range activity state of the peer (idle = 0, active = 1)
+	byteCodesIdle     int32 // Current byte-code activity state of the peer (idle = 0, active = 1)
+	trieNodesIdle     int32 // Current trie-node activity state of the peer (idle = 0, active = 1)
+
 	blockThroughput   float64 // Number of blocks (bodies) measured to be retrievable per second
 	receiptThroughput float64 // Number of receipts measured to be retrievable per second
 	stateThroughput   float64 // Number of node data pieces measured to be retrievable per second
 
+	accountRangeThroughput  float64 // Number of account-range entries measured to be retrievable per second
+	storageRangesThroughput float64 // Number of storage-range entries measured to be retrievable per second
+	byteCodesThroughput     float64 // Number of byte codes measured to be retrievable per second
+	trieNodesThroughput     float64 // Number of trie nodes measured to be retrievable per second
+
 	blockStarted   time.Time // Time instance when the last block (body)fetch was started
 	receiptStarted time.Time // Time instance when the last receipt fetch was started
 	stateStarted   time.Time // Time instance when the last node data fetch was started
 
-	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
+	accountRangeStarted  time.Time // Time instance when the last account-range fetch was started
+	storageRangesStarted time.Time // Time instance when the last storage-range fetch was started
+	byteCodesStarted     time.Time // Time instance when the last byte-code fetch was started
+	trieNodesStarted     time.Time // Time instance when the last trie-node fetch was started
 
-	getRelHashes relativeHashFetcherFn // [eth/61] Method to retrieve a batch of hashes from an origin hash
-	getAbsHashes absoluteHashFetcherFn // [eth/61] Method to retrieve a batch of hashes from an absolute position
-	getBlocks    blockFetcherFn        // [eth/61] Method to retrieve a batch of blocks
+	rtt time.Duration // EMA-estimated round-trip time of this peer's fetch requests
 
-	getRelHeaders  relativeHeaderFetcherFn // [eth/62] Method to retrieve a batch of headers from an origin hash
-	getAbsHeaders  absoluteHeaderFetcherFn // [eth/62] Method to retrieve a batch of headers from an absolute position
-	getBlockBodies blockBodyFetcherFn      // [eth/62] Method to retrieve a batch of block bodies
+	lacking *lackingSet // Bounded FIFO of hashes not to request (didn't have previously)
 
-	getReceipts receiptFetcherFn // [eth/63] Method to retrieve a batch of block transaction receipts
-	getNodeData stateFetcherFn   // [eth/63] Method to retrieve a batch of state trie data
+	dropped int32 // Non-zero once QosTune has found this peer's throughput below the reputation floor
+
+	targetRTT *int64 // Pointer to the owning peerSet's shared, atomically-updated target round-trip time (ns)
+
+	peer Peer // Remote retrieval methods, implemented by the owning protocol manager
 
 	version int // Eth protocol version number to switch strategies
 	lock    sync.RWMutex
 }
 
-// newPeer create a new downloader peer, with specific hash and block retrieval
-// mechanisms.
-func newPeer(id string, version int, head common.Hash,
-	getRelHashes relativeHashFetcherFn, getAbsHashes absoluteHashFetcherFn, getBlocks blockFetcherFn, // eth/61 callbacks, remove when upgrading
-	getRelHeaders relativeHeaderFetcherFn, getAbsHeaders absoluteHeaderFetcherFn, getBlockBodies blockBodyFetcherFn,
-	getReceipts receiptFetcherFn, getNodeData stateFetcherFn) *peer {
+// newPeer create a new downloader peer, backed by the retrieval methods of peer.
+func newPeer(id string, version int, peer Peer) *peer {
+	return newPeerWithLacksConfig(id, version, peer, LacksConfig{})
+}
+
+// newPeerWithLacksConfig is newPeer, but lets the caller override the
+// lacking-set eviction capacity instead of defaulting to maxLackingHashes.
+func newPeerWithLacksConfig(id string, version int, remote Peer, lacksCfg LacksConfig) *peer {
 	return &peer{
 		id:      id,
-		head:    head,
-		lacking: make(map[common.Hash]struct{}),
-
-		getRelHashes: getRelHashes,
-		getAbsHashes: getAbsHashes,
-		getBlocks:    getBlocks,
-
-		getRelHeaders:  getRelHeaders,
-		getAbsHeaders:  getAbsHeaders,
-		getBlockBodies: getBlockBodies,
-
-		getReceipts: getReceipts,
-		getNodeData: getNodeData,
-
+		lacking: newLackingSet(lacksCfg),
+		peer:    remote,
 		version: version,
 	}
 }
 
+// Head returns the peer's current best known block hash and total
+// difficulty, looked up fresh on every call instead of a value captured
+// once at registration time, so fork/pivot selection always sees the
+// remote's latest announcement without needing to re-register the peer.
+func (p *peer) Head() (common.Hash, *big.Int) {
+	return p.peer.Head()
+}
+
 // Reset clears the internal state of a peer entity.
 func (p *peer) Reset() {
 	p.lock.Lock()
@@ -121,34 +225,21 @@ func (p *peer) Reset() {
 	atomic.StoreInt32(&p.blockIdle, 0)
 	atomic.StoreInt32(&p.receiptIdle, 0)
 	atomic.StoreInt32(&p.stateIdle, 0)
+	atomic.StoreInt32(&p.accountRangeIdle, 0)
+	atomic.StoreInt32(&p.storageRangesIdle, 0)
+	atomic.StoreInt32(&p.byteCodesIdle, 0)
+	atomic.StoreInt32(&p.trieNodesIdle, 0)
+	atomic.StoreInt32(&p.dropped, 0)
 
 	p.blockThroughput = 0
 	p.receiptThroughput = 0
 	p.stateThroughput = 0
+	p.accountRangeThroughput = 0
+	p.storageRangesThroughput = 0
+	p.byteCodesThroughput = 0
+	p.trieNodesThroughput = 0
 
-	p.lacking = make(map[common.Hash]struct{})
-}
-
-// Fetch61 sends a block retrieval request to the remote peer.
-func (p *peer) Fetch61(request *fetchRequest) error {
-	// Sanity check the protocol version
-	if p.version != 61 {
-		panic(fmt.Sprintf("block fetch [eth/61] requested on eth/%d", p.version))
-	}
-	// Short circuit if the peer is already fetching
-	if !atomic.CompareAndSwapInt32(&p.blockIdle, 0, 1) {
-		return errAlreadyFetching
-	}
-	p.blockStarted = time.Now()
-
-	// Convert the hash set to a retrievable slice
-	hashes := make([]common.Hash, 0, len(request.Hashes))
-	for hash, _ := range request.Hashes {
-		hashes = append(hashes, hash)
-	}
-	go p.getBlocks(hashes)
-
-	return nil
+	p.lacking = p.lacking.reset()
 }
 
 // FetchBodies sends a block body retrieval request to the remote peer.
@@ -168,7 +259,7 @@ func (p *peer) FetchBodies(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.getBlockBodies(hashes)
+	go p.peer.RequestBodies(hashes)
 
 	return nil
 }
@@ -190,7 +281,7 @@ func (p *peer) FetchReceipts(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.getReceipts(hashes)
+	go p.peer.RequestReceipts(hashes)
 
 	return nil
 }
@@ -212,11 +303,72 @@ func (p *peer) FetchNodeData(request *fetchRequest) error {
 	for hash, _ := range request.Hashes {
 		hashes = append(hashes, hash)
 	}
-	go p.getNodeData(hashes)
+	go p.peer.RequestNodeData(hashes)
 
 	return nil
 }
 
+// FetchAccountRange sends a state trie account-range retrieval request to
+// the remote peer, covering keys in [origin, limit] up to a soft bytes cap.
+func (p *peer) FetchAccountRange(origin, limit common.Hash, bytes uint64) error {
+	if p.version < eth67 {
+		panic(fmt.Sprintf("account range fetch [eth/67+] requested on eth/%d", p.version))
+	}
+	if !atomic.CompareAndSwapInt32(&p.accountRangeIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.accountRangeStarted = time.Now()
+
+	go p.peer.RequestAccountRange(origin, limit, bytes)
+	return nil
+}
+
+// FetchStorageRanges sends a storage-range retrieval request, covering the
+// given accounts' slots in [origin, limit] up to a soft bytes cap.
+func (p *peer) FetchStorageRanges(accounts []common.Hash, origin, limit common.Hash, bytes uint64) error {
+	if p.version < eth67 {
+		panic(fmt.Sprintf("storage ranges fetch [eth/67+] requested on eth/%d", p.version))
+	}
+	if !atomic.CompareAndSwapInt32(&p.storageRangesIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.storageRangesStarted = time.Now()
+
+	go p.peer.RequestStorageRanges(accounts, origin, limit, bytes)
+	return nil
+}
+
+// FetchByteCodes sends a contract byte-code retrieval request for the given
+// code hashes, up to a soft bytes cap.
+func (p *peer) FetchByteCodes(hashes []common.Hash, bytes uint64) error {
+	if p.version < eth67 {
+		panic(fmt.Sprintf("byte codes fetch [eth/67+] requested on eth/%d", p.version))
+	}
+	if !atomic.CompareAndSwapInt32(&p.byteCodesIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.byteCodesStarted = time.Now()
+
+	go p.peer.RequestByteCodes(hashes, bytes)
+	return nil
+}
+
+// FetchTrieNodes sends a fallback trie-node retrieval request, used when an
+// account or storage range request cannot be satisfied with a proof alone,
+// up to a soft bytes cap.
+func (p *peer) FetchTrieNodes(paths [][][]byte, bytes uint64) error {
+	if p.version < eth67 {
+		panic(fmt.Sprintf("trie nodes fetch [eth/67+] requested on eth/%d", p.version))
+	}
+	if !atomic.CompareAndSwapInt32(&p.trieNodesIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.trieNodesStarted = time.Now()
+
+	go p.peer.RequestTrieNodes(paths, bytes)
+	return nil
+}
+
 // SetBlocksIdle sets the peer to idle, allowing it to execute new block retrieval
 // requests. Its estimated block retrieval throughput is updated with that measured
 // just now.
@@ -245,8 +397,37 @@ func (p *peer) SetNodeDataIdle(delivered int) {
 	p.setIdle(p.stateStarted, delivered, &p.stateThroughput, &p.stateIdle)
 }
 
+// SetAccountRangeIdle sets the peer to idle, allowing it to execute new
+// account-range retrieval requests. Its estimated throughput is updated
+// with that measured just now.
+func (p *peer) SetAccountRangeIdle(delivered int) {
+	p.setIdle(p.accountRangeStarted, delivered, &p.accountRangeThroughput, &p.accountRangeIdle)
+}
+
+// SetStorageRangesIdle sets the peer to idle, allowing it to execute new
+// storage-range retrieval requests. Its estimated throughput is updated
+// with that measured just now.
+func (p *peer) SetStorageRangesIdle(delivered int) {
+	p.setIdle(p.storageRangesStarted, delivered, &p.storageRangesThroughput, &p.storageRangesIdle)
+}
+
+// SetByteCodesIdle sets the peer to idle, allowing it to execute new
+// byte-code retrieval requests. Its estimated throughput is updated with
+// that measured just now.
+func (p *peer) SetByteCodesIdle(delivered int) {
+	p.setIdle(p.byteCodesStarted, delivered, &p.byteCodesThroughput, &p.byteCodesIdle)
+}
+
+// SetTrieNodesIdle sets the peer to idle, allowing it to execute new
+// trie-node retrieval requests. Its estimated throughput is updated with
+// that measured just now.
+func (p *peer) SetTrieNodesIdle(delivered int) {
+	p.setIdle(p.trieNodesStarted, delivered, &p.trieNodesThroughput, &p.trieNodesIdle)
+}
+
 // setIdle sets the peer to idle, allowing it to execute new retrieval requests.
-// Its estimated retrieval throughput is updated with that measured just now.
+// Its estimated retrieval throughput and round-trip time are updated with
+// that measured just now.
 func (p *peer) setIdle(started time.Time, delivered int, throughput *float64, idle *int32) {
 	// Irrelevant of the scaling, make sure the peer ends up idle
 	defer atomic.StoreInt32(idle, 0)
@@ -254,57 +435,119 @@ func (p *peer) setIdle(started time.Time, delivered int, throughput *float64, id
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	elapsed := time.Since(started)
+	p.rtt = time.Duration((1-throughputImpact)*float64(p.rtt) + throughputImpact*float64(elapsed))
+
 	// If nothing was delivered (hard timeout / unavailable data), reduce throughput to minimum
 	if delivered == 0 {
 		*throughput = 0
 		return
 	}
 	// Otherwise update the throughput with a new measurement
-	measured := float64(delivered) / (float64(time.Since(started)+1) / float64(time.Second)) // +1 (ns) to ensure non-zero divisor
+	measured := float64(delivered) / (float64(elapsed+1) / float64(time.Second)) // +1 (ns) to ensure non-zero divisor
 	*throughput = (1-throughputImpact)*(*throughput) + throughputImpact*measured
 }
 
+// RTT returns the peer's current estimated round-trip time for a fetch request.
+func (p *peer) RTT() time.Duration {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.rtt
+}
+
+// targetRTTDuration returns the global target round-trip time shared across
+// the owning peerSet, or rttInitialEstimate if the peer hasn't been
+// registered into one yet.
+func (p *peer) targetRTTDuration() time.Duration {
+	if p.targetRTT == nil {
+		return rttInitialEstimate
+	}
+	return time.Duration(atomic.LoadInt64(p.targetRTT))
+}
+
+// capacity scales throughput by how the peer's own round-trip time compares
+// to the global target, so a faster, closer peer is handed proportionally
+// larger batches than a slow one, clamped to [1, max].
+func (p *peer) capacity(throughput float64, max int) int {
+	rtt := p.rtt
+	if rtt <= 0 {
+		rtt = p.targetRTTDuration()
+	}
+	return int(math.Max(1, math.Min(throughput*float64(p.targetRTTDuration())/float64(rtt), float64(max))))
+}
+
 // BlockCapacity retrieves the peers block download allowance based on its
-// previously discovered throughput.
+// previously discovered throughput and round-trip time.
 func (p *peer) BlockCapacity() int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	return int(math.Max(1, math.Min(p.blockThroughput*float64(blockTargetRTT)/float64(time.Second), float64(MaxBlockFetch))))
+	return p.capacity(p.blockThroughput, MaxBlockFetch)
 }
 
 // ReceiptCapacity retrieves the peers receipt download allowance based on its
-// previously discovered throughput.
+// previously discovered throughput and round-trip time.
 func (p *peer) ReceiptCapacity() int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	return int(math.Max(1, math.Min(p.receiptThroughput*float64(receiptTargetRTT)/float64(time.Second), float64(MaxReceiptFetch))))
+	return p.capacity(p.receiptThroughput, MaxReceiptFetch)
 }
 
 // NodeDataCapacity retrieves the peers state download allowance based on its
-// previously discovered throughput.
+// previously discovered throughput and round-trip time.
 func (p *peer) NodeDataCapacity() int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	return int(math.Max(1, math.Min(p.stateThroughput*float64(stateTargetRTT)/float64(time.Second), float64(MaxStateFetch))))
+	return p.capacity(p.stateThroughput, MaxStateFetch)
+}
+
+// AccountRangeCapacity retrieves the peers account-range download allowance
+// based on its previously discovered throughput and round-trip time.
+func (p *peer) AccountRangeCapacity() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.capacity(p.accountRangeThroughput, MaxAccountRangeFetch)
+}
+
+// StorageRangesCapacity retrieves the peers storage-range download allowance
+// based on its previously discovered throughput and round-trip time.
+func (p *peer) StorageRangesCapacity() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.capacity(p.storageRangesThroughput, MaxStorageRangesFetch)
+}
+
+// ByteCodesCapacity retrieves the peers byte-code download allowance based
+// on its previously discovered throughput and round-trip time.
+func (p *peer) ByteCodesCapacity() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.capacity(p.byteCodesThroughput, MaxByteCodesFetch)
+}
+
+// TrieNodesCapacity retrieves the peers trie-node download allowance based
+// on its previously discovered throughput and round-trip time.
+func (p *peer) TrieNodesCapacity() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.capacity(p.trieNodesThroughput, MaxTrieNodesFetch)
 }
 
 // MarkLacking appends a new entity to the set of items (blocks, receipts, states)
-// that a peer is known not to have (i.e. have been requested before). If the
-// set reaches its maximum allowed capacity, items are randomly dropped off.
+// that a peer is known not to have (i.e. have been requested before). Once the
+// set reaches its configured capacity, the oldest entry is evicted first.
 func (p *peer) MarkLacking(hash common.Hash) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	for len(p.lacking) >= maxLackingHashes {
-		for drop, _ := range p.lacking {
-			delete(p.lacking, drop)
-			break
-		}
-	}
-	p.lacking[hash] = struct{}{}
+	p.lacking.mark(hash)
 }
 
 // Lacks retrieves whether the hash of a blockchain item is on the peers lacking
@@ -313,8 +556,7 @@ func (p *peer) Lacks(hash common.Hash) bool {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	_, ok := p.lacking[hash]
-	return ok
+	return p.lacking.has(hash)
 }
 
 // String implements fmt.Stringer.
@@ -326,7 +568,7 @@ func (p *peer) String() string {
 		fmt.Sprintf("blocks %3.2f/s, ", p.blockThroughput)+
 			fmt.Sprintf("receipts %3.2f/s, ", p.receiptThroughput)+
 			fmt.Sprintf("states %3.2f/s, ", p.stateThroughput)+
-			fmt.Sprintf("lacking %4d", len(p.lacking)),
+			fmt.Sprintf("lacking %4d", p.lacking.len()),
 	)
 }
 
@@ -335,13 +577,26 @@ func (p *peer) String() string {
 type peerSet struct {
 	peers map[string]*peer
 	lock  sync.RWMutex
+
+	targetRTT int64 // Shared, atomically-updated target round-trip time (ns), handed out to every registered peer
+
+	blockThroughputMeter   gometrics.Meter // Meter for the aggregate, QosTune-measured block throughput
+	receiptThroughputMeter gometrics.Meter // Meter for the aggregate, QosTune-measured receipt throughput
+	stateThroughputMeter   gometrics.Meter // Meter for the aggregate, QosTune-measured state throughput
 }
 
 // newPeerSet creates a new peer set top track the active download sources.
 func newPeerSet() *peerSet {
-	return &peerSet{
-		peers: make(map[string]*peer),
+	ps := &peerSet{
+		peers:     make(map[string]*peer),
+		targetRTT: int64(rttInitialEstimate),
+	}
+	if metrics.Enabled {
+		ps.blockThroughputMeter = metrics.NewMeter("peer/throughput/blocks")
+		ps.receiptThroughputMeter = metrics.NewMeter("peer/throughput/receipts")
+		ps.stateThroughputMeter = metrics.NewMeter("peer/throughput/states")
 	}
+	return ps
 }
 
 // Reset iterates over the current peer set, and resets each of the known peers
@@ -368,6 +623,8 @@ func (ps *peerSet) Register(p *peer) error {
 	if _, ok := ps.peers[p.id]; ok {
 		return errAlreadyRegistered
 	}
+	p.targetRTT = &ps.targetRTT
+
 	if len(ps.peers) > 0 {
 		p.blockThroughput, p.receiptThroughput, p.stateThroughput = 0, 0, 0
 
@@ -483,6 +740,21 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peer, int) {
 	return ps.idlePeers(63, 64, idle, throughput)
 }
 
+// SnapIdlePeers retrieves a flat list of all the currently account-range-idle
+// peers within the active peer set that speak eth/67 or above, ordered by
+// their reputation.
+func (ps *peerSet) SnapIdlePeers() ([]*peer, int) {
+	idle := func(p *peer) bool {
+		return atomic.LoadInt32(&p.accountRangeIdle) == 0
+	}
+	throughput := func(p *peer) float64 {
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		return p.accountRangeThroughput
+	}
+	return ps.idlePeers(eth67, math.MaxInt32, idle, throughput)
+}
+
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
 // protocol version constraints, using the provided function to check idleness.
 // The resulting set of peers are sorted by their measure throughput.
@@ -493,18 +765,117 @@ func (ps *peerSet) idlePeers(minProtocol, maxProtocol int, idleCheck func(*peer)
 	idle, total := make([]*peer, 0, len(ps.peers)), 0
 	for _, p := range ps.peers {
 		if p.version >= minProtocol && p.version <= maxProtocol {
-			if idleCheck(p) {
+			if atomic.LoadInt32(&p.dropped) == 0 && idleCheck(p) {
 				idle = append(idle, p)
 			}
 			total++
 		}
 	}
-	for i := 0; i < len(idle); i++ {
-		for j := i + 1; j < len(idle); j++ {
-			if throughput(idle[i]) < throughput(idle[j]) {
-				idle[i], idle[j] = idle[j], idle[i]
+	sort.Slice(idle, func(i, j int) bool {
+		return throughput(idle[i]) > throughput(idle[j])
+	})
+	return idle, total
+}
+
+// QosTune recomputes each peer's reputation from the current spread of
+// measured throughput, dropping from idle-peer scheduling any peer whose
+// block throughput has fallen to below qosReputationFloor of the group's
+// median, and reports the aggregate throughput distribution via
+// prometheus-style meters. It also lets the shared target round-trip time
+// shrink towards the peers' own median RTT, so request sizing tightens up
+// again once NoteTimeout has grown it. It is meant to be called
+// periodically by the sync loop driving this peer set.
+func (ps *peerSet) QosTune() {
+	ps.lock.RLock()
+	blocks := make([]float64, 0, len(ps.peers))
+	receipts := make([]float64, 0, len(ps.peers))
+	states := make([]float64, 0, len(ps.peers))
+	rtts := make([]float64, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		p.lock.RLock()
+		blocks = append(blocks, p.blockThroughput)
+		receipts = append(receipts, p.receiptThroughput)
+		states = append(states, p.stateThroughput)
+		rtts = append(rtts, float64(p.rtt))
+		p.lock.RUnlock()
+	}
+	ps.lock.RUnlock()
+
+	floor := qosReputationFloor * median(blocks)
+
+	ps.lock.RLock()
+	for _, p := range ps.peers {
+		p.lock.RLock()
+		dropped := p.blockThroughput < floor
+		p.lock.RUnlock()
+
+		if dropped {
+			atomic.StoreInt32(&p.dropped, 1)
+		} else {
+			atomic.StoreInt32(&p.dropped, 0)
+		}
+	}
+	ps.lock.RUnlock()
+
+	if medianRTT := time.Duration(median(rtts)); medianRTT > 0 {
+		for {
+			current := atomic.LoadInt64(&ps.targetRTT)
+			if medianRTT >= time.Duration(current) {
+				break // never shrink above the current target here; NoteTimeout owns growth
+			}
+			target := time.Duration((1-throughputImpact)*float64(current) + throughputImpact*float64(medianRTT))
+			if target < rttMinEstimate {
+				target = rttMinEstimate
+			}
+			if atomic.CompareAndSwapInt64(&ps.targetRTT, current, int64(target)) {
+				break
 			}
 		}
 	}
-	return idle, total
+
+	if ps.blockThroughputMeter != nil {
+		ps.blockThroughputMeter.Mark(int64(sum(blocks)))
+		ps.receiptThroughputMeter.Mark(int64(sum(receipts)))
+		ps.stateThroughputMeter.Mark(int64(sum(states)))
+	}
+}
+
+// NoteTimeout grows the shared target round-trip time after a fetch request
+// failed to complete in time, so future requests (to any peer) are sized
+// more conservatively until QosTune shrinks it back down.
+func (ps *peerSet) NoteTimeout() {
+	for {
+		current := atomic.LoadInt64(&ps.targetRTT)
+		target := time.Duration(float64(current) * rttGrowthFactor)
+		if target > rttMaxEstimate {
+			target = rttMaxEstimate
+		}
+		if atomic.CompareAndSwapInt64(&ps.targetRTT, current, int64(target)) {
+			return
+		}
+	}
+}
+
+// median returns the median of vals, or 0 for an empty slice.
+func median(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// sum returns the sum of vals.
+func sum(vals []float64) float64 {
+	var total float64
+	for _, v := range vals {
+		total += v
+	}
+	return total
 }
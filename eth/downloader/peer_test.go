@@ -0,0 +1,73 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// stubPeer is a no-op Peer used to construct a *peer for unit tests that
+// don't exercise the retrieval methods themselves.
+type stubPeer struct{}
+
+func (stubPeer) Head() (common.Hash, *big.Int)                          { return common.Hash{}, nil }
+func (stubPeer) RequestHeadersByHash(common.Hash, int, int, bool) error { return nil }
+func (stubPeer) RequestHeadersByNumber(uint64, int, int, bool) error    { return nil }
+func (stubPeer) RequestBodies([]common.Hash) error                     { return nil }
+func (stubPeer) RequestReceipts([]common.Hash) error                    { return nil }
+func (stubPeer) RequestNodeData([]common.Hash) error                    { return nil }
+func (stubPeer) RequestAccountRange(common.Hash, common.Hash, uint64) error { return nil }
+func (stubPeer) RequestStorageRanges([]common.Hash, common.Hash, common.Hash, uint64) error {
+	return nil
+}
+func (stubPeer) RequestByteCodes([]common.Hash, uint64) error { return nil }
+func (stubPeer) RequestTrieNodes([][][]byte, uint64) error    { return nil }
+
+// TestLackingSetEvictsOldestFirst verifies that once the lacking set fills
+// up, inserting k more hashes evicts exactly the k oldest ones, leaving the
+// most recently marked capacity hashes still reported as lacking.
+func TestLackingSetEvictsOldestFirst(t *testing.T) {
+	const capacity = 16
+	const extra = 5
+
+	p := newPeerWithLacksConfig("test", 63, stubPeer{}, LacksConfig{Capacity: capacity})
+
+	hashes := make([]common.Hash, capacity+extra)
+	for i := range hashes {
+		hashes[i] = common.BytesToHash([]byte{byte(i)})
+		p.MarkLacking(hashes[i])
+	}
+
+	for i, hash := range hashes {
+		want := i >= extra // the first `extra` insertions should have been evicted
+		if got := p.Lacks(hash); got != want {
+			t.Errorf("hash %d: Lacks = %v, want %v", i, got, want)
+		}
+	}
+}
+
+// TestLackingSetDefaultCapacity checks that an unconfigured lacking set
+// falls back to maxLackingHashes.
+func TestLackingSetDefaultCapacity(t *testing.T) {
+	p := newPeer("test", 63, stubPeer{})
+	if cap := p.lacking.capacity; cap != maxLackingHashes {
+		t.Errorf("default capacity = %d, want %d", cap, maxLackingHashes)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the configuration knobs of the gas price oracle, split out of
+// eth.Config so the oracle can be constructed and tested independently of
+// the rest of the Ethereum service.
+
+package gasprice
+
+import "math/big"
+
+// Config bundles the tunables of the gas price oracle's "gpobase" moving
+// average strategy: the price is nudged up or down block-by-block depending
+// on how full recent blocks were, clamped to [MinGasPrice, MaxGasPrice].
+type Config struct {
+	MinGasPrice *big.Int // Gas price below which the oracle never suggests
+	MaxGasPrice *big.Int // Gas price above which the oracle never suggests
+
+	FullBlockRatio int // Percentage of a block that must be full for it to count towards the average
+
+	StepDown         int // Percentage to drop the gas price estimate by when blocks are below FullBlockRatio
+	StepUp           int // Percentage to raise the gas price estimate by when blocks are at or above FullBlockRatio
+	CorrectionFactor int // Percentage correction factor applied to the blended historical average
+}
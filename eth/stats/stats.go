@@ -0,0 +1,230 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package stats implements the network stats reporting service: it keeps a
+// websocket connection open to a github.com/ethereum/eth-netstats-style
+// dashboard and pushes this node's chain head, tx pool size, peer count and
+// mining hashrate to it as JSON frames, so an operator gets a community
+// dashboard without running a separate sidecar process.
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/les"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// reportInterval is how often a full stats update (block, pending
+	// transactions, peers, hashrate) is pushed while the connection is up.
+	reportInterval = 10 * time.Second
+
+	// backoffMin and backoffMax bound the reconnect delay: it starts at
+	// backoffMin and doubles on every failed dial, capped at backoffMax, so a
+	// stats server that's briefly down doesn't get hammered with retries.
+	backoffMin = 2 * time.Second
+	backoffMax = 64 * time.Second
+)
+
+// Service is the node.Service that maintains the connection to the remote
+// stats server and periodically reports this node's status to it. A nil
+// lesServ is fine: either ethServ or lesServ is reported against, never both.
+type Service struct {
+	server string // host:port of the stats server to dial
+	pass   string // Secret shared with the stats server to authenticate this node
+	node   string // Name this node identifies itself as on the dashboard
+
+	ethServ *eth.Ethereum
+	lesServ *les.LightEthereum
+	p2pServ *p2p.Server // set by Start; used to read the live peer count
+
+	quitCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New parses a nodename:secret@host:port URL, as accepted by EthStatsURLFlag,
+// and returns a Service ready to be registered with node.Node. Exactly one of
+// ethServ or lesServ should be non-nil -- whichever protocol manager this
+// node actually registered.
+func New(url string, ethServ *eth.Ethereum, lesServ *les.LightEthereum) (*Service, error) {
+	node, auth, server, err := parseEthstatsURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		server:  server,
+		pass:    auth,
+		node:    node,
+		ethServ: ethServ,
+		lesServ: lesServ,
+		quitCh:  make(chan struct{}),
+	}, nil
+}
+
+// parseEthstatsURL splits a nodename:secret@host:port URL into its three parts.
+func parseEthstatsURL(url string) (node, auth, server string, err error) {
+	parts := strings.Split(url, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid ethstats URL %q, want nodename:secret@host:port", url)
+	}
+	server = parts[1]
+
+	creds := strings.SplitN(parts[0], ":", 2)
+	if len(creds) != 2 || creds[0] == "" {
+		return "", "", "", fmt.Errorf("invalid ethstats URL %q, want nodename:secret@host:port", url)
+	}
+	return creds[0], creds[1], server, nil
+}
+
+// Protocols implements node.Service: the stats reporter runs no p2p protocol
+// of its own, it only rides along the already-registered eth/les protocols.
+func (s *Service) Protocols() []p2p.Protocol { return nil }
+
+// APIs implements node.Service: the stats reporter exposes nothing over RPC.
+func (s *Service) APIs() []rpc.API { return nil }
+
+// Start implements node.Service, spawning the background reporting loop.
+func (s *Service) Start(server *p2p.Server) error {
+	s.p2pServ = server
+	s.wg.Add(1)
+	go s.loop()
+	return nil
+}
+
+// Stop implements node.Service, terminating the background reporting loop.
+func (s *Service) Stop() error {
+	close(s.quitCh)
+	s.wg.Wait()
+	return nil
+}
+
+// loop maintains the websocket connection to the stats server, reconnecting
+// with exponential backoff whenever it drops, and pushes a report every
+// reportInterval while connected.
+func (s *Service) loop() {
+	defer s.wg.Done()
+
+	backoff := backoffMin
+	for {
+		conn, err := s.dial()
+		if err != nil {
+			glog.V(logger.Warn).Infof("ethstats: connection to %s failed: %v, retrying in %v", s.server, err, backoff)
+			select {
+			case <-time.After(backoff):
+				if backoff *= 2; backoff > backoffMax {
+					backoff = backoffMax
+				}
+				continue
+			case <-s.quitCh:
+				return
+			}
+		}
+		backoff = backoffMin
+
+		if !s.reportLoop(conn) {
+			return
+		}
+	}
+}
+
+// dial opens the websocket connection and performs the hello/auth handshake.
+func (s *Service) dial() (*websocket.Conn, error) {
+	conn, err := websocket.Dial(fmt.Sprintf("ws://%s/api", s.server), "", "http://"+s.server)
+	if err != nil {
+		return nil, err
+	}
+	hello := map[string]interface{}{
+		"id":     s.node,
+		"secret": s.pass,
+	}
+	if err := websocket.JSON.Send(conn, map[string]interface{}{"emit": []interface{}{"hello", hello}}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// reportLoop pushes a status report every reportInterval until the
+// connection drops or the service is asked to stop. It returns false once
+// the service should shut down entirely, true if it should just reconnect.
+func (s *Service) reportLoop(conn *websocket.Conn) bool {
+	defer conn.Close()
+
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.report(conn); err != nil {
+				glog.V(logger.Warn).Infof("ethstats: report to %s failed: %v", s.server, err)
+				return true
+			}
+		case <-s.quitCh:
+			return false
+		}
+	}
+}
+
+// report gathers this node's current block, pending transaction count, peer
+// count and mining hashrate and pushes them as a single JSON frame.
+func (s *Service) report(conn *websocket.Conn) error {
+	block, pending, peers, hashrate, err := s.status()
+	if err != nil {
+		return err
+	}
+	update := map[string]interface{}{
+		"id": s.node,
+		"stats": map[string]interface{}{
+			"block":    block,
+			"pending":  pending,
+			"peers":    peers,
+			"hashrate": hashrate,
+		},
+	}
+	return websocket.JSON.Send(conn, map[string]interface{}{"emit": []interface{}{"update", update}})
+}
+
+// status snapshots the current block header, pending tx count, peer count
+// and mining hashrate from whichever of ethServ/lesServ is registered.
+func (s *Service) status() (block *core.Header, pending int, peers int, hashrate int64, err error) {
+	if s.p2pServ != nil {
+		peers = s.p2pServ.PeerCount()
+	}
+	switch {
+	case s.ethServ != nil:
+		block = s.ethServ.BlockChain().CurrentHeader()
+		pending, _ = s.ethServ.TxPool().Stats()
+		hashrate = s.ethServ.Miner().HashRate()
+	case s.lesServ != nil:
+		block = s.lesServ.BlockChain().CurrentHeader()
+	default:
+		return nil, 0, 0, 0, errors.New("ethstats: no registered Ethereum service")
+	}
+	return block, pending, peers, hashrate, nil
+}
@@ -28,8 +28,8 @@ import (
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
-	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 
 	gometrics "github.com/rcrowley/go-metrics"
 )
@@ -115,6 +115,11 @@ func (self *LDBDatabase) Put(key []byte, value []byte) error {
 	return self.db.Put(key, value, nil)
 }
 
+// Has reports whether key is present in the database.
+func (self *LDBDatabase) Has(key []byte) (bool, error) {
+	return self.db.Has(key, nil)
+}
+
 // Get returns the given key if it's present.
 func (self *LDBDatabase) Get(key []byte) ([]byte, error) {
 	// Measure the database get latency, if requested
@@ -147,10 +152,27 @@ func (self *LDBDatabase) Delete(key []byte) error {
 	return self.db.Delete(key, nil)
 }
 
-func (self *LDBDatabase) NewIterator() iterator.Iterator {
+func (self *LDBDatabase) NewIterator() Iterator {
 	return self.db.NewIterator(nil, nil)
 }
 
+// NewIteratorWithPrefix returns an iterator restricted to keys sharing the
+// given prefix.
+func (self *LDBDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return self.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+// Stat returns a leveldb property, e.g. "leveldb.stats".
+func (self *LDBDatabase) Stat(property string) (string, error) {
+	return self.db.GetProperty(property)
+}
+
+// Compact triggers a manual compaction of the key range [start, limit). A
+// nil start or limit is treated as unbounded in that direction.
+func (self *LDBDatabase) Compact(start, limit []byte) error {
+	return self.db.CompactRange(util.Range{Start: start, Limit: limit})
+}
+
 func (self *LDBDatabase) Close() {
 	// Stop the metrics collection to avoid internal database races
 	self.quitLock.Lock()
@@ -0,0 +1,502 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ethdb's freezer is an append-only store for finalized chain data
+// (headers, bodies, receipts, total difficulty) that is too large, and too
+// rarely mutated, to be worth keeping in LevelDB. Moving it out from under
+// LevelDB removes it from compaction entirely, which the compTimeMeter /
+// compReadMeter / compWriteMeter counters in LDBDatabase.meter show is the
+// dominant source of disk IO on a synced chain.
+package ethdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/golang/snappy"
+)
+
+// freezerTableSize is the maximum size, in bytes, that a single data file of
+// a freezerTable is allowed to reach before a new one is started.
+const freezerTableSize = 2 * 1000 * 1000 * 1000
+
+// freezerKinds are the ancient-store columns a Freezer maintains, one per
+// piece of finalized per-block data.
+var freezerKinds = []string{"hashes", "headers", "bodies", "receipts", "diffs"}
+
+// freezerDefaultOffset is the default confirmation depth: blocks younger
+// than this, measured from the chain head, are left in LevelDB because they
+// may still be involved in a reorg.
+const freezerDefaultOffset = 90000
+
+// indexEntry is the 8-byte on-disk representation of one item boundary in a
+// freezerTable: the data file it was written to, and the byte offset of the
+// item immediately following it. Item i therefore spans
+// [index[i].offset, index[i+1].offset) of file index[i+1].filenum.
+type indexEntry struct {
+	filenum uint32
+	offset  uint32
+}
+
+const indexEntrySize = 8
+
+func (e indexEntry) marshal() []byte {
+	buf := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint32(buf[:4], e.filenum)
+	binary.BigEndian.PutUint32(buf[4:], e.offset)
+	return buf
+}
+
+func (e *indexEntry) unmarshal(buf []byte) {
+	e.filenum = binary.BigEndian.Uint32(buf[:4])
+	e.offset = binary.BigEndian.Uint32(buf[4:])
+}
+
+// freezerTable is an append-only sequence of size-capped data files for a
+// single kind of ancient item, together with an index file of indexEntry
+// records (one sentinel plus one per stored item) that gives O(1) random
+// reads without having to scan the data files.
+type freezerTable struct {
+	lock sync.RWMutex
+
+	name string
+	path string
+
+	head   *os.File            // data file currently being appended to
+	headId uint32              // number of head
+	files  map[uint32]*os.File // all data files opened so far, for reads
+
+	index *os.File // index file: (items+1) indexEntry records
+	items uint64   // number of items currently stored
+
+	headBytes uint32 // bytes written into head so far
+}
+
+func newFreezerTable(path, name string) (*freezerTable, error) {
+	t := &freezerTable{
+		name:  name,
+		path:  path,
+		files: make(map[uint32]*os.File),
+	}
+	if err := t.repair(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *freezerTable) indexFile() string { return filepath.Join(t.path, t.name+".ridx") }
+func (t *freezerTable) dataFile(n uint32) string {
+	return filepath.Join(t.path, fmt.Sprintf("%s.%04d.rdat", t.name, n))
+}
+
+// repair opens (creating if necessary) the index file, truncates any
+// trailing, partially-written index record left behind by a crash, and
+// positions the head data file for further appends.
+func (t *freezerTable) repair() error {
+	index, err := os.OpenFile(t.indexFile(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	t.index = index
+
+	stat, err := index.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+	if trailing := size % indexEntrySize; trailing != 0 {
+		size -= trailing
+		if err := index.Truncate(size); err != nil {
+			return err
+		}
+		glog.V(logger.Warn).Infof("freezer: truncated %d trailing bytes from %s", trailing, t.indexFile())
+	}
+	if size == 0 {
+		// Brand new table: seed the sentinel entry for item 0.
+		if _, err := index.Write(indexEntry{filenum: 0, offset: 0}.marshal()); err != nil {
+			return err
+		}
+		size = indexEntrySize
+	}
+	t.items = uint64(size/indexEntrySize) - 1
+
+	var last indexEntry
+	buf := make([]byte, indexEntrySize)
+	if _, err := index.ReadAt(buf, size-indexEntrySize); err != nil {
+		return err
+	}
+	last.unmarshal(buf)
+
+	head, err := os.OpenFile(t.dataFile(last.filenum), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := head.Truncate(int64(last.offset)); err != nil {
+		return err
+	}
+	t.head, t.headId, t.headBytes = head, last.filenum, last.offset
+	t.files[last.filenum] = head
+	return nil
+}
+
+// Items reports the number of items currently stored in the table.
+func (t *freezerTable) Items() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.items
+}
+
+// Append adds item as the next entry in the table, rolling over to a fresh
+// data file first if item would overflow freezerTableSize.
+func (t *freezerTable) Append(item []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	blob := snappy.Encode(nil, item)
+	if uint64(t.headBytes)+uint64(len(blob)) > freezerTableSize && t.headBytes > 0 {
+		next := t.headId + 1
+		file, err := os.OpenFile(t.dataFile(next), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		t.head, t.headId, t.headBytes = file, next, 0
+		t.files[next] = file
+	}
+	if _, err := t.head.Write(blob); err != nil {
+		return err
+	}
+	t.headBytes += uint32(len(blob))
+	t.items++
+
+	_, err := t.index.Write(indexEntry{filenum: t.headId, offset: t.headBytes}.marshal())
+	return err
+}
+
+// Retrieve returns the uncompressed item at the given (0-based) index.
+func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if item >= t.items {
+		return nil, fmt.Errorf("freezer: item %d out of bounds (have %d)", item, t.items)
+	}
+	var start, end indexEntry
+	buf := make([]byte, indexEntrySize)
+	if _, err := t.index.ReadAt(buf, int64(item)*indexEntrySize); err != nil {
+		return nil, err
+	}
+	start.unmarshal(buf)
+	if _, err := t.index.ReadAt(buf, int64(item+1)*indexEntrySize); err != nil {
+		return nil, err
+	}
+	end.unmarshal(buf)
+
+	file := t.files[end.filenum]
+	if file == nil {
+		f, err := os.Open(t.dataFile(end.filenum))
+		if err != nil {
+			return nil, err
+		}
+		t.files[end.filenum] = f
+		file = f
+	}
+	var from uint32
+	if start.filenum == end.filenum {
+		from = start.offset
+	}
+	blob := make([]byte, end.offset-from)
+	if _, err := file.ReadAt(blob, int64(from)); err != nil {
+		return nil, err
+	}
+	return snappy.Decode(nil, blob)
+}
+
+// truncate discards every item with index >= items, rewinding the head
+// data file and index accordingly.
+func (t *freezerTable) truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if items >= t.items {
+		return nil
+	}
+	buf := make([]byte, indexEntrySize)
+	if _, err := t.index.ReadAt(buf, int64(items)*indexEntrySize); err != nil {
+		return err
+	}
+	var cut indexEntry
+	cut.unmarshal(buf)
+
+	if err := t.index.Truncate(int64(items+1) * indexEntrySize); err != nil {
+		return err
+	}
+	for n, f := range t.files {
+		if n > cut.filenum {
+			f.Close()
+			os.Remove(t.dataFile(n))
+			delete(t.files, n)
+		}
+	}
+	head := t.files[cut.filenum]
+	if err := head.Truncate(int64(cut.offset)); err != nil {
+		return err
+	}
+	t.head, t.headId, t.headBytes = head, cut.filenum, cut.offset
+	t.items = items
+	return nil
+}
+
+func (t *freezerTable) sync() error {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if err := t.head.Sync(); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
+
+func (t *freezerTable) close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, f := range t.files {
+		f.Close()
+	}
+	t.index.Close()
+}
+
+// Freezer is the append-only ancient store sitting beneath an LDBDatabase.
+// Every freezerKinds column is kept in lock-step: AppendAncient writes one
+// item to each table atomically with respect to the others (best effort —
+// a crash mid-append is recovered by NewFreezer truncating each table back
+// to the shortest one on next open).
+type Freezer struct {
+	path   string
+	tables map[string]*freezerTable
+
+	frozen uint64 // block number one past the newest frozen item (atomic)
+}
+
+// NewFreezer opens (creating if necessary) the ancient store rooted at path.
+func NewFreezer(path string) (*Freezer, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	f := &Freezer{path: path, tables: make(map[string]*freezerTable, len(freezerKinds))}
+	var min uint64 = ^uint64(0)
+	for _, kind := range freezerKinds {
+		table, err := newFreezerTable(path, kind)
+		if err != nil {
+			return nil, err
+		}
+		f.tables[kind] = table
+		if n := table.Items(); n < min {
+			min = n
+		}
+	}
+	// Tables may disagree after a crash between two Append calls; clip them
+	// all back to the shortest one so every kind stays in lock-step.
+	for _, table := range f.tables {
+		if table.Items() > min {
+			if err := table.truncate(min); err != nil {
+				return nil, err
+			}
+		}
+	}
+	atomic.StoreUint64(&f.frozen, min)
+	return f, nil
+}
+
+// Ancient returns the requested piece of frozen data for the given block
+// number, or an error if it hasn't been frozen yet.
+func (f *Freezer) Ancient(kind string, number uint64) ([]byte, error) {
+	table, ok := f.tables[kind]
+	if !ok {
+		return nil, fmt.Errorf("freezer: unknown kind %q", kind)
+	}
+	return table.Retrieve(number)
+}
+
+// Frozen reports one past the highest block number currently held in the
+// ancient store.
+func (f *Freezer) Frozen() uint64 {
+	return atomic.LoadUint64(&f.frozen)
+}
+
+// AppendAncient freezes the given block's finalized data. number must equal
+// Frozen(); the caller is expected to migrate blocks from LevelDB strictly
+// in order.
+func (f *Freezer) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	if number != f.Frozen() {
+		return fmt.Errorf("freezer: out-of-order append, have %d want %d", number, f.Frozen())
+	}
+	for kind, item := range map[string][]byte{
+		"hashes": hash, "headers": header, "bodies": body, "receipts": receipts, "diffs": td,
+	} {
+		if err := f.tables[kind].Append(item); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, number+1)
+	return nil
+}
+
+// TruncateAncients discards every frozen item from block n onwards.
+func (f *Freezer) TruncateAncients(n uint64) error {
+	if n >= f.Frozen() {
+		return nil
+	}
+	for _, table := range f.tables {
+		if err := table.truncate(n); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, n)
+	return nil
+}
+
+// Sync flushes all ancient-store tables to stable storage.
+func (f *Freezer) Sync() error {
+	for _, table := range f.tables {
+		if err := table.sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the table file handles. The freezer is unusable afterwards.
+func (f *Freezer) Close() {
+	for _, table := range f.tables {
+		table.close()
+	}
+}
+
+// FreezerDB wraps an LDBDatabase with an ancient store for finalized chain
+// data, so Get/Has transparently fall through to the freezer once a key's
+// block has been migrated out of LevelDB and deleted there.
+type FreezerDB struct {
+	*LDBDatabase
+	freezer *Freezer
+
+	// resolve maps an ethdb key back to (kind, block number) so Get/Has can
+	// consult the freezer; it returns ok == false for keys the freezer
+	// doesn't know about (state trie nodes, indexes, ...).
+	resolve func(key []byte) (kind string, number uint64, ok bool)
+}
+
+// NewFreezerDB wraps db with an ancient store rooted at freezerPath. resolve
+// is supplied by the call site (core/rawdb in the full tree) since only it
+// knows the key encodings for headers/bodies/receipts/td.
+func NewFreezerDB(db *LDBDatabase, freezerPath string, resolve func(key []byte) (string, uint64, bool)) (*FreezerDB, error) {
+	freezer, err := NewFreezer(freezerPath)
+	if err != nil {
+		return nil, err
+	}
+	return &FreezerDB{LDBDatabase: db, freezer: freezer, resolve: resolve}, nil
+}
+
+// Has reports whether key is present in either the hot LevelDB store or the
+// frozen ancient store.
+func (db *FreezerDB) Has(key []byte) (bool, error) {
+	if _, err := db.LDBDatabase.Get(key); err == nil {
+		return true, nil
+	}
+	if kind, number, ok := db.resolve(key); ok && number < db.freezer.Frozen() {
+		if _, err := db.freezer.Ancient(kind, number); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Get returns key's value, checking LevelDB first and falling through to
+// the ancient store for keys that have already been migrated out of it.
+func (db *FreezerDB) Get(key []byte) ([]byte, error) {
+	val, err := db.LDBDatabase.Get(key)
+	if err == nil {
+		return val, nil
+	}
+	if kind, number, ok := db.resolve(key); ok && number < db.freezer.Frozen() {
+		return db.freezer.Ancient(kind, number)
+	}
+	return nil, err
+}
+
+// Freezer exposes the underlying ancient store, e.g. for the background
+// migration loop to call AppendAncient/TruncateAncients directly.
+func (db *FreezerDB) Freezer() *Freezer { return db.freezer }
+
+// Close shuts down both the hot and the ancient store.
+func (db *FreezerDB) Close() {
+	db.freezer.Close()
+	db.LDBDatabase.Close()
+}
+
+// AncientItem bundles one block's finalized data for hand-off to the
+// freeze loop.
+type AncientItem struct {
+	Number   uint64
+	Hash     []byte
+	Header   []byte
+	Body     []byte
+	Receipts []byte
+	TD       []byte
+}
+
+// FreezeLoop runs until quit is closed, periodically asking fetch for the
+// next block beyond what's already frozen and migrating it from LevelDB
+// into the ancient store. fetch should return ok == false once it reaches
+// a block within confirmations of the current head, since those blocks may
+// still be reorged away. A block is only deleted from LevelDB, via del,
+// after AppendAncient for it has returned successfully.
+func (db *FreezerDB) FreezeLoop(quit chan struct{}, fetch func(number uint64) (item AncientItem, ok bool), del func(number uint64, hash []byte)) {
+	ticker := time.NewTicker(freezeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			for {
+				number := db.freezer.Frozen()
+				item, ok := fetch(number)
+				if !ok {
+					break
+				}
+				if err := db.freezer.AppendAncient(number, item.Hash, item.Header, item.Body, item.Receipts, item.TD); err != nil {
+					glog.V(logger.Error).Infof("freezer: failed to migrate block %d: %v", number, err)
+					break
+				}
+				del(number, item.Hash)
+			}
+			if err := db.freezer.Sync(); err != nil {
+				glog.V(logger.Error).Infof("freezer: sync failed: %v", err)
+			}
+		}
+	}
+}
+
+// freezeInterval is how often FreezeLoop looks for newly-confirmed blocks
+// to migrate out of LevelDB.
+const freezeInterval = 10 * time.Second
@@ -0,0 +1,69 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import "fmt"
+
+// Iterator walks a range of key/value pairs in ascending key order. It is
+// satisfied by goleveldb's iterator.Iterator without change, since that
+// type already exposes the same Next/Error/Key/Value/Release methods.
+type Iterator interface {
+	Next() bool
+	Error() error
+	Key() []byte
+	Value() []byte
+	Release()
+}
+
+// Batch is a write-only accumulator that commits all of its puts atomically
+// on Write.
+type Batch interface {
+	Put(key, value []byte) error
+	Write() error
+}
+
+// Database is the narrow interface every consumer (core, state, eth, ...)
+// should depend on instead of a concrete store, so that the backend can be
+// swapped per deployment without touching call sites.
+type Database interface {
+	Has(key []byte) (bool, error)
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	NewIterator() Iterator
+	NewIteratorWithPrefix(prefix []byte) Iterator
+	NewBatch() Batch
+	Stat(property string) (string, error)
+	Compact(start, limit []byte) error
+	Close()
+}
+
+// Open returns a ready-to-use Database backed by the requested kind, either
+// "leveldb" (the default) or "pebble". cache and handles are megabytes of
+// cache and a number of open file descriptors. The leveldb backend scales
+// them per-database by cacheRatio / handleRatio; the pebble backend uses
+// them as given.
+func Open(kind, path string, cache, handles int) (Database, error) {
+	switch kind {
+	case "", "leveldb":
+		return NewLDBDatabase(path, cache, handles)
+	case "pebble":
+		return NewPebbleDatabase(path, cache, handles)
+	default:
+		return nil, fmt.Errorf("ethdb: unknown database kind %q", kind)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+// table is a Database view that transparently prepends a fixed prefix to
+// every key on Put/Get/Delete/Has and strips it back off on iteration, so
+// several subsystems (the tx-indexer, the bloombits index, ...) can share a
+// single underlying store without hand-rolling key prefixing themselves.
+type table struct {
+	db     Database
+	prefix string
+}
+
+// NewTable returns a namespaced view of db: every key this view sees is
+// transparently prefixed before touching db, and the prefix is stripped
+// back off keys yielded by iteration. Closing the returned Database does
+// NOT close db, since it is normally shared by several tables.
+func NewTable(db Database, prefix string) Database {
+	return &table{db: db, prefix: prefix}
+}
+
+func (t *table) key(key []byte) []byte {
+	return append([]byte(t.prefix), key...)
+}
+
+func (t *table) Has(key []byte) (bool, error) {
+	return t.db.Has(t.key(key))
+}
+
+func (t *table) Get(key []byte) ([]byte, error) {
+	return t.db.Get(t.key(key))
+}
+
+func (t *table) Put(key, value []byte) error {
+	return t.db.Put(t.key(key), value)
+}
+
+func (t *table) Delete(key []byte) error {
+	return t.db.Delete(t.key(key))
+}
+
+func (t *table) NewIterator() Iterator {
+	return t.NewIteratorWithPrefix(nil)
+}
+
+func (t *table) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return &tableIterator{
+		it:     t.db.NewIteratorWithPrefix(t.key(prefix)),
+		prefix: t.prefix,
+	}
+}
+
+func (t *table) NewBatch() Batch {
+	return &tableBatch{b: t.db.NewBatch(), prefix: t.prefix}
+}
+
+func (t *table) Stat(property string) (string, error) {
+	return t.db.Stat(property)
+}
+
+// Compact restricts the requested range to this table's namespace before
+// forwarding it, so a table can never trigger compaction of keys outside
+// its prefix. A nil start/limit is clamped to the bounds of the prefix
+// itself rather than left unbounded.
+func (t *table) Compact(start, limit []byte) error {
+	first, last := t.key(nil), upperBound([]byte(t.prefix))
+	if start != nil {
+		first = t.key(start)
+	}
+	if limit != nil {
+		last = t.key(limit)
+	}
+	return t.db.Compact(first, last)
+}
+
+func (t *table) Close() {
+	// The underlying Database is shared; nothing to do here.
+}
+
+// tableIterator strips the table's prefix back off every key it yields, so
+// callers see the same unprefixed keys they wrote.
+type tableIterator struct {
+	it     Iterator
+	prefix string
+}
+
+func (it *tableIterator) Next() bool   { return it.it.Next() }
+func (it *tableIterator) Error() error { return it.it.Error() }
+func (it *tableIterator) Release()     { it.it.Release() }
+
+func (it *tableIterator) Key() []byte {
+	key := it.it.Key()
+	return key[len(it.prefix):]
+}
+
+func (it *tableIterator) Value() []byte {
+	return it.it.Value()
+}
+
+// tableBatch prefixes every key on Put before forwarding to the underlying
+// Batch, so Write still commits all of this batch's puts atomically. Each
+// tableBatch has its own underlying Batch; writes of different tables are
+// not committed together.
+type tableBatch struct {
+	b      Batch
+	prefix string
+}
+
+func (b *tableBatch) Put(key, value []byte) error {
+	return b.b.Put(append([]byte(b.prefix), key...), value)
+}
+
+func (b *tableBatch) Write() error {
+	return b.b.Write()
+}
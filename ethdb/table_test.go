@@ -0,0 +1,133 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newTestDB(t *testing.T) (*LDBDatabase, func()) {
+	dir, err := ioutil.TempDir("", "ethdb-table-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewLDBDatabase(dir, 0, 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTablePutGetDelete(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+
+	foo := NewTable(db, "foo-")
+	bar := NewTable(db, "bar-")
+
+	if err := foo.Put([]byte("x"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bar.Put([]byte("x"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each table sees its own value for the shared unprefixed key.
+	if val, err := foo.Get([]byte("x")); err != nil || !bytes.Equal(val, []byte("1")) {
+		t.Fatalf("foo[x] = %q, %v, want \"1\", nil", val, err)
+	}
+	if val, err := bar.Get([]byte("x")); err != nil || !bytes.Equal(val, []byte("2")) {
+		t.Fatalf("bar[x] = %q, %v, want \"2\", nil", val, err)
+	}
+
+	// The underlying store sees the prefixed keys, not the raw ones.
+	if has, _ := db.Has([]byte("x")); has {
+		t.Fatalf("underlying db should not see unprefixed key \"x\"")
+	}
+	if val, err := db.Get([]byte("foo-x")); err != nil || !bytes.Equal(val, []byte("1")) {
+		t.Fatalf("db[foo-x] = %q, %v, want \"1\", nil", val, err)
+	}
+
+	if err := foo.Delete([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := foo.Has([]byte("x")); has {
+		t.Fatalf("foo[x] should have been deleted")
+	}
+	if has, _ := bar.Has([]byte("x")); !has {
+		t.Fatalf("deleting foo[x] should not affect bar[x]")
+	}
+}
+
+func TestTableIteratorBounds(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+
+	foo := NewTable(db, "foo-")
+	bar := NewTable(db, "bar-")
+
+	want := map[string]string{"a": "1", "b": "2", "c": "3"}
+	for k, v := range want {
+		if err := foo.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bar.Put([]byte("z"), []byte("9")); err != nil {
+		t.Fatal(err)
+	}
+
+	it := foo.NewIterator()
+	defer it.Release()
+
+	got := make(map[string]string)
+	for it.Next() {
+		got[string(it.Key())] = string(it.Value())
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("iterator yielded %d entries, want %d (got %v)", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("got[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+
+	// Iterating with a sub-prefix should further narrow the range, and
+	// keys must come back with only the table prefix stripped off; the
+	// sub-prefix stays part of the key.
+	sub := foo.NewIteratorWithPrefix([]byte("a"))
+	defer sub.Release()
+	if !sub.Next() {
+		t.Fatalf("expected one entry for sub-prefix \"a\"")
+	}
+	if string(sub.Key()) != "a" {
+		t.Fatalf("sub.Key() = %q, want \"a\"", sub.Key())
+	}
+	if sub.Next() {
+		t.Fatalf("expected exactly one entry for sub-prefix \"a\", got another: %q", sub.Key())
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package light implements on-demand retrieval for Ethereum state, letting
+// a node that only keeps headers (no full state or block bodies) still
+// answer state queries by fetching the missing Merkle trie nodes from the
+// network as they're needed.
+package light
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/net/context"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// ErrProofInvalid is returned by TrieRequest.StoreResult when the
+	// retrieved proof doesn't verify against the requested root, whether
+	// because a node's hash doesn't match or because the proof doesn't
+	// actually terminate at the requested key. The local database is left
+	// untouched, so the caller can safely re-request from another peer.
+	ErrProofInvalid = errors.New("light: invalid merkle proof")
+
+	// ErrHashMismatch is returned by NodeDataRequest.StoreResult when the
+	// retrieved data doesn't hash to the requested hash. The local
+	// database is left untouched.
+	ErrHashMismatch = errors.New("light: retrieved data does not match requested hash")
+)
+
+// OdrBackend is the network-facing half of on-demand retrieval: it knows
+// how to turn an OdrRequest into an answer (typically by asking peers) and
+// exposes the local database the answer ends up cached in. On success,
+// Retrieve must call req.StoreResult before returning, so the answer is
+// available locally by the time the caller sees the nil error.
+type OdrBackend interface {
+	Database() ethdb.Database
+	Retrieve(ctx context.Context, req OdrRequest) error
+}
+
+// OdrRequest is satisfied by every request type an OdrBackend can be asked
+// to retrieve. StoreResult verifies whatever the backend filled the
+// request with and, only if it checks out, persists it into db so the next
+// local lookup for the same data succeeds without going through ODR again.
+// A non-nil error means db was left untouched: the data came from an
+// untrusted peer and didn't hold up, and the caller should try elsewhere.
+type OdrRequest interface {
+	StoreResult(db ethdb.Database) error
+}
+
+// TrieRequest asks for the Merkle proof of key in the trie rooted at root.
+// The backend answers it by filling proof with whatever trie.Prove wrote
+// on the peer's side; once retrieved, the proof nodes are indistinguishable
+// from ones that were always local: StoreResult writes each one under its
+// own hash, so the trie package's ordinary node lookups find them without
+// knowing they were ever missing.
+type TrieRequest struct {
+	root  common.Hash
+	key   []byte
+	proof ethdb.Database
+}
+
+// StoreResult verifies that proof is a valid Merkle proof for key against
+// root before writing any of its nodes to db, so a malicious or buggy peer
+// can't poison the local database with nodes that don't actually belong to
+// the trie it claims to.
+func (req *TrieRequest) StoreResult(db ethdb.Database) error {
+	if _, err := trie.VerifyProof(req.root, req.key, req.proof); err != nil {
+		return ErrProofInvalid
+	}
+	it := req.proof.NewIterator()
+	defer it.Release()
+	for it.Next() {
+		if err := db.Put(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NodeDataRequest asks for the preimage of hash, e.g. a contract's code.
+type NodeDataRequest struct {
+	hash common.Hash
+	data []byte
+}
+
+// StoreResult verifies that data actually hashes to the requested hash
+// before writing it to db.
+func (req *NodeDataRequest) StoreResult(db ethdb.Database) error {
+	if !bytes.Equal(crypto.Keccak256(req.data), req.hash[:]) {
+		return ErrHashMismatch
+	}
+	db.Put(req.hash[:], req.data)
+	return nil
+}
+
+// requestGroup de-duplicates concurrent ODR requests for the same data: if
+// two goroutines independently walk into the same missing trie node (a
+// common pattern when several light state reads race to open the same
+// account), only one of them actually calls through to the backend, and
+// the other waits for it to finish populating the local database before
+// retrying its own lookup.
+type requestGroup struct {
+	odr OdrBackend
+
+	lock     sync.Mutex
+	inFlight map[interface{}]*groupCall
+}
+
+// groupCall tracks a single request the group has handed to the backend,
+// shared by every caller asking for the same key while it's outstanding.
+type groupCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newRequestGroup(odr OdrBackend) *requestGroup {
+	return &requestGroup{
+		odr:      odr,
+		inFlight: make(map[interface{}]*groupCall),
+	}
+}
+
+// retrieve fetches req through the backend, joining an already in-flight
+// call for the same key instead of starting a redundant one. Callers that
+// join an existing call get its error back but must re-run their own local
+// lookup afterwards, since req itself is never populated for them; the
+// data they actually want was written into the shared database by the
+// call they joined.
+func (g *requestGroup) retrieve(ctx context.Context, req OdrRequest) error {
+	key := odrRequestKey(req)
+
+	g.lock.Lock()
+	if call, ok := g.inFlight[key]; ok {
+		g.lock.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &groupCall{done: make(chan struct{})}
+	g.inFlight[key] = call
+	g.lock.Unlock()
+
+	call.err = g.odr.Retrieve(ctx, req)
+
+	g.lock.Lock()
+	delete(g.inFlight, key)
+	g.lock.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// odrRequestKey returns a comparable key identifying what a request is
+// asking for, so that requestGroup can tell two requests for the same data
+// apart from two requests for different data.
+func odrRequestKey(req OdrRequest) interface{} {
+	switch req := req.(type) {
+	case *TrieRequest:
+		return [2]string{req.root.Hex(), string(req.key)}
+	case *NodeDataRequest:
+		return req.hash
+	default:
+		return req
+	}
+}
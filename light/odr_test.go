@@ -0,0 +1,94 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/net/context"
+)
+
+// maliciousOdr answers TrieRequests with a proof it has tampered with, and
+// NodeDataRequests with data that doesn't match the requested hash, so
+// StoreResult's verification can be exercised against an adversarial peer.
+type maliciousOdr struct {
+	sdb, ldb ethdb.Database
+}
+
+func (odr *maliciousOdr) Database() ethdb.Database { return odr.ldb }
+
+func (odr *maliciousOdr) Retrieve(ctx context.Context, req OdrRequest) error {
+	switch req := req.(type) {
+	case *TrieRequest:
+		t, _ := trie.New(req.root, odr.sdb)
+		proofDb, _ := ethdb.NewMemDatabase()
+		t.Prove(req.key, 0, proofDb)
+		if v, _ := proofDb.Get(req.root[:]); v != nil {
+			tampered := append([]byte(nil), v...)
+			tampered[0] ^= 0xff
+			proofDb.Put(req.root[:], tampered)
+		}
+		req.proof = proofDb
+	case *NodeDataRequest:
+		req.data = []byte("not the preimage you're looking for")
+	}
+	return req.StoreResult(odr.ldb)
+}
+
+func makeOdrTestTrie() (common.Hash, ethdb.Database) {
+	sdb, _ := ethdb.NewMemDatabase()
+	t, _ := trie.New(common.Hash{}, sdb)
+	for i := byte(0); i < 50; i++ {
+		t.Update([]byte{i}, []byte{i, i, i})
+	}
+	root, _ := t.Commit()
+	return root, sdb
+}
+
+func TestTrieRequestRejectsTamperedProof(t *testing.T) {
+	root, sdb := makeOdrTestTrie()
+	ldb, _ := ethdb.NewMemDatabase()
+	odr := &maliciousOdr{sdb: sdb, ldb: ldb}
+
+	req := &TrieRequest{root: root, key: []byte{10}}
+	if err := odr.Retrieve(context.Background(), req); err != ErrProofInvalid {
+		t.Fatalf("Retrieve returned %v, expected ErrProofInvalid", err)
+	}
+	it := req.proof.NewIterator()
+	defer it.Release()
+	for it.Next() {
+		if v, _ := ldb.Get(it.Key()); v != nil {
+			t.Fatalf("tampered proof node was stored in the local database")
+		}
+	}
+}
+
+func TestNodeDataRequestRejectsHashMismatch(t *testing.T) {
+	ldb, _ := ethdb.NewMemDatabase()
+	odr := &maliciousOdr{ldb: ldb}
+
+	req := &NodeDataRequest{hash: common.BytesToHash([]byte{1, 2, 3})}
+	if err := odr.Retrieve(context.Background(), req); err != ErrHashMismatch {
+		t.Fatalf("Retrieve returned %v, expected ErrHashMismatch", err)
+	}
+	if v, _ := ldb.Get(req.hash[:]); v != nil {
+		t.Fatalf("mismatched node data was stored in the local database")
+	}
+}
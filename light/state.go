@@ -0,0 +1,280 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/net/context"
+)
+
+// LightState is the ODR-backed counterpart of core/state.StateDB: it
+// offers the same account/storage operations, but every one of them takes
+// a context and can return an error, since satisfying it might mean
+// fetching Merkle trie nodes from the network rather than reading them
+// straight off disk.
+type LightState struct {
+	root  common.Hash
+	trie  *LightTrie // lazily opened, since NewLightState can't fail
+	odr   OdrBackend
+	group *requestGroup
+
+	objects map[common.Address]*stateObject
+}
+
+// NewLightState returns a LightState over the account trie rooted at root.
+// Opening the trie itself is deferred to the first actual access, so this
+// never needs to retrieve anything and can't fail.
+func NewLightState(root common.Hash, odr OdrBackend) *LightState {
+	return &LightState{
+		root:    root,
+		odr:     odr,
+		group:   newRequestGroup(odr),
+		objects: make(map[common.Address]*stateObject),
+	}
+}
+
+func (self *LightState) openTrie(ctx context.Context) (*LightTrie, error) {
+	if self.trie == nil {
+		t, err := NewLightTrie(ctx, self.root, self.odr, self.group)
+		if err != nil {
+			return nil, err
+		}
+		self.trie = t
+	}
+	return self.trie, nil
+}
+
+// getStateObject returns the cached or freshly retrieved object for addr,
+// or nil if the account doesn't exist (or has been Delete-d).
+func (self *LightState) getStateObject(ctx context.Context, addr common.Address) (*stateObject, error) {
+	if obj, ok := self.objects[addr]; ok {
+		if obj.deleted {
+			return nil, nil
+		}
+		return obj, nil
+	}
+	trie, err := self.openTrie(ctx)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := trie.TryGet(ctx, crypto.Keccak256(addr[:]))
+	if err != nil {
+		return nil, err
+	}
+	if len(enc) == 0 {
+		return nil, nil
+	}
+	var data Account
+	if err := rlp.DecodeBytes(enc, &data); err != nil {
+		return nil, err
+	}
+	obj := newStateObject(addr, self.odr, self.group)
+	obj.data = data
+	self.objects[addr] = obj
+	return obj, nil
+}
+
+// getOrNewStateObject returns the object for addr, creating an empty one
+// (not yet written to the trie) if it doesn't exist yet.
+func (self *LightState) getOrNewStateObject(ctx context.Context, addr common.Address) (*stateObject, error) {
+	if obj, ok := self.objects[addr]; ok {
+		return obj, nil
+	}
+	obj, err := self.getStateObject(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		obj = newStateObject(addr, self.odr, self.group)
+		self.objects[addr] = obj
+	}
+	return obj, nil
+}
+
+// CreateStateObject creates a fresh, empty account at addr, discarding
+// whatever was previously there (used for contract creation, where any
+// leftover state at the address must not be inherited).
+func (self *LightState) CreateStateObject(ctx context.Context, addr common.Address) (*stateObject, error) {
+	obj := newStateObject(addr, self.odr, self.group)
+	self.objects[addr] = obj
+	return obj, nil
+}
+
+func (self *LightState) HasAccount(ctx context.Context, addr common.Address) (bool, error) {
+	obj, err := self.getStateObject(ctx, addr)
+	if err != nil {
+		return false, err
+	}
+	return obj != nil, nil
+}
+
+func (self *LightState) IsDeleted(ctx context.Context, addr common.Address) (bool, error) {
+	obj, err := self.getStateObject(ctx, addr)
+	if err != nil {
+		return false, err
+	}
+	return obj == nil, nil
+}
+
+// Delete marks addr as removed: HasAccount will report it gone and
+// IsDeleted will report it deleted, without needing to touch the trie
+// until the state is next committed.
+func (self *LightState) Delete(ctx context.Context, addr common.Address) error {
+	obj, err := self.getOrNewStateObject(ctx, addr)
+	if err != nil {
+		return err
+	}
+	obj.deleted = true
+	return nil
+}
+
+func (self *LightState) GetBalance(ctx context.Context, addr common.Address) (*big.Int, error) {
+	obj, err := self.getStateObject(ctx, addr)
+	if err != nil || obj == nil {
+		return new(big.Int), err
+	}
+	return obj.data.Balance, nil
+}
+
+func (self *LightState) AddBalance(ctx context.Context, addr common.Address, amount *big.Int) error {
+	obj, err := self.getOrNewStateObject(ctx, addr)
+	if err != nil {
+		return err
+	}
+	obj.AddBalance(amount)
+	return nil
+}
+
+func (self *LightState) GetNonce(ctx context.Context, addr common.Address) (uint64, error) {
+	obj, err := self.getStateObject(ctx, addr)
+	if err != nil || obj == nil {
+		return 0, err
+	}
+	return obj.data.Nonce, nil
+}
+
+func (self *LightState) SetNonce(ctx context.Context, addr common.Address, nonce uint64) error {
+	obj, err := self.getOrNewStateObject(ctx, addr)
+	if err != nil {
+		return err
+	}
+	obj.SetNonce(nonce)
+	return nil
+}
+
+func (self *LightState) GetCode(ctx context.Context, addr common.Address) ([]byte, error) {
+	obj, err := self.getStateObject(ctx, addr)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.GetCode(ctx)
+}
+
+func (self *LightState) SetCode(ctx context.Context, addr common.Address, code []byte) error {
+	obj, err := self.getOrNewStateObject(ctx, addr)
+	if err != nil {
+		return err
+	}
+	obj.SetCode(code)
+	return nil
+}
+
+func (self *LightState) GetState(ctx context.Context, addr common.Address, key common.Hash) (common.Hash, error) {
+	obj, err := self.getStateObject(ctx, addr)
+	if err != nil || obj == nil {
+		return common.Hash{}, err
+	}
+	return obj.GetState(ctx, key)
+}
+
+func (self *LightState) SetState(ctx context.Context, addr common.Address, key, value common.Hash) error {
+	obj, err := self.getOrNewStateObject(ctx, addr)
+	if err != nil {
+		return err
+	}
+	obj.SetState(key, value)
+	return nil
+}
+
+// Copy returns an independent LightState over the same root, with its own
+// copy of every account touched so far; mutating the copy never affects
+// the original, or vice versa.
+func (self *LightState) Copy() *LightState {
+	state := &LightState{
+		root:    self.root,
+		odr:     self.odr,
+		group:   self.group,
+		objects: make(map[common.Address]*stateObject, len(self.objects)),
+	}
+	for addr, obj := range self.objects {
+		state.objects[addr] = obj.deepCopy(self.odr, self.group)
+	}
+	return state
+}
+
+// Set replaces self's content with an independent copy of other's,
+// mirroring core/state.StateDB.Set.
+func (self *LightState) Set(other *LightState) {
+	self.root = other.root
+	self.trie = nil
+	self.odr = other.odr
+	self.group = other.group
+	self.objects = make(map[common.Address]*stateObject, len(other.objects))
+	for addr, obj := range other.objects {
+		self.objects[addr] = obj.deepCopy(other.odr, other.group)
+	}
+}
+
+// Commit writes every touched account (and its storage) back into the
+// account trie and returns the new state root.
+func (self *LightState) Commit(ctx context.Context) (common.Hash, error) {
+	trie, err := self.openTrie(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for addr, obj := range self.objects {
+		key := crypto.Keccak256(addr[:])
+		if obj.deleted {
+			if err := trie.TryUpdate(ctx, key, nil); err != nil {
+				return common.Hash{}, err
+			}
+			continue
+		}
+		root, err := obj.updateTrie(ctx)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		obj.data.Root = root
+		enc, err := rlp.EncodeToBytes(obj.data)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if err := trie.TryUpdate(ctx, key, enc); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	root, err := trie.Commit()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	self.root = root
+	return root, nil
+}
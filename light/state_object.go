@@ -0,0 +1,186 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/net/context"
+)
+
+// Account is the consensus-level representation of an account: the value
+// stored in the state trie under the Keccak256 hash of its address.
+type Account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash // storage trie root
+	CodeHash []byte
+}
+
+// stateObject is the light-client mirror of an account: its Account plus
+// whatever storage and code has been touched or fetched so far. Unlike
+// core/state's stateObject, every read that isn't already cached here goes
+// out through ODR rather than assuming the full trie is local.
+type stateObject struct {
+	address common.Address
+	data    Account
+
+	odr   OdrBackend
+	group *requestGroup
+
+	storageTrie *LightTrie
+	storage     map[common.Hash]common.Hash // pending (dirty) storage writes
+	code        []byte                      // nil until fetched, even if CodeHash is empty
+
+	deleted bool
+}
+
+func newStateObject(address common.Address, odr OdrBackend, group *requestGroup) *stateObject {
+	return &stateObject{
+		address: address,
+		odr:     odr,
+		group:   group,
+		data:    Account{Balance: new(big.Int)},
+		storage: make(map[common.Hash]common.Hash),
+	}
+}
+
+// openStorageTrie lazily opens the account's storage trie, resolving
+// missing nodes through the same ODR backend and request group as the
+// account trie it lives under.
+func (self *stateObject) openStorageTrie(ctx context.Context) (*LightTrie, error) {
+	if self.storageTrie == nil {
+		t, err := NewLightTrie(ctx, self.data.Root, self.odr, self.group)
+		if err != nil {
+			return nil, err
+		}
+		self.storageTrie = t
+	}
+	return self.storageTrie, nil
+}
+
+func (self *stateObject) GetState(ctx context.Context, key common.Hash) (common.Hash, error) {
+	if val, ok := self.storage[key]; ok {
+		return val, nil
+	}
+	trie, err := self.openStorageTrie(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	enc, err := trie.TryGet(ctx, crypto.Keccak256(key[:]))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	var value common.Hash
+	if len(enc) > 0 {
+		_, content, _, err := rlp.Split(enc)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		value.SetBytes(content)
+	}
+	return value, nil
+}
+
+func (self *stateObject) SetState(key, value common.Hash) {
+	self.storage[key] = value
+}
+
+func (self *stateObject) SetNonce(nonce uint64) {
+	self.data.Nonce = nonce
+}
+
+func (self *stateObject) SetCode(code []byte) {
+	self.code = code
+	self.data.CodeHash = crypto.Keccak256(code)
+}
+
+func (self *stateObject) AddBalance(amount *big.Int) {
+	self.SetBalance(new(big.Int).Add(self.data.Balance, amount))
+}
+
+func (self *stateObject) SetBalance(amount *big.Int) {
+	self.data.Balance = amount
+}
+
+func (self *stateObject) GetCode(ctx context.Context) ([]byte, error) {
+	if self.code != nil || len(self.data.CodeHash) == 0 {
+		return self.code, nil
+	}
+	req := &NodeDataRequest{hash: common.BytesToHash(self.data.CodeHash)}
+	if err := self.group.retrieve(ctx, req); err != nil {
+		return nil, err
+	}
+	code, err := self.odr.Database().Get(req.hash[:])
+	if err != nil {
+		return nil, err
+	}
+	self.code = code
+	return self.code, nil
+}
+
+// updateTrie flushes the object's pending storage writes into its storage
+// trie and returns the (possibly new) storage root, which the caller
+// writes back into the account trie.
+func (self *stateObject) updateTrie(ctx context.Context) (common.Hash, error) {
+	if len(self.storage) == 0 {
+		return self.data.Root, nil
+	}
+	trie, err := self.openStorageTrie(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for key, value := range self.storage {
+		k := crypto.Keccak256(key[:])
+		if (value == common.Hash{}) {
+			if err := trie.TryUpdate(ctx, k, nil); err != nil {
+				return common.Hash{}, err
+			}
+			continue
+		}
+		v, _ := rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+		if err := trie.TryUpdate(ctx, k, v); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	self.storage = make(map[common.Hash]common.Hash)
+	root, err := trie.Commit()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	self.data.Root = root
+	return root, nil
+}
+
+// deepCopy returns an independent copy of the object, sharing only the ODR
+// backend and request group (its own pending writes and cached trie must
+// not be shared, or mutating the copy would mutate the original).
+func (self *stateObject) deepCopy(odr OdrBackend, group *requestGroup) *stateObject {
+	obj := newStateObject(self.address, odr, group)
+	obj.data = self.data
+	obj.data.Balance = new(big.Int).Set(self.data.Balance)
+	obj.code = self.code
+	for k, v := range self.storage {
+		obj.storage[k] = v
+	}
+	obj.deleted = self.deleted
+	return obj
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/net/context"
+)
+
+// LightTrie is a trie.Trie that fetches whatever nodes it's missing
+// through an OdrBackend instead of failing outright, so a light client can
+// read (and write) a trie it only has the root hash for.
+type LightTrie struct {
+	root  common.Hash
+	trie  *trie.Trie
+	odr   OdrBackend
+	group *requestGroup
+}
+
+// NewLightTrie opens the trie rooted at root, retrieving the root node
+// itself through odr if it isn't already present locally.
+func NewLightTrie(ctx context.Context, root common.Hash, odr OdrBackend, group *requestGroup) (*LightTrie, error) {
+	lt := &LightTrie{root: root, odr: odr, group: group}
+	t, err := lt.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lt.trie = t
+	return lt, nil
+}
+
+// open resolves missing nodes along the way until trie.New succeeds.
+func (lt *LightTrie) open(ctx context.Context) (*trie.Trie, error) {
+	for {
+		t, err := trie.New(lt.root, lt.odr.Database())
+		if err == nil {
+			return t, nil
+		}
+		if rerr := lt.retrieve(ctx, err); rerr != nil {
+			return nil, rerr
+		}
+	}
+}
+
+// retrieve resolves a single MissingNodeError by fetching the Merkle proof
+// from root down to the error's key, which covers every node missing along
+// that path in one round trip. Any other error is returned unchanged.
+func (lt *LightTrie) retrieve(ctx context.Context, err error) error {
+	missing, ok := err.(*trie.MissingNodeError)
+	if !ok {
+		return err
+	}
+	req := &TrieRequest{root: lt.root, key: missing.Key}
+	return lt.group.retrieve(ctx, req)
+}
+
+// TryGet returns the value for key, fetching any trie nodes it needs
+// through odr along the way.
+func (lt *LightTrie) TryGet(ctx context.Context, key []byte) ([]byte, error) {
+	for {
+		val, err := lt.trie.TryGet(key)
+		if err == nil {
+			return val, nil
+		}
+		if rerr := lt.retrieve(ctx, err); rerr != nil {
+			return nil, rerr
+		}
+	}
+}
+
+// TryUpdate associates key with value in the trie, fetching any trie nodes
+// it needs through odr along the way.
+func (lt *LightTrie) TryUpdate(ctx context.Context, key, value []byte) error {
+	for {
+		err := lt.trie.TryUpdate(key, value)
+		if err == nil {
+			return nil
+		}
+		if rerr := lt.retrieve(ctx, err); rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// Commit writes the trie to its attached database and returns its new
+// root hash. Like trie.Trie.Commit, it never needs to fetch anything: it
+// only hashes and stores nodes already held in memory.
+func (lt *LightTrie) Commit() (common.Hash, error) {
+	root, err := lt.trie.Commit()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	lt.root = root
+	return root, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrNonceTooLow is returned by Add when tx's nonce is below the
+	// account's current nonce in the pool's LightState.
+	ErrNonceTooLow = errors.New("light: nonce too low")
+
+	// ErrInsufficientFunds is returned by Add when the account can't cover
+	// tx's value plus its maximum gas cost.
+	ErrInsufficientFunds = errors.New("light: insufficient funds for gas * price + value")
+
+	// ErrIntrinsicGas is returned by Add when tx doesn't supply enough gas
+	// to even cover the intrinsic cost of the call it encodes.
+	ErrIntrinsicGas = errors.New("light: intrinsic gas too low")
+)
+
+// TxPool is the light-client counterpart of core.TxPool: it accepts
+// locally-submitted transactions and hands them to SubscribeTxs
+// subscribers, but since it
+// never holds the full chain, every nonce/balance check it makes goes
+// through a LightState, which may in turn fetch Merkle proofs over ODR
+// rather than reading state straight off disk.
+type TxPool struct {
+	odr OdrBackend
+
+	mu      sync.Mutex
+	state   *LightState
+	pending map[common.Address]types.Transactions // nonce-sorted per sender
+
+	subsMu sync.Mutex
+	subs   map[chan types.Transactions]struct{}
+}
+
+// NewTxPool creates an empty pool over the account state rooted at root.
+func NewTxPool(root common.Hash, odr OdrBackend) *TxPool {
+	return &TxPool{
+		odr:     odr,
+		state:   NewLightState(root, odr),
+		pending: make(map[common.Address]types.Transactions),
+		subs:    make(map[chan types.Transactions]struct{}),
+	}
+}
+
+// validate checks tx's nonce, intrinsic gas and the sender's balance
+// against the pool's current LightState. Every check it performs may need
+// to fetch a Merkle proof, so it takes ctx and returns promptly if ctx is
+// done.
+func (pool *TxPool) validate(ctx context.Context, tx *types.Transaction) error {
+	from, err := tx.From()
+	if err != nil {
+		return err
+	}
+	nonce, err := pool.state.GetNonce(ctx, from)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tx.Nonce() < nonce {
+		return ErrNonceTooLow
+	}
+
+	intrinsic := core.IntrinsicGas(tx.Data(), nil, byte(core.LegacyTxType), tx.To() == nil, true)
+	if tx.Gas().Cmp(intrinsic) < 0 {
+		return ErrIntrinsicGas
+	}
+
+	balance, err := pool.state.GetBalance(ctx, from)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cost := new(big.Int).Mul(tx.GasPrice(), tx.Gas())
+	cost.Add(cost, tx.Value())
+	if balance.Cmp(cost) < 0 {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// Add validates tx against the pool's current state and, if it checks out,
+// queues it under its sender, keeping that sender's queue sorted by nonce.
+func (pool *TxPool) Add(ctx context.Context, tx *types.Transaction) error {
+	if err := pool.validate(ctx, tx); err != nil {
+		return err
+	}
+	from, _ := tx.From()
+
+	pool.mu.Lock()
+	txs := append(pool.pending[from], tx)
+	sort.Sort(types.TxByNonce(txs))
+	pool.pending[from] = txs
+	pool.mu.Unlock()
+
+	pool.notifySubscribers(types.Transactions{tx})
+	return nil
+}
+
+// Pending returns a snapshot of every transaction currently queued, keyed
+// by sender. ctx is accepted for symmetry with the pool's other methods
+// and so future revalidation-on-read can be added without changing the
+// signature, but today's Pending never itself goes out over ODR.
+func (pool *TxPool) Pending(ctx context.Context) (map[common.Address]types.Transactions, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pending := make(map[common.Address]types.Transactions, len(pool.pending))
+	for addr, txs := range pool.pending {
+		cpy := make(types.Transactions, len(txs))
+		copy(cpy, txs)
+		pending[addr] = cpy
+	}
+	return pending, nil
+}
+
+// NewHead re-roots the pool's LightState at root and re-validates every
+// pending transaction against it, dropping whichever ones no longer check
+// out (e.g. their nonce has since been used, or their sender's balance can
+// no longer cover them).
+func (pool *TxPool) NewHead(ctx context.Context, root common.Hash) error {
+	pool.mu.Lock()
+	pool.state = NewLightState(root, pool.odr)
+	pending := pool.pending
+	pool.mu.Unlock()
+
+	kept := make(map[common.Address]types.Transactions, len(pending))
+	for addr, txs := range pending {
+		var live types.Transactions
+		for _, tx := range txs {
+			if err := pool.validate(ctx, tx); err == nil {
+				live = append(live, tx)
+			}
+		}
+		if len(live) > 0 {
+			kept[addr] = live
+		}
+	}
+
+	pool.mu.Lock()
+	pool.pending = kept
+	pool.mu.Unlock()
+	return ctx.Err()
+}
+
+// SubscribeTxs registers a subscriber for batches of newly accepted
+// transactions. The returned func unsubscribes and must be called to
+// release the channel.
+func (pool *TxPool) SubscribeTxs() (<-chan types.Transactions, func()) {
+	ch := make(chan types.Transactions, 1)
+
+	pool.subsMu.Lock()
+	pool.subs[ch] = struct{}{}
+	pool.subsMu.Unlock()
+
+	unsubscribe := func() {
+		pool.subsMu.Lock()
+		delete(pool.subs, ch)
+		pool.subsMu.Unlock()
+	}
+	return ch, unsubscribe
+}
+
+// notifySubscribers fans txs out to every live SubscribeTxs channel,
+// dropping them for a subscriber that isn't keeping up rather than
+// blocking the caller of Add.
+func (pool *TxPool) notifySubscribers(txs types.Transactions) {
+	pool.subsMu.Lock()
+	defer pool.subsMu.Unlock()
+
+	for ch := range pool.subs {
+		select {
+		case ch <- txs:
+		default:
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"golang.org/x/net/context"
+)
+
+// txPoolTestAccount is a funded account whose address is the real
+// secp256k1 derivation of key, so transactions signed with key recover to
+// exactly the address the test state funded. makeTestState can't be
+// reused as-is here because its accounts live at small fixed addresses
+// ({0}, {1}, ...) that no private key actually derives to.
+type txPoolTestAccount struct {
+	key     *ecdsa.PrivateKey
+	addr    common.Address
+	balance int64
+	nonce   uint64
+}
+
+func makeTxPoolTestState(accounts []*txPoolTestAccount) (common.Hash, ethdb.Database) {
+	sdb, _ := ethdb.NewMemDatabase()
+	st, _ := state.New(common.Hash{}, sdb)
+	for _, acc := range accounts {
+		so := st.GetOrNewStateObject(acc.addr)
+		so.AddBalance(big.NewInt(acc.balance))
+		so.SetNonce(acc.nonce)
+		so.Update()
+		st.UpdateStateObject(so)
+	}
+	root, _ := st.Commit()
+	return root, sdb
+}
+
+func newTxPoolTestAccount(t *testing.T, balance int64, nonce uint64) *txPoolTestAccount {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &txPoolTestAccount{
+		key:     key,
+		addr:    crypto.PubkeyToAddress(key.PublicKey),
+		balance: balance,
+		nonce:   nonce,
+	}
+}
+
+// signTx builds and signs a transaction spending value wei at a gas price
+// of 1 wei/gas with a 21000 gas limit, so its total cost is easy to reason
+// about against the tiny balances these tests fund accounts with.
+func signTx(t *testing.T, acc *txPoolTestAccount, value int64) *types.Transaction {
+	tx := types.NewTransaction(acc.nonce, common.Address{}, big.NewInt(value), big.NewInt(21000), big.NewInt(1), nil)
+	signed, err := tx.SignECDSA(acc.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestTxPoolRejectsInsufficientBalanceOnlyAfterProof(t *testing.T) {
+	poorAcc := newTxPoolTestAccount(t, 5, 100)    // has 5 wei
+	richAcc := newTxPoolTestAccount(t, 100000, 7) // can afford a transfer
+
+	root, sdb := makeTxPoolTestState([]*txPoolTestAccount{poorAcc, richAcc})
+	ldb, _ := ethdb.NewMemDatabase()
+	odr := &testOdr{sdb: sdb, ldb: ldb}
+
+	pool := NewTxPool(root, odr)
+	ctx := context.Background()
+
+	// Spending 100 wei costs 100 + 21000*1 = 21100, far more than the 5
+	// wei this account has. Nothing in the freshly-created pool has
+	// fetched that account's proof yet, so the rejection can only be
+	// happening once Add itself retrieves it.
+	poor := signTx(t, poorAcc, 100)
+	if _, ok := pool.pending[poorAcc.addr]; ok {
+		t.Fatalf("pool already has a pending entry for %x before Add was called", poorAcc.addr)
+	}
+	if err := pool.Add(ctx, poor); err != ErrInsufficientFunds {
+		t.Fatalf("Add returned %v, want ErrInsufficientFunds", err)
+	}
+	if _, ok := pool.pending[poorAcc.addr]; ok {
+		t.Fatalf("rejected transaction was nonetheless queued")
+	}
+
+	rich := signTx(t, richAcc, 0)
+	if err := pool.Add(ctx, rich); err != nil {
+		t.Fatalf("Add rejected an affordable transaction: %v", err)
+	}
+	pending, err := pool.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending[richAcc.addr]) != 1 {
+		t.Fatalf("expected 1 pending tx for %x, got %d", richAcc.addr, len(pending[richAcc.addr]))
+	}
+}
+
+func TestTxPoolNewHeadKeepsValidTx(t *testing.T) {
+	acc := newTxPoolTestAccount(t, 100000, 9)
+	root, sdb := makeTxPoolTestState([]*txPoolTestAccount{acc})
+	ldb, _ := ethdb.NewMemDatabase()
+	odr := &testOdr{sdb: sdb, ldb: ldb}
+
+	pool := NewTxPool(root, odr)
+	ctx := context.Background()
+
+	tx := signTx(t, acc, 0)
+	if err := pool.Add(ctx, tx); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	// Re-rooting at the very same state leaves the transaction valid, so
+	// NewHead must keep it queued.
+	if err := pool.NewHead(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := pool.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending[acc.addr]) != 1 {
+		t.Fatalf("valid transaction was evicted by NewHead")
+	}
+}
@@ -18,6 +18,7 @@ package miner
 
 import (
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"sync/atomic"
@@ -29,6 +30,13 @@ import (
 	"github.com/ethereum/go-ethereum/logger/glog"
 )
 
+// staleThreshold is how many blocks behind the current work a pending
+// GetWork result may fall before SubmitWork no longer accepts it. Keying
+// eviction off block number rather than a fixed wall-clock window means
+// miners on chains with a much longer or shorter block time than Ethereum's
+// aren't dropped prematurely (or kept around too long).
+const staleThreshold = 7
+
 type hashrate struct {
 	ping time.Time
 	rate uint64
@@ -47,6 +55,9 @@ type RemoteAgent struct {
 	hashrateMu sync.RWMutex
 	hashrate   map[common.Hash]hashrate
 
+	subsMu sync.Mutex
+	subs   map[chan [4]string]struct{}
+
 	running int32 // running indicates whether the agent is active. Call atomically
 }
 
@@ -54,6 +65,7 @@ func NewRemoteAgent() *RemoteAgent {
 	return &RemoteAgent{
 		work:     make(map[common.Hash]*Work),
 		hashrate: make(map[common.Hash]hashrate),
+		subs:     make(map[chan [4]string]struct{}),
 	}
 }
 
@@ -103,11 +115,14 @@ func (a *RemoteAgent) GetHashRate() (tot int64) {
 	return
 }
 
-func (a *RemoteAgent) GetWork() ([3]string, error) {
+// GetWork returns the hash, seed hash, truncated target and current block
+// number of the work package the miner should currently work on, in the
+// de-facto stratum/getwork format external miners and pools expect.
+func (a *RemoteAgent) GetWork() ([4]string, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	var res [3]string
+	var res [4]string
 
 	if a.currentWork != nil {
 		block := a.currentWork.Block
@@ -115,12 +130,16 @@ func (a *RemoteAgent) GetWork() ([3]string, error) {
 		res[0] = block.HashNoNonce().Hex()
 		seedHash, _ := ethash.GetSeedHash(block.NumberU64())
 		res[1] = common.BytesToHash(seedHash).Hex()
-		// Calculate the "target" to be returned to the external miner
+		// Calculate the "target" to be returned to the external miner. This
+		// truncates the last bit of precision compared to the exact
+		// boundary 2^256/difficulty; use GetWorkWithBoundary for the exact
+		// value.
 		n := big.NewInt(1)
 		n.Lsh(n, 255)
 		n.Div(n, block.Difficulty())
 		n.Lsh(n, 1)
 		res[2] = common.BytesToHash(n.Bytes()).Hex()
+		res[3] = fmt.Sprintf("0x%x", block.NumberU64())
 
 		a.work[block.HashNoNonce()] = a.currentWork
 		return res, nil
@@ -128,6 +147,65 @@ func (a *RemoteAgent) GetWork() ([3]string, error) {
 	return res, errors.New("No work available yet, don't panic.")
 }
 
+// GetWorkWithBoundary is GetWork, except the returned target is the exact
+// 32-byte boundary 2^256/difficulty rather than GetWork's truncated form.
+func (a *RemoteAgent) GetWorkWithBoundary() ([4]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var res [4]string
+
+	if a.currentWork != nil {
+		block := a.currentWork.Block
+
+		res[0] = block.HashNoNonce().Hex()
+		seedHash, _ := ethash.GetSeedHash(block.NumberU64())
+		res[1] = common.BytesToHash(seedHash).Hex()
+
+		n := new(big.Int).Lsh(big.NewInt(1), 256)
+		n.Div(n, block.Difficulty())
+		res[2] = common.BytesToHash(n.Bytes()).Hex()
+		res[3] = fmt.Sprintf("0x%x", block.NumberU64())
+
+		a.work[block.HashNoNonce()] = a.currentWork
+		return res, nil
+	}
+	return res, errors.New("No work available yet, don't panic.")
+}
+
+// SubscribeNewWork registers a subscriber for GetWork-shaped notifications,
+// pushed by maintainLoop every time currentWork changes, so long-polling RPC
+// clients don't have to keep calling GetWork themselves. The returned func
+// unsubscribes and must be called to release the channel.
+func (a *RemoteAgent) SubscribeNewWork() (<-chan [4]string, func()) {
+	ch := make(chan [4]string, 1)
+
+	a.subsMu.Lock()
+	a.subs[ch] = struct{}{}
+	a.subsMu.Unlock()
+
+	unsubscribe := func() {
+		a.subsMu.Lock()
+		delete(a.subs, ch)
+		a.subsMu.Unlock()
+	}
+	return ch, unsubscribe
+}
+
+// notifySubscribers fans out work to every live SubscribeNewWork channel,
+// dropping it for a subscriber that isn't keeping up rather than blocking.
+func (a *RemoteAgent) notifySubscribers(work [4]string) {
+	a.subsMu.Lock()
+	defer a.subsMu.Unlock()
+
+	for ch := range a.subs {
+		select {
+		case ch <- work:
+		default:
+		}
+	}
+}
+
 // Returns true or false, but does not indicate if the PoW was correct
 func (a *RemoteAgent) SubmitWork(nonce uint64, mixDigest, hash common.Hash) bool {
 	a.mu.Lock()
@@ -160,12 +238,19 @@ out:
 			a.mu.Lock()
 			a.currentWork = work
 			a.mu.Unlock()
+
+			if notify, err := a.GetWork(); err == nil {
+				a.notifySubscribers(notify)
+			}
 		case <-ticker:
 			// cleanup
 			a.mu.Lock()
-			for hash, work := range a.work {
-				if time.Since(work.createdAt) > 7*(12*time.Second) {
-					delete(a.work, hash)
+			if a.currentWork != nil {
+				current := a.currentWork.Block.NumberU64()
+				for hash, work := range a.work {
+					if num := work.Block.NumberU64(); num+staleThreshold < current {
+						delete(a.work, hash)
+					}
 				}
 			}
 			a.mu.Unlock()
@@ -0,0 +1,58 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TxOrderingPolicy groups a flat transaction pool into per-sender,
+// nonce-ordered queues, ready to be handed to
+// types.NewTransactionsByPriceAndNonce. Plugging in an alternative policy
+// (FCFS, fair-queueing, MEV-aware, ...) is just a matter of grouping and
+// ordering the senders' queues differently; commitTransactions itself
+// doesn't need to change.
+type TxOrderingPolicy func(types.Transactions) *types.TransactionsByPriceAndNonce
+
+// defaultTxOrdering is the worker's default TxOrderingPolicy: sender queues
+// kept in nonce order, with the block filled by always taking the
+// highest-gas-priced sender's next transaction first.
+func defaultTxOrdering(txs types.Transactions) *types.TransactionsByPriceAndNonce {
+	byAccount := make(map[common.Address]types.Transactions)
+	for _, tx := range txs {
+		from, _ := tx.From() // already checked during pool acceptance
+		byAccount[from] = append(byAccount[from], tx)
+	}
+	for from, accTxs := range byAccount {
+		sort.Sort(types.TxByNonce(accTxs))
+		byAccount[from] = accTxs
+	}
+	return types.NewTransactionsByPriceAndNonce(byAccount)
+}
+
+// SetTxOrdering replaces the worker's transaction-ordering policy, letting
+// callers outside this package (e.g. research tooling) experiment with how
+// pending transactions are packed into a block without patching the
+// worker itself.
+func (self *worker) SetTxOrdering(policy TxOrderingPolicy) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.txOrdering = policy
+}
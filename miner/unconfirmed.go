@@ -0,0 +1,106 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+// unconfirmedBlock is a locally mined block that hasn't yet accumulated
+// depth confirmations, pending classification once it has.
+type unconfirmedBlock struct {
+	index uint64
+	hash  common.Hash
+}
+
+// unconfirmedBlocks tracks the locally mined blocks that are still too
+// recent to know whether they ended up canonical, replacing the old
+// uint64RingBuffer (which only remembered block numbers and so couldn't
+// tell a stale inclusion from a canonical one, nor survive being asked
+// about a block it had already overwritten in the ring).
+type unconfirmedBlocks struct {
+	chain  *core.BlockChain
+	depth  uint64
+	blocks *list.List
+	lock   sync.RWMutex
+}
+
+// newUnconfirmedBlocks returns an unconfirmedBlocks tracker that waits depth
+// blocks before reporting on an entry inserted into it.
+func newUnconfirmedBlocks(chain *core.BlockChain, depth uint64) *unconfirmedBlocks {
+	return &unconfirmedBlocks{
+		chain:  chain,
+		depth:  depth,
+		blocks: list.New(),
+	}
+}
+
+// Insert adds a newly mined block to the set of unconfirmed ones.
+func (set *unconfirmedBlocks) Insert(index uint64, hash common.Hash) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	set.blocks.PushBack(&unconfirmedBlock{index: index, hash: hash})
+	glog.V(logger.Debug).Infof("🔗  Tracking unconfirmed block #%d [%x…]", index, hash.Bytes()[:4])
+}
+
+// Shift drops and reports on every tracked block that is now at least depth
+// blocks behind height, classifying each as included in the canonical
+// chain, stuck on a side chain, or lost outright.
+func (set *unconfirmedBlocks) Shift(height uint64) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	for e := set.blocks.Front(); e != nil; {
+		block := e.Value.(*unconfirmedBlock)
+		if block.index+set.depth > height {
+			break
+		}
+		next := e.Next()
+		set.blocks.Remove(e)
+		e = next
+
+		canonical := set.chain.GetBlockByNumber(block.index)
+		switch {
+		case canonical != nil && canonical.Hash() == block.hash:
+			glog.V(logger.Info).Infof("🔗  Mined block confirmed #%d [%x…]", block.index, block.hash.Bytes()[:4])
+		case set.chain.GetBlock(block.hash) != nil:
+			glog.V(logger.Info).Infof("⑂  Mined block became a side chain block #%d [%x…]", block.index, block.hash.Bytes()[:4])
+		default:
+			glog.V(logger.Warn).Infof("⑂  Mined block lost #%d [%x…]", block.index, block.hash.Bytes()[:4])
+		}
+	}
+}
+
+// Pending returns the indexes of all blocks still being tracked, i.e. those
+// that haven't yet reached depth confirmations.
+func (set *unconfirmedBlocks) Pending() []uint64 {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+
+	indexes := make([]uint64, 0, set.blocks.Len())
+	for e := set.blocks.Front(); e != nil; e = e.Next() {
+		indexes = append(indexes, e.Value.(*unconfirmedBlock).index)
+	}
+	return indexes
+}
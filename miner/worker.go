@@ -25,6 +25,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -33,7 +34,6 @@ import (
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
-	"github.com/ethereum/go-ethereum/pow"
 	"gopkg.in/fatih/set.v0"
 )
 
@@ -42,9 +42,18 @@ var jsonlogger = logger.NewJsonLogger()
 const (
 	resultQueueSize  = 10
 	miningLogAtDepth = 5
+
+	// recommitInterval bounds how often mainLoop rebuilds the pending work
+	// template in response to requestNewWork, so a burst of TxPreEvents or
+	// a flurry of competing side blocks costs at most one rebuild per tick
+	// instead of thrashing the state for every one of them.
+	recommitInterval = 3 * time.Second
 )
 
-// Agent can register themself with the worker
+// Agent is a sealer: registered with the worker, it's handed each new Work
+// and is expected to drive it through the worker's engine.Seal (whether
+// that's CPU mining, a remote rig polling getWork/submitWork, or a PoA
+// signer), returning the result on its SetReturnCh channel.
 type Agent interface {
 	Work() chan<- *Work
 	SetReturnCh(chan<- *Result)
@@ -53,11 +62,6 @@ type Agent interface {
 	GetHashRate() int64
 }
 
-type uint64RingBuffer struct {
-	ints []uint64 //array of all integers in buffer
-	next int      //where is the next insertion? assert 0 <= next < len(ints)
-}
-
 // environment is the workers current environment and holds
 // all of the current state information
 type Work struct {
@@ -67,11 +71,8 @@ type Work struct {
 	uncles             *set.Set       // uncle set
 	remove             *set.Set       // tx which will be removed
 	tcount             int            // tx count in cycle
-	ignoredTransactors *set.Set
-	lowGasTransactors  *set.Set
 	ownedAccounts      *set.Set
 	lowGasTxs          types.Transactions
-	localMinedBlocks   *uint64RingBuffer // the most recent block numbers that were mined locally (used to check block inclusion)
 
 	Block *types.Block // the new block
 
@@ -80,6 +81,11 @@ type Work struct {
 	receipts []*types.Receipt
 
 	createdAt time.Time
+
+	// stopCh is closed by commitNewWork when this Work is superseded by a
+	// fresher one, so an agent mid-Seal on it can abandon that attempt
+	// instead of racing to submit a stale result.
+	stopCh chan struct{}
 }
 
 type Result struct {
@@ -91,11 +97,20 @@ type Result struct {
 type worker struct {
 	mu sync.Mutex
 
-	agents map[Agent]struct{}
-	recv   chan *Result
-	mux    *event.TypeMux
-	quit   chan struct{}
-	pow    pow.PoW
+	agents   map[Agent]struct{}
+	resultCh chan *Result
+	mux      *event.TypeMux
+	quit     chan struct{}
+	engine   consensus.Engine
+
+	// taskCh carries freshly committed Work to taskLoop for dispatch to
+	// agents, decoupling that (potentially blocking) fan-out from
+	// commitNewWork's locked critical section.
+	taskCh chan *Work
+	// newWorkCh is nudged by requestNewWork whenever an event suggests the
+	// current template may be stale; mainLoop coalesces bursts of these
+	// into at most one commitNewWork per recommitInterval.
+	newWorkCh chan struct{}
 
 	eth     core.Backend
 	chain   *core.BlockChain
@@ -120,14 +135,24 @@ type worker struct {
 	atWork int32
 
 	fullValidation bool
+
+	// unconfirmed tracks blocks mined locally that haven't yet accumulated
+	// miningLogAtDepth confirmations, so their eventual fate can be logged.
+	unconfirmed *unconfirmedBlocks
+
+	// txOrdering decides how pending transactions are packed into a block;
+	// see SetTxOrdering.
+	txOrdering TxOrderingPolicy
 }
 
-func newWorker(coinbase common.Address, eth core.Backend) *worker {
+func newWorker(coinbase common.Address, eth core.Backend, engine consensus.Engine) *worker {
 	worker := &worker{
 		eth:            eth,
 		mux:            eth.EventMux(),
 		chainDb:        eth.ChainDb(),
-		recv:           make(chan *Result, resultQueueSize),
+		resultCh:       make(chan *Result, resultQueueSize),
+		taskCh:         make(chan *Work, 1),
+		newWorkCh:      make(chan struct{}, 1),
 		gasPrice:       new(big.Int),
 		chain:          eth.BlockChain(),
 		proc:           eth.BlockChain().Validator(),
@@ -136,9 +161,14 @@ func newWorker(coinbase common.Address, eth core.Backend) *worker {
 		txQueue:        make(map[common.Hash]*types.Transaction),
 		quit:           make(chan struct{}),
 		agents:         make(map[Agent]struct{}),
+		engine:         engine,
 		fullValidation: false,
 	}
+	worker.unconfirmed = newUnconfirmedBlocks(worker.chain, miningLogAtDepth)
+	worker.txOrdering = defaultTxOrdering
 	go worker.update()
+	go worker.mainLoop()
+	go worker.taskLoop()
 	go worker.wait()
 
 	worker.commitNewWork()
@@ -202,7 +232,7 @@ func (self *worker) register(agent Agent) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 	self.agents[agent] = struct{}{}
-	agent.SetReturnCh(self.recv)
+	agent.SetReturnCh(self.resultCh)
 }
 
 func (self *worker) unregister(agent Agent) {
@@ -228,17 +258,21 @@ func (self *worker) update() {
 			// A real event arrived, process interesting content
 			switch ev := event.Data.(type) {
 			case core.ChainHeadEvent:
+				self.unconfirmed.Shift(ev.Block.NumberU64())
 				self.commitNewWork()
 			case core.ChainSideEvent:
 				self.uncleMu.Lock()
 				self.possibleUncles[ev.Block.Hash()] = ev.Block
 				self.uncleMu.Unlock()
+				self.requestNewWork()
 			case core.TxPreEvent:
 				// Apply transaction to the pending state if we're not mining
 				if atomic.LoadInt32(&self.mining) == 0 {
 					self.currentMu.Lock()
-					self.current.commitTransactions(self.mux, types.Transactions{ev.Tx}, self.gasPrice, self.chain)
+					self.current.commitTransactions(self.mux, self.txOrdering(types.Transactions{ev.Tx}), self.gasPrice, self.chain)
 					self.currentMu.Unlock()
+				} else {
+					self.requestNewWork()
 				}
 			}
 		case <-self.quit:
@@ -247,21 +281,58 @@ func (self *worker) update() {
 	}
 }
 
-func newLocalMinedBlock(blockNumber uint64, prevMinedBlocks *uint64RingBuffer) (minedBlocks *uint64RingBuffer) {
-	if prevMinedBlocks == nil {
-		minedBlocks = &uint64RingBuffer{next: 0, ints: make([]uint64, miningLogAtDepth+1)}
-	} else {
-		minedBlocks = prevMinedBlocks
+// requestNewWork nudges mainLoop that the pending work template may be
+// stale. It never blocks: newWorkCh is buffered to 1, so a burst of events
+// arriving faster than mainLoop drains them just collapses into the single
+// pending signal already queued.
+func (self *worker) requestNewWork() {
+	select {
+	case self.newWorkCh <- struct{}{}:
+	default:
+	}
+}
+
+// mainLoop rebuilds the pending work template at most once per
+// recommitInterval, no matter how many times requestNewWork is nudged in
+// between, so a flurry of TxPreEvents or competing side blocks costs one
+// commitNewWork instead of thrashing state for each of them.
+func (self *worker) mainLoop() {
+	ticker := time.NewTicker(recommitInterval)
+	defer ticker.Stop()
+
+	dirty := false
+	for {
+		select {
+		case <-self.newWorkCh:
+			dirty = true
+		case <-ticker.C:
+			if dirty {
+				dirty = false
+				self.commitNewWork()
+			}
+		case <-self.quit:
+			return
+		}
 	}
+}
 
-	minedBlocks.ints[minedBlocks.next] = blockNumber
-	minedBlocks.next = (minedBlocks.next + 1) % len(minedBlocks.ints)
-	return minedBlocks
+// taskLoop dispatches each Work committed by commitNewWork to the
+// registered agents, outside of commitNewWork's own locked critical
+// section.
+func (self *worker) taskLoop() {
+	for {
+		select {
+		case work := <-self.taskCh:
+			self.push(work)
+		case <-self.quit:
+			return
+		}
+	}
 }
 
 func (self *worker) wait() {
 	for {
-		for result := range self.recv {
+		for result := range self.resultCh {
 			atomic.AddInt32(&self.atWork, -1)
 
 			if result == nil {
@@ -338,7 +409,7 @@ func (self *worker) wait() {
 				stale = "stale "
 			} else {
 				confirm = "Wait 5 blocks for confirmation"
-				work.localMinedBlocks = newLocalMinedBlock(block.Number().Uint64(), work.localMinedBlocks)
+				self.unconfirmed.Insert(block.NumberU64(), block.Hash())
 			}
 			glog.V(logger.Info).Infof("🔨  Mined %sblock (#%v / %x). %s", stale, block.Number(), block.Hash().Bytes()[:4], confirm)
 
@@ -377,6 +448,7 @@ func (self *worker) makeCurrent(parent *types.Block, header *types.Header) error
 		uncles:    set.New(),
 		header:    header,
 		createdAt: time.Now(),
+		stopCh:    make(chan struct{}),
 	}
 
 	// when 08 is processed ancestors contain 07 (quick block)
@@ -392,12 +464,7 @@ func (self *worker) makeCurrent(parent *types.Block, header *types.Header) error
 	// Keep track of transactions which return errors so they can be removed
 	work.remove = set.New()
 	work.tcount = 0
-	work.ignoredTransactors = set.New()
-	work.lowGasTransactors = set.New()
 	work.ownedAccounts = accountAddressesSet(accounts)
-	if self.current != nil {
-		work.localMinedBlocks = self.current.localMinedBlocks
-	}
 	self.current = work
 	return nil
 }
@@ -413,36 +480,10 @@ func (w *worker) setGasPrice(p *big.Int) {
 	w.mux.Post(core.GasPriceChanged{w.gasPrice})
 }
 
-func (self *worker) isBlockLocallyMined(current *Work, deepBlockNum uint64) bool {
-	//Did this instance mine a block at {deepBlockNum} ?
-	var isLocal = false
-	for idx, blockNum := range current.localMinedBlocks.ints {
-		if deepBlockNum == blockNum {
-			isLocal = true
-			current.localMinedBlocks.ints[idx] = 0 //prevent showing duplicate logs
-			break
-		}
-	}
-	//Short-circuit on false, because the previous and following tests must both be true
-	if !isLocal {
-		return false
-	}
-
-	//Does the block at {deepBlockNum} send earnings to my coinbase?
-	var block = self.chain.GetBlockByNumber(deepBlockNum)
-	return block != nil && block.Coinbase() == self.coinbase
-}
-
-func (self *worker) logLocalMinedBlocks(current, previous *Work) {
-	if previous != nil && current.localMinedBlocks != nil {
-		nextBlockNum := current.Block.NumberU64()
-		for checkBlockNum := previous.Block.NumberU64(); checkBlockNum < nextBlockNum; checkBlockNum++ {
-			inspectBlockNum := checkBlockNum - miningLogAtDepth
-			if self.isBlockLocallyMined(current, inspectBlockNum) {
-				glog.V(logger.Info).Infof("🔨 🔗  Mined %d blocks back: block #%v", miningLogAtDepth, inspectBlockNum)
-			}
-		}
-	}
+// Pending returns the block numbers of all locally mined blocks that
+// haven't yet accumulated miningLogAtDepth confirmations.
+func (self *worker) Pending() []uint64 {
+	return self.unconfirmed.Pending()
 }
 
 func (self *worker) commitNewWork() {
@@ -470,15 +511,25 @@ func (self *worker) commitNewWork() {
 	header := &types.Header{
 		ParentHash: parent.Hash(),
 		Number:     num.Add(num, common.Big1),
-		Difficulty: core.CalcDifficulty(uint64(tstamp), parent.Time().Uint64(), parent.Number(), parent.Difficulty()),
 		GasLimit:   core.CalcGasLimit(parent),
 		GasUsed:    new(big.Int),
 		Coinbase:   self.coinbase,
 		Extra:      self.extra,
 		Time:       big.NewInt(tstamp),
 	}
+	// Let the engine set Difficulty and any other consensus-specific
+	// fields it needs, instead of hard-coding Ethash's rules here.
+	if err := self.engine.Prepare(self.chain, header); err != nil {
+		glog.V(logger.Error).Infoln("Failed to prepare header for mining:", err)
+		return
+	}
 
 	previous := self.current
+	// This work is about to be superseded: let any agent still sealing it
+	// know so it can give up instead of racing to submit a stale result.
+	if previous != nil {
+		close(previous.stopCh)
+	}
 	// Could potentially happen if starting to mine in an odd state.
 	err := self.makeCurrent(parent, header)
 	if err != nil {
@@ -487,42 +538,7 @@ func (self *worker) commitNewWork() {
 	}
 	work := self.current
 
-	/* //approach 1
-	transactions := self.eth.TxPool().GetTransactions()
-	sort.Sort(types.TxByNonce(transactions))
-	*/
-
-	//approach 2
-	transactions := self.eth.TxPool().GetTransactions()
-	types.SortByPriceAndNonce(transactions)
-
-	/* // approach 3
-	// commit transactions for this run.
-	txPerOwner := make(map[common.Address]types.Transactions)
-	// Sort transactions by owner
-	for _, tx := range self.eth.TxPool().GetTransactions() {
-		from, _ := tx.From() // we can ignore the sender error
-		txPerOwner[from] = append(txPerOwner[from], tx)
-	}
-	var (
-		singleTxOwner types.Transactions
-		multiTxOwner  types.Transactions
-	)
-	// Categorise transactions by
-	// 1. 1 owner tx per block
-	// 2. multi txs owner per block
-	for _, txs := range txPerOwner {
-		if len(txs) == 1 {
-			singleTxOwner = append(singleTxOwner, txs[0])
-		} else {
-			multiTxOwner = append(multiTxOwner, txs...)
-		}
-	}
-	sort.Sort(types.TxByPrice(singleTxOwner))
-	sort.Sort(types.TxByNonce(multiTxOwner))
-	transactions := append(singleTxOwner, multiTxOwner...)
-	*/
-
+	transactions := self.txOrdering(self.eth.TxPool().GetTransactions())
 	work.commitTransactions(self.mux, transactions, self.gasPrice, self.chain)
 	self.eth.TxPool().RemoveTransactions(work.lowGasTxs)
 
@@ -551,20 +567,34 @@ func (self *worker) commitNewWork() {
 	}
 
 	if atomic.LoadInt32(&self.mining) == 1 {
-		// commit state root after all state transitions.
-		core.AccumulateRewards(work.state, header, uncles)
-		header.Root = work.state.IntermediateRoot()
+		// Finalize credits the engine's block reward into work.state and
+		// seals the resulting root into header before the block goes off
+		// to be sealed.
+		block, err := self.engine.Finalize(self.chain, header, work.state, work.txs, uncles, work.receipts)
+		if err != nil {
+			glog.V(logger.Error).Infoln("Failed to finalize block for mining:", err)
+			return
+		}
+		work.Block = block
+	} else {
+		// Not mining: skip the block reward and just assemble a block from
+		// the header for pending()'s sake.
+		work.Block = types.NewBlock(header, work.txs, uncles, work.receipts)
 	}
 
-	// create the new block whose nonce will be mined.
-	work.Block = types.NewBlock(header, work.txs, uncles, work.receipts)
-
 	// We only care about logging if we're actually mining.
 	if atomic.LoadInt32(&self.mining) == 1 {
 		glog.V(logger.Info).Infof("commit new work on block %v with %d txs & %d uncles. Took %v\n", work.Block.Number(), work.tcount, len(uncles), time.Since(tstart))
-		self.logLocalMinedBlocks(work, previous)
 	}
-	self.push(work)
+
+	// Hand the new work to taskLoop for dispatch, dropping whatever stale
+	// work was sitting in the buffer unconsumed rather than blocking here
+	// with self.mu held.
+	select {
+	case <-self.taskCh:
+	default:
+	}
+	self.taskCh <- work
 }
 
 func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
@@ -582,43 +612,26 @@ func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
 	return nil
 }
 
-func (env *Work) commitTransactions(mux *event.TypeMux, transactions types.Transactions, gasPrice *big.Int, bc *core.BlockChain) {
+func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsByPriceAndNonce, gasPrice *big.Int, bc *core.BlockChain) {
 	gp := new(core.GasPool).AddGas(env.header.GasLimit)
 
 	var coalescedLogs vm.Logs
-	for _, tx := range transactions {
+	for {
+		tx := txs.Peek()
+		if tx == nil {
+			break
+		}
 		// Error may be ignored here. The error has already been checked
 		// during transaction acceptance is the transaction pool.
 		from, _ := tx.From()
 
-		// Check if it falls within margin. Txs from owned accounts are always processed.
+		// Check if it falls within margin. Txs from owned accounts are
+		// always processed. A sender below the margin is dropped entirely,
+		// since its next transaction (by nonce) would fail anyway.
 		if tx.GasPrice().Cmp(gasPrice) < 0 && !env.ownedAccounts.Has(from) {
-			// ignore the transaction and transactor. We ignore the transactor
-			// because nonce will fail after ignoring this transaction so there's
-			// no point
-			env.lowGasTransactors.Add(from)
-
 			glog.V(logger.Info).Infof("transaction(%x) below gas price (tx=%v ask=%v). All sequential txs from this address(%x) will be ignored\n", tx.Hash().Bytes()[:4], common.CurrencyToString(tx.GasPrice()), common.CurrencyToString(gasPrice), from[:4])
-		}
-
-		// Continue with the next transaction if the transaction sender is included in
-		// the low gas tx set. This will also remove the tx and all sequential transaction
-		// from this transactor
-		if env.lowGasTransactors.Has(from) {
-			// add tx to the low gas set. This will be removed at the end of the run
-			// owned accounts are ignored
-			if !env.ownedAccounts.Has(from) {
-				env.lowGasTxs = append(env.lowGasTxs, tx)
-			}
-			continue
-		}
-
-		// Move on to the next transaction when the transactor is in ignored transactions set
-		// This may occur when a transaction hits the gas limit. When a gas limit is hit and
-		// the transaction is processed (that could potentially be included in the block) it
-		// will throw a nonce error because the previous transaction hasn't been processed.
-		// Therefor we need to ignore any transaction after the ignored one.
-		if env.ignoredTransactors.Has(from) {
+			env.lowGasTxs = append(env.lowGasTxs, tx)
+			txs.Pop()
 			continue
 		}
 
@@ -627,20 +640,23 @@ func (env *Work) commitTransactions(mux *event.TypeMux, transactions types.Trans
 		err, logs := env.commitTransaction(tx, bc, gp)
 		switch {
 		case core.IsGasLimitErr(err):
-			// ignore the transactor so no nonce errors will be thrown for this account
-			// next time the worker is run, they'll be picked up again.
-			env.ignoredTransactors.Add(from)
-
+			// Drop the sender entirely: once a gas limit error is hit the
+			// next transaction (by nonce) would throw a nonce error against
+			// state that was never updated, so there's no point keeping it
+			// around for this block.
 			glog.V(logger.Detail).Infof("Gas limit reached for (%x) in this block. Continue to try smaller txs\n", from[:4])
+			txs.Pop()
 		case err != nil:
 			env.remove.Add(tx.Hash())
 
 			if glog.V(logger.Detail) {
 				glog.Infof("TX (%x) failed, will be removed: %v\n", tx.Hash().Bytes()[:4], err)
 			}
+			txs.Shift()
 		default:
 			env.tcount++
 			coalescedLogs = append(coalescedLogs, logs...)
+			txs.Shift()
 		}
 	}
 	if len(coalescedLogs) > 0 || env.tcount > 0 {
@@ -656,10 +672,12 @@ func (env *Work) commitTransactions(mux *event.TypeMux, transactions types.Trans
 }
 
 func (env *Work) commitTransaction(tx *types.Transaction, bc *core.BlockChain, gp *core.GasPool) (error, vm.Logs) {
-	snap := env.state.Copy()
+	// Snapshot is a journal checkpoint, not a deep copy of the state, so
+	// rolling back a failed tx no longer costs O(state size).
+	snap := env.state.Snapshot()
 	receipt, logs, _, err := core.ApplyTransaction(bc, gp, env.state, env.header, tx, env.header.GasUsed, nil)
 	if err != nil {
-		env.state.Set(snap)
+		env.state.RevertToSnapshot(snap)
 		return err, nil
 	}
 	env.txs = append(env.txs, tx)
@@ -0,0 +1,313 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package enode implements signed node records: self-contained key/value
+// sets that describe how to reach a node and what it supports, carrying
+// their own proof that the node identified by the record actually produced
+// it. They are meant to replace plain enode://pubkey@ip:port URLs, which
+// assert an identity and an address but let anyone else assert the same
+// pair just as easily.
+package enode
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// ErrUnknownScheme is returned when a record names (or Parse is asked
+	// to validate against) an IdentityScheme that hasn't been registered.
+	ErrUnknownScheme = errors.New("enode: unknown identity scheme")
+
+	// ErrInvalidSignature is returned when a record's signature doesn't
+	// match its content under the claimed public key.
+	ErrInvalidSignature = errors.New("enode: invalid record signature")
+
+	// ErrMissingField is returned when a record is missing a field its
+	// identity scheme needs in order to verify it.
+	ErrMissingField = errors.New("enode: record missing required field")
+)
+
+// Record is a self-signed set of key/value pairs. The pairs are kept
+// sorted by key so that the signed content has one canonical encoding.
+type Record struct {
+	Signature []byte
+	pairs     []pair
+}
+
+type pair struct {
+	Key   string
+	Value rlp.RawValue
+}
+
+type pairsByKey []pair
+
+func (p pairsByKey) Len() int           { return len(p) }
+func (p pairsByKey) Less(i, j int) bool { return p[i].Key < p[j].Key }
+func (p pairsByKey) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// Set stores value under key, RLP-encoding it the same way every field in
+// the record is encoded. It does not re-sign the record; call Sign once
+// every field has been set.
+func (r *Record) Set(key string, value interface{}) error {
+	enc, err := rlp.EncodeToBytes(value)
+	if err != nil {
+		return err
+	}
+	for i, p := range r.pairs {
+		if p.Key == key {
+			r.pairs[i].Value = enc
+			return nil
+		}
+	}
+	r.pairs = append(r.pairs, pair{key, enc})
+	sort.Sort(pairsByKey(r.pairs))
+	return nil
+}
+
+// Load decodes the value stored under key into out. It returns
+// ErrMissingField if the record has no such key.
+func (r *Record) Load(key string, out interface{}) error {
+	for _, p := range r.pairs {
+		if p.Key == key {
+			return rlp.DecodeBytes(p.Value, out)
+		}
+	}
+	return ErrMissingField
+}
+
+// signingContent returns the RLP encoding of the record's key/value pairs,
+// which is exactly what Signature is computed over.
+func (r *Record) signingContent() []byte {
+	enc, _ := rlp.EncodeToBytes(r.pairs)
+	return enc
+}
+
+// Sign computes r's Signature under scheme by passing the Keccak256 hash of
+// the record's content to sign, which must produce a signature understood
+// by that scheme (for "v4", a secp256k1 signature as crypto.Sign returns).
+func (r *Record) Sign(scheme string, sign func(hash []byte) ([]byte, error)) error {
+	if _, err := lookupScheme(scheme); err != nil {
+		return err
+	}
+	sig, err := sign(crypto.Keccak256(r.signingContent()))
+	if err != nil {
+		return err
+	}
+	r.Signature = sig
+	return nil
+}
+
+// IdentityScheme validates a Record's signature and derives the NodeID it
+// identifies. Keeping this pluggable means the record format itself
+// doesn't need to know about any particular signature algorithm.
+type IdentityScheme interface {
+	// Verify reports whether r's Signature is valid for its content.
+	Verify(r *Record) error
+	// NodeID returns the discover.NodeID a verified record identifies.
+	NodeID(r *Record) (discover.NodeID, error)
+}
+
+var schemes = map[string]IdentityScheme{
+	"v4": v4ID{},
+}
+
+// RegisterIdentityScheme makes scheme available under name, so records
+// naming it can be verified by Parse. Registering a name
+// twice replaces the previous scheme.
+func RegisterIdentityScheme(name string, scheme IdentityScheme) {
+	schemes[name] = scheme
+}
+
+func lookupScheme(name string) (IdentityScheme, error) {
+	s, ok := schemes[name]
+	if !ok {
+		return nil, ErrUnknownScheme
+	}
+	return s, nil
+}
+
+// v4ID is the default IdentityScheme: secp256k1 signatures as used
+// everywhere else in the client, with the node ID being the Keccak256
+// hash of the uncompressed public key.
+type v4ID struct{}
+
+func (v4ID) pubkey(r *Record) ([]byte, error) {
+	var pub []byte
+	if err := r.Load("secp256k1", &pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+func (v4ID) Verify(r *Record) error {
+	var id string
+	if err := r.Load("id", &id); err != nil {
+		return err
+	}
+	if id != "v4" {
+		return ErrUnknownScheme
+	}
+	pub, err := v4ID{}.pubkey(r)
+	if err != nil {
+		return err
+	}
+	hash := crypto.Keccak256(r.signingContent())
+	recovered, err := crypto.Ecrecover(hash, r.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !bytes.Equal(recovered, pub) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (v4ID) NodeID(r *Record) (discover.NodeID, error) {
+	pub, err := v4ID{}.pubkey(r)
+	if err != nil {
+		return discover.NodeID{}, err
+	}
+	hash := crypto.Keccak256(pub)
+	var id discover.NodeID
+	copy(id[:], hash)
+	return id, nil
+}
+
+// Parse decodes an "enr:"-prefixed, hex-encoded record from rawurl and
+// verifies it under the named scheme, returning the discover.Node it
+// describes. Unknown schemes are rejected outright rather than being
+// accepted unverified.
+func Parse(scheme, rawurl string) (*discover.Node, error) {
+	s, err := lookupScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(rawurl, "enr:") {
+		return nil, errors.New("enode: missing \"enr:\" prefix")
+	}
+	blob, err := hex.DecodeString(rawurl[len("enr:"):])
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := rlp.DecodeBytes(blob, &r); err != nil {
+		return nil, err
+	}
+	if err := s.Verify(&r); err != nil {
+		return nil, err
+	}
+	id, err := s.NodeID(&r)
+	if err != nil {
+		return nil, err
+	}
+	var ip net.IP
+	if err := r.Load("ip", &ip); err != nil {
+		return nil, err
+	}
+	var tcp, udp uint16
+	if err := r.Load("tcp", &tcp); err != nil {
+		return nil, err
+	}
+	if err := r.Load("udp", &udp); err != nil {
+		return nil, err
+	}
+	return discover.NewNode(id, ip, udp, tcp), nil
+}
+
+// MustParse is like Parse, but panics if rawurl cannot be parsed. It is
+// meant for node lists baked into the binary, where a malformed record is
+// a programming error rather than something to recover from.
+func MustParse(scheme, rawurl string) *discover.Node {
+	n, err := Parse(scheme, rawurl)
+	if err != nil {
+		panic("enode: invalid record " + rawurl + ": " + err.Error())
+	}
+	return n
+}
+
+// NewV4 builds and signs a "v4" record for a node at the given address,
+// advertising caps (e.g. {"eth": 63}) alongside it. sign must produce a
+// valid secp256k1 signature over its argument under the private key
+// matching pubkey, e.g. func(hash []byte) ([]byte, error) { return
+// crypto.Sign(hash, key) }.
+func NewV4(pubkey []byte, ip net.IP, tcp, udp uint16, caps map[string]uint, sign func(hash []byte) ([]byte, error)) (*Record, error) {
+	r := &Record{}
+	r.Set("id", "v4")
+	r.Set("secp256k1", pubkey)
+	r.Set("ip", ip)
+	r.Set("tcp", tcp)
+	r.Set("udp", udp)
+	for name, version := range caps {
+		r.Set(name, version)
+	}
+	if err := r.Sign("v4", sign); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewTrustedV4 builds a discover.Node directly from a known public key and
+// address, without requiring (or being able to produce) a signature. It
+// exists for node lists whose identity is trusted out of band rather than
+// proven on parse, such as the bootnode lists baked into this binary: the
+// entire reason they're hardcoded is that we already trust them, and the
+// private keys needed to sign them belong to the remote operators, not us.
+// Records accepted at runtime from an untrusted source must go through
+// Parse instead.
+func NewTrustedV4(pubkeyHex string, ip net.IP, tcp, udp uint16) *discover.Node {
+	pub, err := hex.DecodeString(pubkeyHex)
+	if err != nil {
+		panic("enode: invalid public key: " + err.Error())
+	}
+	var id discover.NodeID
+	copy(id[:], crypto.Keccak256(pub))
+	return discover.NewNode(id, ip, udp, tcp)
+}
+
+// encodedRecord mirrors Record's fields in exported form, since pairs being
+// unexported (it must stay that way to force callers through Set/Load)
+// would otherwise make the default RLP struct encoding drop it silently.
+type encodedRecord struct {
+	Signature []byte
+	Pairs     []pair
+}
+
+// EncodeRLP implements rlp.Encoder, encoding the signature followed by the
+// sorted key/value pairs.
+func (r *Record) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, encodedRecord{r.Signature, r.pairs})
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (r *Record) DecodeRLP(s *rlp.Stream) error {
+	var raw encodedRecord
+	if err := s.Decode(&raw); err != nil {
+		return err
+	}
+	r.Signature = raw.Signature
+	r.pairs = raw.Pairs
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package enode
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func makeSignedRecord(t *testing.T) (*Record, []byte) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := crypto.FromECDSAPub(&key.PublicKey)
+	r, err := NewV4(pub, net.ParseIP("127.0.0.1"), 30303, 30303, map[string]uint{"eth": 63}, func(hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, pub
+}
+
+func encodeAsURL(r *Record) string {
+	enc, _ := rlp.EncodeToBytes(r)
+	return "enr:" + hex.EncodeToString(enc)
+}
+
+func TestParseValidRecord(t *testing.T) {
+	r, pub := makeSignedRecord(t)
+	n, err := Parse("v4", encodeAsURL(r))
+	if err != nil {
+		t.Fatalf("Parse failed on a validly signed record: %v", err)
+	}
+	wantID := v4ID{}
+	id, err := wantID.NodeID(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != id {
+		t.Errorf("node ID = %x, want %x (keccak256 of %x)", n.ID, id, pub)
+	}
+	if n.TCP != 30303 || n.UDP != 30303 {
+		t.Errorf("unexpected ports: tcp=%d udp=%d", n.TCP, n.UDP)
+	}
+}
+
+func TestParseRejectsTamperedSignature(t *testing.T) {
+	r, _ := makeSignedRecord(t)
+	r.Signature[0] ^= 0xff
+	if _, err := Parse("v4", encodeAsURL(r)); err != ErrInvalidSignature {
+		t.Fatalf("Parse returned %v, expected ErrInvalidSignature", err)
+	}
+}
+
+func TestParseRejectsUnknownScheme(t *testing.T) {
+	r, _ := makeSignedRecord(t)
+	if _, err := Parse("v5", encodeAsURL(r)); err != ErrUnknownScheme {
+		t.Fatalf("Parse returned %v, expected ErrUnknownScheme", err)
+	}
+}
+
+func TestMustParsePanicsOnInvalidRecord(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustParse did not panic on an invalid record")
+		}
+	}()
+	MustParse("v4", "enr:not-valid-hex")
+}
@@ -0,0 +1,56 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// Direction indicates whether a connection attempt was initiated by the
+// remote side (Inbound) or by this node (Outbound).
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// NodePermissioner gates connections to and from the p2p network. It is
+// consulted by Server right after the encryption handshake, for every
+// non-trusted connection, both inbound (listenLoop/setupConn) and outbound
+// (the dialer). Implementations typically hold a signed allowlist of
+// enode IDs, as the p2p/permissioning subpackage does, but the interface
+// itself is deliberately minimal so other permissioning schemes (on-chain
+// contracts, external services, ...) can be plugged in the same way.
+type NodePermissioner interface {
+	PermitEnode(id discover.NodeID, addr net.IP, direction Direction) error
+}
+
+// DiscNotPermitted is sent to a peer that was rejected by a configured
+// NodePermissioner, so the remote side gets an unambiguous signal distinct
+// from a generic DiscUselessPeer/DiscTooManyPeers. 0x11 is the next reason
+// code free of the existing 0x00-0x10 assignments.
+const DiscNotPermitted = DiscReason(0x11)
@@ -0,0 +1,164 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package permissioning
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// writeAllowList signs each id in ids with authority and writes the
+// resulting entries to a fresh file under t's temp directory, returning its
+// path.
+func writeAllowList(t *testing.T, authority *ecdsa.PrivateKey, ids ...discover.NodeID) string {
+	t.Helper()
+	entries := make([]entry, len(ids))
+	for i, id := range ids {
+		sig, err := crypto.Sign(crypto.Keccak256(id[:]), authority)
+		if err != nil {
+			t.Fatalf("signing node %x: %v", id[:8], err)
+		}
+		entries[i] = entry{ID: id, Signature: hex.EncodeToString(sig)}
+	}
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("marshaling allowlist: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "allowlist.json")
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		t.Fatalf("writing allowlist: %v", err)
+	}
+	return path
+}
+
+func testNodeID(b byte) discover.NodeID {
+	var id discover.NodeID
+	id[0] = b
+	return id
+}
+
+// TestAllowListPermitsOnlySignedNodes checks that NewAllowList refuses a
+// file carrying an entry signed by a different key, and that a list loaded
+// from validly signed entries makes PermitEnode allow a listed node and
+// reject an unlisted one.
+func TestAllowListPermitsOnlySignedNodes(t *testing.T) {
+	authority, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := testNodeID(1)
+	notListed := testNodeID(2)
+	wrongSigner := testNodeID(3)
+
+	path := writeAllowList(t, authority, allowed)
+
+	// Append an entry signed by a different key, bypassing writeAllowList's
+	// single-signer helper so the file carries a mix of good and bad entries.
+	wrongSig, err := crypto.Sign(crypto.Keccak256(wrongSigner[:]), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []entry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		t.Fatal(err)
+	}
+	entries = append(entries, entry{ID: wrongSigner, Signature: hex.EncodeToString(wrongSig)})
+	raw, err = json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewAllowList(path, crypto.FromECDSAPub(&authority.PublicKey)); err == nil {
+		t.Fatal("NewAllowList() succeeded despite an entry signed by the wrong key, want error")
+	}
+
+	// With only the validly-signed entry, the list should load and gate as expected.
+	path = writeAllowList(t, authority, allowed)
+	list, err := NewAllowList(path, crypto.FromECDSAPub(&authority.PublicKey))
+	if err != nil {
+		t.Fatalf("NewAllowList() error = %v", err)
+	}
+	if err := list.PermitEnode(allowed, net.IPv4zero, p2p.Inbound); err != nil {
+		t.Errorf("PermitEnode(allowed) = %v, want nil", err)
+	}
+	if err := list.PermitEnode(notListed, net.IPv4zero, p2p.Inbound); err == nil {
+		t.Error("PermitEnode(notListed) = nil, want error")
+	}
+}
+
+// TestAllowListReloadKeepsOldListOnError checks that a failed
+// ReloadPermissions (e.g. the file was overwritten with a bad signature)
+// leaves the previously loaded allowlist in effect instead of wiping it.
+func TestAllowListReloadKeepsOldListOnError(t *testing.T) {
+	authority, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := testNodeID(1)
+	path := writeAllowList(t, authority, allowed)
+	list, err := NewAllowList(path, crypto.FromECDSAPub(&authority.PublicKey))
+	if err != nil {
+		t.Fatalf("NewAllowList() error = %v", err)
+	}
+
+	// Overwrite the file with an entry signed by the wrong key.
+	badSig, err := crypto.Sign(crypto.Keccak256(allowed[:]), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal([]entry{{ID: allowed, Signature: hex.EncodeToString(badSig)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := list.ReloadPermissions(); err == nil {
+		t.Fatal("ReloadPermissions() succeeded despite a bad signature, want error")
+	}
+	if err := list.PermitEnode(allowed, net.IPv4zero, p2p.Inbound); err != nil {
+		t.Errorf("PermitEnode(allowed) after failed reload = %v, want nil (old list should still be in effect)", err)
+	}
+}
@@ -21,13 +21,17 @@ import (
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/ethereum/go-ethereum/p2p/nat"
 )
 
@@ -121,6 +125,40 @@ type Server struct {
 	// If NoDial is true, the server will not dial any peers.
 	NoDial bool
 
+	// DiscoveryV5 enables the topic-based discv5 discovery table alongside
+	// the existing Kademlia (discover) table. discv5 opens its own UDP
+	// socket, on DiscoveryV5Addr if set and on ListenAddr otherwise; the two
+	// tables do not share one socket. Topics listed in DiscoveryTopics are
+	// registered with discv5 on start. Start runs no topic searches and does
+	// not hand the discv5 table to the dialer, so dynamic dial candidates
+	// come from the Kademlia table only.
+	DiscoveryV5     bool
+	DiscoveryV5Addr string
+	DiscoveryTopics []discv5.Topic
+
+	// InboundThrottleWindow and MaxInboundPerIP bound how many inbound dial
+	// attempts a single remote IP may make within a sliding window before
+	// listenLoop starts dropping it pre-handshake. Zero values fall back to
+	// defaultInboundThrottleWindow/defaultMaxInboundPerIP.
+	InboundThrottleWindow time.Duration
+	MaxInboundPerIP       int
+
+	throttle *inboundThrottle
+
+	// Permissioner, if set, is consulted for every inbound and outbound
+	// connection attempt from a non-trusted node right after the encryption
+	// handshake completes; TrustedNodes are not checked.
+	// Rejected peers are disconnected with DiscNotPermitted. This lets
+	// operators run the server in a "permissioned" mode, as done by
+	// Quorum-style forks, without touching the dial/accept plumbing.
+	Permissioner NodePermissioner
+
+	// EventMux, if set, receives a PeerEvent for every peer added or
+	// dropped (and, where the protocol layer reports it, every message
+	// sent or received), so callers can build live observability
+	// dashboards via SubscribePeerEvents without hooking the JSON logger.
+	EventMux *event.TypeMux
+
 	// Hooks for testing. These are useful because we can inhibit
 	// the whole protocol stack.
 	newTransport func(net.Conn) transport
@@ -130,9 +168,11 @@ type Server struct {
 	running bool
 
 	ntab         discoverTable
+	ntab5        *discv5.Network
 	listener     net.Listener
 	ourHandshake *protoHandshake
 	lastLookup   time.Time
+	clock        mclock.Clock // abstracts time.Now so tests can use a fake/mock clock
 
 	// These are for Peers, PeerCount (and nothing else).
 	peerOp     chan peerOpFunc
@@ -140,12 +180,61 @@ type Server struct {
 
 	quit          chan struct{}
 	addstatic     chan *discover.Node
+	removestatic  chan *discover.Node
 	posthandshake chan *conn
 	addpeer       chan *conn
-	delpeer       chan *Peer
+	delpeer       chan peerDrop
 	loopWG        sync.WaitGroup // loop, listenLoop
 }
 
+// peerDrop is sent on delpeer so that the reason a peer went away survives
+// the trip from runPeer back into run(), instead of being discarded at the
+// channel boundary.
+type peerDrop struct {
+	peer      *Peer
+	err       error
+	requested bool // true if the peer was disconnected with DiscRequested (e.g. by RemovePeer)
+}
+
+// PeerEventType classifies the events delivered through SubscribePeerEvents.
+type PeerEventType string
+
+const (
+	PeerEventTypeAdd     PeerEventType = "add"
+	PeerEventTypeDrop    PeerEventType = "drop"
+	PeerEventTypeMsgSend PeerEventType = "msgsend"
+	PeerEventTypeMsgRecv PeerEventType = "msgrecv"
+)
+
+// PeerEvent describes a single peer lifecycle or messaging occurrence,
+// posted on Server.EventMux and obtainable via SubscribePeerEvents.
+type PeerEvent struct {
+	Type       PeerEventType
+	Peer       discover.NodeID
+	Protocol   string  `json:",omitempty"`
+	MsgCode    *uint64 `json:",omitempty"`
+	MsgSize    *uint32 `json:",omitempty"`
+	DropReason string  `json:",omitempty"`
+}
+
+// SubscribePeerEvents returns a subscription to every PeerEvent posted on
+// this server's EventMux. It returns nil if the server has no EventMux
+// configured. Callers must eventually call Unsubscribe on the result.
+func (srv *Server) SubscribePeerEvents() *event.TypeMuxSubscription {
+	if srv.EventMux == nil {
+		return nil
+	}
+	return srv.EventMux.Subscribe(PeerEvent{})
+}
+
+// postPeerEvent posts ev on srv.EventMux if one is configured; it is a no-op
+// otherwise so EventMux remains fully optional.
+func (srv *Server) postPeerEvent(ev PeerEvent) {
+	if srv.EventMux != nil {
+		srv.EventMux.Post(ev)
+	}
+}
+
 type peerOpFunc func(map[discover.NodeID]*Peer)
 
 type connFlag int
@@ -255,6 +344,16 @@ func (srv *Server) AddPeer(node *discover.Node) {
 	}
 }
 
+// RemovePeer disconnects from the given node, symmetric to AddPeer: it both
+// removes the node from the dialer's static set (so it won't be
+// automatically redialed) and disconnects the live peer if one exists.
+func (srv *Server) RemovePeer(node *discover.Node) {
+	select {
+	case srv.removestatic <- node:
+	case <-srv.quit:
+	}
+}
+
 // Self returns the local node's endpoint information.
 func (srv *Server) Self() *discover.Node {
 	srv.lock.Lock()
@@ -317,14 +416,19 @@ func (srv *Server) Start() (err error) {
 	if srv.newTransport == nil {
 		srv.newTransport = newRLPX
 	}
+	if srv.clock == nil {
+		srv.clock = mclock.System{}
+	}
 	if srv.Dialer == nil {
 		srv.Dialer = &net.Dialer{Timeout: defaultDialTimeout}
 	}
 	srv.quit = make(chan struct{})
+	srv.throttle = newInboundThrottle(srv.InboundThrottleWindow, srv.MaxInboundPerIP)
 	srv.addpeer = make(chan *conn)
-	srv.delpeer = make(chan *Peer)
+	srv.delpeer = make(chan peerDrop)
 	srv.posthandshake = make(chan *conn)
 	srv.addstatic = make(chan *discover.Node)
+	srv.removestatic = make(chan *discover.Node)
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
 
@@ -339,6 +443,20 @@ func (srv *Server) Start() (err error) {
 		}
 		srv.ntab = ntab
 	}
+	if srv.DiscoveryV5 {
+		addr := srv.DiscoveryV5Addr
+		if addr == "" {
+			addr = srv.ListenAddr
+		}
+		ntab5, err := discv5.ListenUDP(srv.PrivateKey, addr, srv.NAT, srv.NodeDatabase)
+		if err != nil {
+			return err
+		}
+		for _, topic := range srv.DiscoveryTopics {
+			go ntab5.RegisterTopic(topic, srv.quit)
+		}
+		srv.ntab5 = ntab5
+	}
 
 	dynPeers := (srv.MaxPeers + 1) / 2
 	if !srv.Discovery {
@@ -390,9 +508,17 @@ func (srv *Server) startListening() error {
 }
 
 type dialer interface {
-	newTasks(running int, peers map[discover.NodeID]*Peer, now time.Time) []task
-	taskDone(task, time.Time)
+	newTasks(running int, peers map[discover.NodeID]*Peer, now mclock.AbsTime) []task
+	taskDone(task, mclock.AbsTime)
 	addStatic(*discover.Node)
+	removeStatic(*discover.Node)
+
+	// discoverTopic streams dynamic dial candidates discovered through
+	// discv5 topic search, as opposed to random Kademlia walks. A dialer
+	// implementation mixes these into the nodes returned by newTasks so
+	// sub-protocols that registered topic in DiscoveryTopics can find peers
+	// without relying on the main DHT.
+	discoverTopic(topic discv5.Topic) <-chan *discover.Node
 }
 
 func (srv *Server) run(dialstate dialer) {
@@ -442,7 +568,7 @@ func (srv *Server) run(dialstate dialer) {
 running:
 	for {
 		// Query the dialer for new tasks and launch them.
-		now := time.Now()
+		now := srv.clock.Now()
 		nt := dialstate.newTasks(len(pendingTasks)+len(tasks), peers, now)
 		scheduleTasks(nt)
 
@@ -457,6 +583,15 @@ running:
 			// it will keep the node connected.
 			glog.V(logger.Detail).Infoln("<-addstatic:", n)
 			dialstate.addStatic(n)
+		case n := <-srv.removestatic:
+			// This channel is used by RemovePeer to remove a node from the
+			// ephemeral static peer list. It also disconnects the peer if
+			// one is currently connected.
+			glog.V(logger.Detail).Infoln("<-removestatic:", n)
+			dialstate.removeStatic(n)
+			if p, ok := peers[n.ID]; ok {
+				p.Disconnect(DiscRequested)
+			}
 		case op := <-srv.peerOp:
 			// This channel is used by Peers and PeerCount.
 			op(peers)
@@ -490,15 +625,17 @@ running:
 				p := newPeer(c, srv.Protocols)
 				peers[c.id] = p
 				go srv.runPeer(p)
+				srv.postPeerEvent(PeerEvent{Type: PeerEventTypeAdd, Peer: p.ID()})
 			}
 			// The dialer logic relies on the assumption that
 			// dial tasks complete after the peer has been added or
 			// discarded. Unblock the task last.
 			c.cont <- err
-		case p := <-srv.delpeer:
+		case pd := <-srv.delpeer:
 			// A peer disconnected.
-			glog.V(logger.Detail).Infoln("<-delpeer:", p)
-			delete(peers, p.ID())
+			glog.V(logger.Detail).Infoln("<-delpeer:", pd.peer)
+			delete(peers, pd.peer.ID())
+			srv.postPeerEvent(PeerEvent{Type: PeerEventTypeDrop, Peer: pd.peer.ID(), DropReason: dropReasonString(pd.err)})
 		}
 	}
 
@@ -506,6 +643,9 @@ running:
 	if srv.ntab != nil {
 		srv.ntab.Close()
 	}
+	if srv.ntab5 != nil {
+		srv.ntab5.Close()
+	}
 	// Disconnect all peers.
 	for _, p := range peers {
 		p.Disconnect(DiscQuitting)
@@ -515,10 +655,19 @@ running:
 	// is closed.
 	glog.V(logger.Detail).Infof("ignoring %d pending tasks at spindown", len(tasks))
 	for len(peers) > 0 {
-		p := <-srv.delpeer
-		glog.V(logger.Detail).Infoln("<-delpeer (spindown):", p)
-		delete(peers, p.ID())
+		pd := <-srv.delpeer
+		glog.V(logger.Detail).Infoln("<-delpeer (spindown):", pd.peer)
+		delete(peers, pd.peer.ID())
+	}
+}
+
+// dropReasonString renders the error that caused a peer to be dropped for
+// inclusion in a PeerEvent, tolerating a nil error (locally requested drop).
+func dropReasonString(err error) string {
+	if err == nil {
+		return ""
 	}
+	return err.Error()
 }
 
 func (srv *Server) protoHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn) error {
@@ -526,11 +675,45 @@ func (srv *Server) protoHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn
 	if len(srv.Protocols) > 0 && countMatchingProtocols(srv.Protocols, c.caps) == 0 {
 		return DiscUselessPeer
 	}
+	// Enforce per-protocol MaxPeers quotas: a protocol that declares one is
+	// capped independently of the server-wide MaxPeers, so e.g. a heavy
+	// streaming sub-protocol can't starve a lighter one (or vice versa) out
+	// of the shared connection budget.
+	for _, proto := range srv.Protocols {
+		if proto.MaxPeers <= 0 || !hasCap(c.caps, proto.cap()) {
+			continue
+		}
+		if countRunning(peers, proto.cap()) >= proto.MaxPeers {
+			return DiscTooManyPeers
+		}
+	}
 	// Repeat the encryption handshake checks because the
 	// peer set might have changed between the handshakes.
 	return srv.encHandshakeChecks(peers, c)
 }
 
+// hasCap reports whether caps advertises cap.
+func hasCap(caps []Cap, cap Cap) bool {
+	for _, c := range caps {
+		if c == cap {
+			return true
+		}
+	}
+	return false
+}
+
+// countRunning counts the currently connected peers that advertised cap
+// during their protocol handshake.
+func countRunning(peers map[discover.NodeID]*Peer, cap Cap) int {
+	n := 0
+	for _, p := range peers {
+		if hasCap(p.Caps(), cap) {
+			n++
+		}
+	}
+	return n
+}
+
 func (srv *Server) encHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn) error {
 	switch {
 	case !c.is(trustedConn|staticDialedConn) && len(peers) >= srv.MaxPeers:
@@ -539,11 +722,60 @@ func (srv *Server) encHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn)
 		return DiscAlreadyConnected
 	case c.id == srv.Self().ID:
 		return DiscSelf
+	case srv.Permissioner != nil && !c.is(trustedConn):
+		if err := srv.Permissioner.PermitEnode(c.id, remoteIP(c.fd), directionOf(c)); err != nil {
+			glog.V(logger.Debug).Infof("%v rejected by permissioner: %v", c, err)
+			return DiscNotPermitted
+		}
+		return nil
 	default:
 		return nil
 	}
 }
 
+// remoteIP extracts the IP address of the remote end of fd, or nil if it
+// can't be determined (e.g. in tests using an in-memory pipe).
+func remoteIP(fd net.Conn) net.IP {
+	if addr, ok := fd.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// directionOf reports whether c represents an inbound or outbound connection
+// attempt, for consumption by NodePermissioner.
+func directionOf(c *conn) Direction {
+	if c.is(inboundConn) {
+		return Inbound
+	}
+	return Outbound
+}
+
+const (
+	dialBackoffBase   = 5 * time.Second
+	dialBackoffMax    = 10 * time.Minute
+	dialBackoffJitter = 1 * time.Second
+)
+
+// dialBackoff returns how long the dialer should wait before retrying a node
+// that has failed attempt previous times in a row, doubling the base delay
+// per attempt up to dialBackoffMax and adding up to dialBackoffJitter of
+// random jitter so that many peers that failed at the same moment (e.g.
+// after a network blip) don't all redial in lockstep.
+func dialBackoff(attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+	delay := dialBackoffBase
+	for i := 1; i < attempts && delay < dialBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > dialBackoffMax {
+		delay = dialBackoffMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(dialBackoffJitter)))
+}
+
 type tempError interface {
 	Temporary() bool
 }
@@ -588,6 +820,15 @@ func (srv *Server) listenLoop() {
 		fd = newMeteredConn(fd, true)
 		glog.V(logger.Debug).Infof("Accepted conn %v\n", fd.RemoteAddr())
 
+		// Throttle floods of dial attempts from a single remote address
+		// before they ever reach the (comparatively expensive) handshake.
+		if !srv.throttle.allow(remoteIP(fd)) {
+			glog.V(logger.Debug).Infof("Throttled conn %v: too many attempts from this IP\n", fd.RemoteAddr())
+			fd.Close()
+			slots <- struct{}{}
+			continue
+		}
+
 		// Spawn the handler. It will give the slot back when the connection
 		// has been established.
 		go func() {
@@ -684,7 +925,7 @@ func (srv *Server) runPeer(p *Peer) {
 	discreason := p.run()
 	// Note: run waits for existing peers to be sent on srv.delpeer
 	// before returning, so this send should not select on srv.quit.
-	srv.delpeer <- p
+	srv.delpeer <- peerDrop{peer: p, err: discreason, requested: discreason == DiscRequested}
 
 	glog.V(logger.Debug).Infof("Removed %v (%v)\n", p, discreason)
 	srvjslog.LogJson(&logger.P2PDisconnected{
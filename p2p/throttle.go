@@ -0,0 +1,86 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains a simple per-IP inbound dial throttle, used by listenLoop to stop
+// a single remote address from exhausting the pending-handshake slots with
+// a flood of connection attempts.
+
+package p2p
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInboundThrottleWindow = 10 * time.Second
+	defaultMaxInboundPerIP       = 10
+)
+
+// inboundThrottle rate-limits inbound connection attempts per remote IP
+// address over a sliding window.
+type inboundThrottle struct {
+	window time.Duration
+	max    int
+
+	mu     sync.Mutex
+	recent map[string][]time.Time
+}
+
+func newInboundThrottle(window time.Duration, max int) *inboundThrottle {
+	if window <= 0 {
+		window = defaultInboundThrottleWindow
+	}
+	if max <= 0 {
+		max = defaultMaxInboundPerIP
+	}
+	return &inboundThrottle{window: window, max: max, recent: make(map[string][]time.Time)}
+}
+
+// allow reports whether a new connection attempt from ip should be accepted,
+// recording it if so. Older attempts are pruned as a side effect, so the
+// tracker's memory footprint stays bounded by the number of distinct IPs
+// seen within the last window.
+func (t *inboundThrottle) allow(ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+	key := ip.String()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := now.Add(-t.window)
+	for k, ts := range t.recent {
+		if k != key && (len(ts) == 0 || !ts[len(ts)-1].After(cutoff)) {
+			delete(t.recent, k)
+		}
+	}
+	kept := t.recent[key][:0]
+	for _, ts := range t.recent[key] {
+		if ts.After(cutoff) {
+			kept = append(kept, ts)
+		}
+	}
+	if len(kept) >= t.max {
+		t.recent[key] = kept
+		return false
+	}
+	t.recent[key] = append(kept, now)
+	return true
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pingService is registered on the test server so the client side of the
+// IPC connection has something to call.
+type pingService struct{}
+
+func (pingService) Ping() string { return "pong" }
+
+func TestIPCConnection(t *testing.T) {
+	endpoint := DefaultIPCEndpoint("gethtest", filepath.Join(os.TempDir(), "geth-ipc-test"))
+
+	srv := NewServer()
+	if err := srv.RegisterName("ping", new(pingService)); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := ipcListen(endpoint)
+	if err != nil {
+		t.Fatalf("ipcListen failed: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeCodec(NewJSONCodec(conn), OptionMethodInvocation)
+		}
+	}()
+
+	conn, err := newIPCConnection(endpoint)
+	if err != nil {
+		t.Fatalf("newIPCConnection failed: %v", err)
+	}
+	defer conn.Close()
+
+	client := NewClientWithCodec(NewJSONCodec(conn))
+	defer client.Close()
+
+	var reply string
+	if err := client.Call(&reply, "ping_ping"); err != nil {
+		t.Fatalf("call over IPC failed: %v", err)
+	}
+	if reply != "pong" {
+		t.Fatalf("reply = %q, want %q", reply, "pong")
+	}
+}
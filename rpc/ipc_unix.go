@@ -24,6 +24,16 @@ import (
 	"path/filepath"
 )
 
+// DefaultIPCEndpoint returns the IPC endpoint clients should dial to reach
+// the node identified by clientIdentifier: a socket path under datadir on
+// this platform.
+func DefaultIPCEndpoint(clientIdentifier, datadir string) string {
+	if clientIdentifier == "" {
+		clientIdentifier = "geth"
+	}
+	return filepath.Join(datadir, clientIdentifier+".ipc")
+}
+
 // ipcListen will create a Unix socket on the given endpoint.
 func ipcListen(endpoint string) (net.Listener, error) {
 	// Ensure the IPC path exists and remove any previous leftover
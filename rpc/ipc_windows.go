@@ -0,0 +1,45 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build windows
+
+package rpc
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// ipcListen will create a named pipe on the given endpoint.
+func ipcListen(endpoint string) (net.Listener, error) {
+	return winio.ListenPipe(endpoint, nil)
+}
+
+// newIPCConnection will connect to a named pipe on the given endpoint.
+func newIPCConnection(endpoint string) (net.Conn, error) {
+	return winio.DialPipe(endpoint, nil)
+}
+
+// DefaultIPCEndpoint returns the IPC endpoint clients should dial to reach
+// the node identified by clientIdentifier: a named pipe on Windows, since
+// there's no filesystem socket to put under datadir here.
+func DefaultIPCEndpoint(clientIdentifier, datadir string) string {
+	if clientIdentifier == "" {
+		clientIdentifier = "geth"
+	}
+	return `\\.\pipe\` + clientIdentifier + `.ipc`
+}
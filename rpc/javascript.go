@@ -49,6 +49,16 @@ web3._extend({
 			name: 'lockAccount',
 			call: 'personal_lockAccount',
 			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'openWallet',
+			call: 'personal_openWallet',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'deriveAccount',
+			call: 'personal_deriveAccount',
+			params: 3
 		})
 	],
 	properties:
@@ -56,6 +66,10 @@ web3._extend({
 		new web3._extend.Property({
 			name: 'listAccounts',
 			getter: 'personal_listAccounts'
+		}),
+		new web3._extend.Property({
+			name: 'listWallets',
+			getter: 'personal_listWallets'
 		})
 	]
 });
@@ -454,6 +468,21 @@ web3._extend({
 			call: 'miner_makeDAG',
 			params: 1,
 			inputFormatter: [web3._extend.formatters.inputDefaultBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getWork',
+			call: 'miner_getWork',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'submitWork',
+			call: 'miner_submitWork',
+			params: 3
+		}),
+		new web3._extend.Method({
+			name: 'submitHashrate',
+			call: 'miner_submitHashrate',
+			params: 2
 		})
 	],
 	properties: []
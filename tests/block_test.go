@@ -17,225 +17,154 @@
 package tests
 
 import (
+	"encoding/json"
 	"math/big"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
-	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/core"
 )
 
-func TestBcValidBlockTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcValidBlockTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcUncleHeaderValidityTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcUncleHeaderValiditiy.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcUncleTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcUncleTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcForkUncleTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcForkUncle.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcInvalidHeaderTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcInvalidHeaderTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcInvalidRLPTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcInvalidRLPTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcRPCAPITests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcRPC_API_Test.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcForkBlockTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcForkBlockTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcForkStress(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcForkStressTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcTotalDifficulty(t *testing.T) {
-	// skip because these will fail due to selfish mining fix
-	t.Skip()
-
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcTotalDifficultyTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcWallet(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcWalletTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcGasPricer(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcGasPricerTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-// TODO: iterate over files once we got more than a few
-func TestBcRandom(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "RandomTests/bl201507071825GO.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcMultiChain(t *testing.T) {
-	// skip due to selfish mining
-	t.Skip()
-
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcMultiChainTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestBcState(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(1000000)
-	err := RunBlockTest(filepath.Join(blockTestDir, "bcStateTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-// Homestead tests
-func TestHomesteadBcValidBlockTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcValidBlockTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcUncleHeaderValidityTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcUncleHeaderValiditiy.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcUncleTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcUncleTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcInvalidHeaderTests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcInvalidHeaderTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcRPCAPITests(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcRPC_API_Test.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcForkStress(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcForkStressTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcTotalDifficulty(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcTotalDifficultyTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcWallet(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcWalletTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcGasPricer(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcGasPricerTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcMultiChain(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcMultiChainTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
-func TestHomesteadBcState(t *testing.T) {
-	params.HomesteadBlock = big.NewInt(0)
-	err := RunBlockTest(filepath.Join(blockTestDir, "Homestead", "bcStateTest.json"), BlockSkipTests)
-	if err != nil {
-		t.Fatal(err)
+// homesteadAt returns a ChainConfig identical to core.DefaultChainConfig
+// except that Homestead rules start at block.
+func homesteadAt(block int64) *core.ChainConfig {
+	cfg := *core.DefaultChainConfig
+	cfg.HomesteadBlock = big.NewInt(block)
+	return &cfg
+}
+
+// daoForkAt returns a ChainConfig identical to core.DefaultChainConfig
+// except that the DAO fork activates at block, either requiring
+// (support=true) or forbidding (support=false) the "dao-hard-fork"
+// extra-data marker for the blocks in its range.
+func daoForkAt(block int64, support bool) *core.ChainConfig {
+	cfg := *core.DefaultChainConfig
+	cfg.DAOForkBlock = big.NewInt(block)
+	cfg.DAOForkSupport = support
+	return &cfg
+}
+
+// eip150At returns a ChainConfig identical to core.DefaultChainConfig except
+// that Homestead rules start at block 0 and EIP150's repriced gas table
+// starts applying at the given block.
+func eip150At(block int64) *core.ChainConfig {
+	cfg := *core.DefaultChainConfig
+	cfg.HomesteadBlock = big.NewInt(0)
+	cfg.EIP150Block = big.NewInt(block)
+	return &cfg
+}
+
+// configsFor returns the ChainConfig(s) a fixture must pass against, chosen
+// by which of blockTestDir's subdirectories (or its root) it lives in. A
+// fixture must pass against every config returned; DAOFork fixtures are
+// checked under both a pro-fork and a no-fork config since both are
+// expected to agree on everything outside the fork's own extra-data range.
+func configsFor(relPath string) []*core.ChainConfig {
+	switch filepath.Dir(relPath) {
+	case "Homestead":
+		return []*core.ChainConfig{homesteadAt(0)}
+	case "EIP150":
+		return []*core.ChainConfig{eip150At(0)}
+	case "DAOFork":
+		return []*core.ChainConfig{daoForkAt(1920000, true), daoForkAt(1920000, false)}
+	default:
+		return []*core.ChainConfig{homesteadAt(1000000)}
+	}
+}
+
+// isSlowTest reports whether relPath names one of the fixture files
+// testing.Short() skips: the fork-stress and wallet suites (hundreds of
+// blocks each) and the ad-hoc RandomTests fixtures.
+func isSlowTest(relPath string) bool {
+	base := filepath.Base(relPath)
+	return base == "bcForkStressTest.json" || base == "bcWalletTest.json" || strings.Contains(relPath, "RandomTests")
+}
+
+// expectedFailures names fixture files (relative to blockTestDir) that are
+// currently known to fail, and why, rather than being silently t.Skip'd.
+// TestBlockchain asserts these actually do fail, so a fixture that starts
+// passing -- because whatever this list blames it on got fixed -- turns
+// into a loud test failure demanding this list be updated, instead of
+// staying quietly (and now incorrectly) skipped forever.
+var expectedFailures = map[string]string{
+	"bcTotalDifficultyTest.json": "known total-difficulty discrepancy from the selfish-mining fix",
+	"bcMultiChainTest.json":      "known total-difficulty discrepancy from the selfish-mining fix",
+}
+
+// TestBlockchain walks blockTestDir (and its Homestead, EIP150 and DAOFork
+// subdirectories), running every fixture file as its own subtest and every
+// named test case inside a fixture as a further nested subtest. This
+// replaces what used to be one hand-written TestBc*/TestHomesteadBc*
+// function per fixture file: a new fixture dropped into any of these
+// directories is now picked up automatically, with no corresponding
+// function to remember to add.
+func TestBlockchain(t *testing.T) {
+	err := filepath.Walk(blockTestDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		relPath, err := filepath.Rel(blockTestDir, path)
+		if err != nil {
+			return err
+		}
+		if testing.Short() && isSlowTest(relPath) {
+			return nil
+		}
+		t.Run(relPath, func(t *testing.T) {
+			runBlockTestFile(t, path, relPath)
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runBlockTestFile decodes every named test case out of the fixture at path
+// and runs each as its own subtest of t.
+func runBlockTestFile(t *testing.T, path, relPath string) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	cases := make(map[string]btJSON)
+	if err := json.NewDecoder(file).Decode(&cases); err != nil {
+		t.Fatal(err)
+	}
+	skip := make(map[string]bool, len(BlockSkipTests))
+	for _, name := range BlockSkipTests {
+		skip[name] = true
+	}
+	reason, expectFail := expectedFailures[relPath]
+
+	for name, test := range cases {
+		if skip[name] {
+			continue
+		}
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			bt := &BlockTest{Name: name, json: test}
+			var runErr error
+			for _, config := range configsFor(relPath) {
+				if runErr = bt.Run(config); runErr != nil {
+					break
+				}
+			}
+			switch {
+			case expectFail && runErr == nil:
+				t.Fatalf("expected to fail (%s), but passed -- update expectedFailures", reason)
+			case expectFail:
+				t.Skipf("known failure: %s (%v)", reason, runErr)
+			case runErr != nil:
+				t.Fatal(runErr)
+			}
+		})
 	}
 }
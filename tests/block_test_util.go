@@ -0,0 +1,310 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the BlockchainTest fixture runner. Every fixture's blocks are
+// imported, in order, into a chain seeded from the fixture's genesis and
+// pre-state, and the result is checked against the fixture's expectations
+// (per-block header fields, and the final LastBlockHash).
+//
+// Which fork rules (Homestead, the DAO fork, EIP150) apply while importing
+// used to be selected by mutating the package-level params.HomesteadBlock
+// (and friends) before calling RunBlockTest, which meant two fixtures
+// requiring different fork rules could never run in the same test binary,
+// let alone in parallel via t.Parallel. RunBlockTest and RunBlockTestWithReader
+// now take the *core.ChainConfig to run against as an explicit argument
+// instead, so every TestBc*/TestHomesteadBc* entry point builds its own.
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// blockTestDir is the fixture directory TestBlockchain walks; fixture paths
+// are reported relative to it.
+var blockTestDir = filepath.Join(".", "files", "BlockTests")
+
+// BlockSkipTests names fixture test cases (by name, not file) that are
+// known-bad and skipped rather than failed.
+var BlockSkipTests = []string{}
+
+// btAccount is one entry of a fixture's "pre" or "post" state: the account
+// balance/nonce/code/storage a BlockTest seeds the chain's genesis state
+// with, or checks it against afterwards.
+type btAccount struct {
+	Balance *big.Int
+	Code    []byte
+	Nonce   uint64
+	Storage map[common.Hash]common.Hash
+}
+
+// btHeader is the subset of a fixture block's "blockHeader" object
+// RunBlockTest checks an imported block's header against.
+type btHeader struct {
+	Bloom       types.Bloom
+	Coinbase    common.Address
+	Difficulty  *big.Int
+	ExtraData   []byte
+	GasLimit    *big.Int
+	GasUsed     *big.Int
+	Hash        common.Hash
+	MixHash     common.Hash
+	Nonce       types.BlockNonce
+	Number      *big.Int
+	ParentHash  common.Hash
+	ReceiptHash common.Hash
+	StateRoot   common.Hash
+	Timestamp   *big.Int
+	TxHash      common.Hash
+	UncleHash   common.Hash
+}
+
+// btBlock is one entry of a fixture's "blocks" array: the block's RLP
+// encoding, plus the header the imported block must match. A nil
+// BlockHeader marks a block whose RLP must fail to decode.
+type btBlock struct {
+	BlockHeader *btHeader
+	Rlp         string
+}
+
+// btJSON is one named entry of a BlockchainTest fixture file.
+type btJSON struct {
+	Blocks             []btBlock
+	GenesisBlockHeader btHeader
+	Pre                map[common.Address]btAccount
+	Post               map[common.Address]btAccount
+	LastBlockHash      common.Hash
+}
+
+// BlockTest is a single named test case loaded out of a BlockchainTest
+// fixture file.
+type BlockTest struct {
+	Name string
+	json btJSON
+}
+
+// RunBlockTest loads every test case out of the fixture at path and runs
+// each against config, skipping any test named in skipTests.
+func RunBlockTest(config *core.ChainConfig, path string, skipTests []string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return RunBlockTestWithReader(config, file, skipTests)
+}
+
+// RunBlockTestWithReader is RunBlockTest reading the fixture from an
+// already-open reader, for callers (such as the gethrpctest harness) that
+// don't have a bare file path to hand it.
+func RunBlockTestWithReader(config *core.ChainConfig, r io.Reader, skipTests []string) error {
+	tests := make(map[string]btJSON)
+	if err := json.NewDecoder(r).Decode(&tests); err != nil {
+		return fmt.Errorf("test file json parse error: %v", err)
+	}
+	skip := make(map[string]bool, len(skipTests))
+	for _, name := range skipTests {
+		skip[name] = true
+	}
+	for name, test := range tests {
+		if skip[name] {
+			continue
+		}
+		bt := &BlockTest{Name: name, json: test}
+		if err := bt.Run(config); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Run seeds a fresh chain from the test case's genesis and pre-state, then
+// imports its blocks in order against config, failing on the first block
+// whose header disagrees with the fixture or, once every block is in,
+// whose resulting head doesn't match LastBlockHash.
+func (t *BlockTest) Run(config *core.ChainConfig) error {
+	db, genesisHash, err := t.genesis()
+	if err != nil {
+		return fmt.Errorf("failed to write genesis block: %v", err)
+	}
+	if err := core.WriteChainConfig(db, genesisHash, config); err != nil {
+		return fmt.Errorf("failed to write chain config: %v", err)
+	}
+	// Block tests carry their own expected post-import hashes rather than
+	// relying on proof-of-work to have been done honestly, so import runs
+	// against a faking engine that accepts any nonce.
+	chain, err := core.NewBlockChain(db, ethash.NewFaker(), new(event.TypeMux))
+	if err != nil {
+		return fmt.Errorf("failed to create chain: %v", err)
+	}
+	for i, b := range t.json.Blocks {
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(common.FromHex(b.Rlp), block); err != nil {
+			if b.BlockHeader == nil {
+				// This block was expected to fail to decode; that's fine.
+				continue
+			}
+			return fmt.Errorf("block %d: rlp decode failed: %v", i, err)
+		}
+		if b.BlockHeader == nil {
+			return fmt.Errorf("block %d: decoded successfully but fixture expected it to be rejected", i)
+		}
+		if err := validateDAOExtraData(config, block.Header()); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+		if err := applyDAOHardForkIfDue(config, db, chain.CurrentBlock(), block.Header()); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			return fmt.Errorf("block %d: insert failed: %v", i, err)
+		}
+		if err := validateHeader(b.BlockHeader, block.Header()); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+	}
+	if head := chain.CurrentBlock(); head.Hash() != t.json.LastBlockHash {
+		return fmt.Errorf("last block hash mismatch: got %x, want %x", head.Hash(), t.json.LastBlockHash)
+	}
+	return nil
+}
+
+// validateHeader checks the subset of fields a fixture's blockHeader object
+// carries against the header an import actually produced.
+func validateHeader(want *btHeader, got *types.Header) error {
+	if want.Hash != got.Hash() {
+		return fmt.Errorf("hash mismatch: got %x, want %x", got.Hash(), want.Hash)
+	}
+	if want.Coinbase != got.Coinbase {
+		return fmt.Errorf("coinbase mismatch: got %x, want %x", got.Coinbase, want.Coinbase)
+	}
+	if want.Difficulty.Cmp(got.Difficulty) != 0 {
+		return fmt.Errorf("difficulty mismatch: got %v, want %v", got.Difficulty, want.Difficulty)
+	}
+	if want.GasLimit.Cmp(got.GasLimit) != 0 {
+		return fmt.Errorf("gasLimit mismatch: got %v, want %v", got.GasLimit, want.GasLimit)
+	}
+	if want.GasUsed.Cmp(got.GasUsed) != 0 {
+		return fmt.Errorf("gasUsed mismatch: got %v, want %v", got.GasUsed, want.GasUsed)
+	}
+	return nil
+}
+
+// validateDAOExtraData checks that a block within a pro-fork config's DAO
+// fork extra-data range carries the "dao-hard-fork" marker, and that a block
+// within a no-fork config's range doesn't. The balance move itself is applied
+// separately, by applyDAOHardForkIfDue.
+func validateDAOExtraData(config *core.ChainConfig, header *types.Header) error {
+	if config.DAOForkBlock == nil {
+		return nil
+	}
+	limit := new(big.Int).Add(config.DAOForkBlock, core.DAOForkExtraRange)
+	if header.Number.Cmp(config.DAOForkBlock) < 0 || header.Number.Cmp(limit) >= 0 {
+		return nil
+	}
+	marked := bytes.Equal(header.Extra, core.DAOForkBlockExtra)
+	switch {
+	case config.DAOForkSupport && !marked:
+		return fmt.Errorf("block %v: missing dao-hard-fork extra-data marker", header.Number)
+	case !config.DAOForkSupport && marked:
+		return fmt.Errorf("block %v: unexpected dao-hard-fork extra-data marker", header.Number)
+	}
+	return nil
+}
+
+// applyDAOHardForkIfDue moves core.DAODrainList's balances into
+// core.DAORefundContract against parent's state, if header is the pro-fork
+// config's DAOForkBlock. This tree's InsertChain has no opinion on
+// DAOForkSupport, so the harness applies the transition itself rather than
+// leaving it unexercised outside of core/dao_test.go's direct unit test.
+func applyDAOHardForkIfDue(config *core.ChainConfig, db ethdb.Database, parent *types.Block, header *types.Header) error {
+	if !config.DAOForkSupport || config.DAOForkBlock == nil || config.DAOForkBlock.Cmp(header.Number) != 0 {
+		return nil
+	}
+	statedb, err := state.New(parent.Root(), db)
+	if err != nil {
+		return fmt.Errorf("failed to open state for DAO hard fork: %v", err)
+	}
+	core.ApplyDAOHardFork(statedb)
+	statedb.Commit()
+	return nil
+}
+
+// genesis writes the test case's genesis block and pre-state into a fresh,
+// temporary database and returns it along with the genesis hash.
+func (t *BlockTest) genesis() (ethdb.Database, common.Hash, error) {
+	dir, err := ioutil.TempDir("", "blocktest-")
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	db, err := ethdb.NewLDBDatabase(dir, 16, 16)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	genesis, err := genesisJSON(t.json.GenesisBlockHeader, t.json.Pre)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	hash, err := core.WriteGenesisBlock(db, genesis)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	return db, hash, nil
+}
+
+// genesisJSON renders a fixture's GenesisBlockHeader and Pre state into the
+// genesis specification string core.WriteGenesisBlock expects.
+func genesisJSON(header btHeader, pre map[common.Address]btAccount) (string, error) {
+	alloc := make(map[common.Address]map[string]interface{}, len(pre))
+	for addr, acc := range pre {
+		alloc[addr] = map[string]interface{}{
+			"balance": acc.Balance.String(),
+			"nonce":   acc.Nonce,
+			"code":    common.ToHex(acc.Code),
+			"storage": acc.Storage,
+		}
+	}
+	doc := map[string]interface{}{
+		"nonce":      header.Nonce,
+		"timestamp":  header.Timestamp,
+		"parentHash": header.ParentHash,
+		"extraData":  common.ToHex(header.ExtraData),
+		"gasLimit":   header.GasLimit,
+		"difficulty": header.Difficulty,
+		"mixhash":    header.MixHash,
+		"coinbase":   header.Coinbase,
+		"alloc":      alloc,
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,284 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Database is a write-back cache layer sitting between a Trie and the raw
+// key/value store, indexing not-yet-flushed nodes by hash so that callers
+// (state, the light-client ODR path) can keep hundreds of in-memory
+// intermediate roots alive without writing every one of them to disk. Nodes
+// are reference counted as a trie is hashed: once a root's refcount drops
+// to zero via Dereference, every node only reachable through it is dropped
+// from memory too.
+//
+// A Database is safe for concurrent use.
+type Database struct {
+	diskdb ethdb.Database
+
+	mu    sync.RWMutex
+	nodes map[common.Hash]*cachedNode
+
+	oldest common.Hash // oldest tracked node, flushed first by Cap
+	newest common.Hash
+
+	nodesSize int // approximate size, in bytes, of the dirty (not yet flushed) nodes still on the flush list
+}
+
+// cachedNode is a trie node held in memory by a Database, along with enough
+// bookkeeping to know when it's safe to drop: how many parents still
+// reference it, and which children it in turn keeps alive.
+type cachedNode struct {
+	blob     []byte
+	parents  int
+	children map[common.Hash]int
+
+	flushPrev common.Hash
+	flushNext common.Hash
+	flushed   bool // written out by Cap and already unlinked from the flush list
+}
+
+// NewDatabase creates a trie node cache backed by diskdb.
+func NewDatabase(diskdb ethdb.Database) *Database {
+	return &Database{
+		diskdb: diskdb,
+		nodes:  make(map[common.Hash]*cachedNode),
+	}
+}
+
+// DiskDB returns the backing store nodes are eventually flushed to.
+func (db *Database) DiskDB() ethdb.Database {
+	return db.diskdb
+}
+
+// Insert adds the encoding of a trie node to the cache, keyed by hash. It is
+// a no-op if the node is already tracked.
+func (db *Database) Insert(hash common.Hash, blob []byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.insert(hash, blob)
+}
+
+func (db *Database) insert(hash common.Hash, blob []byte) {
+	if _, ok := db.nodes[hash]; ok {
+		return
+	}
+	node := &cachedNode{
+		blob:     common.CopyBytes(blob),
+		children: make(map[common.Hash]int),
+	}
+	db.nodesSize += common.HashLength + len(blob)
+
+	if db.newest == (common.Hash{}) {
+		db.oldest, db.newest = hash, hash
+	} else {
+		db.nodes[db.newest].flushNext, node.flushPrev = hash, db.newest
+		db.newest = hash
+	}
+	db.nodes[hash] = node
+}
+
+// Node returns the encoding of the trie node at hash, from the in-memory
+// cache if present, otherwise from the backing store.
+func (db *Database) Node(hash common.Hash) ([]byte, error) {
+	db.mu.RLock()
+	if node, ok := db.nodes[hash]; ok {
+		db.mu.RUnlock()
+		return node.blob, nil
+	}
+	db.mu.RUnlock()
+	return db.diskdb.Get(hash[:])
+}
+
+// Reference records that parent's encoding refers to child, so that
+// Dereference(parent) also recursively considers dropping child.
+func (db *Database) Reference(child, parent common.Hash) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.reference(child, parent)
+}
+
+func (db *Database) reference(child, parent common.Hash) {
+	node, ok := db.nodes[child]
+	if !ok {
+		return
+	}
+	if pnode, ok := db.nodes[parent]; ok {
+		if _, ok := pnode.children[child]; ok {
+			pnode.children[child]++
+			return
+		}
+		pnode.children[child] = 1
+	}
+	node.parents++
+}
+
+// Dereference drops root's reference and, if that was its last one,
+// recursively does the same for every child only root kept alive, removing
+// them from the cache entirely. It is the in-memory counterpart of Cap:
+// Cap flushes cold nodes to disk, Dereference discards nodes nothing needs
+// any more.
+func (db *Database) Dereference(root common.Hash) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.dereference(root)
+}
+
+func (db *Database) dereference(hash common.Hash) {
+	node, ok := db.nodes[hash]
+	if !ok {
+		return
+	}
+	if node.parents > 0 {
+		node.parents--
+	}
+	if node.parents > 0 {
+		return
+	}
+	for child := range node.children {
+		db.dereference(child)
+	}
+	if !node.flushed {
+		db.removeFlushListEntry(hash)
+		db.nodesSize -= common.HashLength + len(node.blob)
+	}
+	delete(db.nodes, hash)
+}
+
+// removeFlushListEntry unlinks hash from the flush-list doubly linked list
+// maintained across Insert calls.
+func (db *Database) removeFlushListEntry(hash common.Hash) {
+	node := db.nodes[hash]
+	switch {
+	case hash == db.oldest && hash == db.newest:
+		db.oldest, db.newest = common.Hash{}, common.Hash{}
+	case hash == db.oldest:
+		db.oldest = node.flushNext
+		db.nodes[db.oldest].flushPrev = common.Hash{}
+	case hash == db.newest:
+		db.newest = node.flushPrev
+		db.nodes[db.newest].flushNext = common.Hash{}
+	default:
+		db.nodes[node.flushPrev].flushNext = node.flushNext
+		db.nodes[node.flushNext].flushPrev = node.flushPrev
+	}
+}
+
+// Cap flushes the oldest cached nodes to the backing store until the
+// cache's dirty (not yet flushed) byte count drops to (or below) limit,
+// then unlinks every node it just flushed from the flush list: Node still
+// serves them out of db.nodes, now from disk as well as memory, but they no
+// longer count towards nodesSize or get walked (and re-flushed) by a future
+// Cap call. A later Dereference is what actually drops them from db.nodes.
+func (db *Database) Cap(limit int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	batch := db.diskdb.NewBatch()
+	size, hash := db.nodesSize, db.oldest
+	for size > limit && hash != (common.Hash{}) {
+		node := db.nodes[hash]
+		if err := batch.Put(hash[:], node.blob); err != nil {
+			return err
+		}
+		size -= common.HashLength + len(node.blob)
+		hash = node.flushNext
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	// Every node between the old db.oldest and hash was just flushed above;
+	// advance db.oldest past them and subtract their bytes from nodesSize.
+	for db.oldest != hash {
+		node := db.nodes[db.oldest]
+		node.flushed = true
+		db.nodesSize -= common.HashLength + len(node.blob)
+		if node.flushNext == (common.Hash{}) {
+			db.oldest, db.newest = common.Hash{}, common.Hash{}
+		} else {
+			db.oldest = node.flushNext
+			db.nodes[db.oldest].flushPrev = common.Hash{}
+		}
+	}
+	return nil
+}
+
+// Commit flushes the entire subtree rooted at hash to the backing store in
+// one batch, regardless of the in-memory size limit Cap enforces; it is
+// what a full block commit (rather than just keeping the root pinned in
+// memory) ultimately calls.
+func (db *Database) Commit(hash common.Hash, report bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	batch := db.diskdb.NewBatch()
+	if err := db.commit(hash, batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if report {
+		fmt.Printf("trie: committed nodes rooted at %x (dirty cache size %d bytes)\n", hash, db.nodesSize)
+	}
+	return nil
+}
+
+func (db *Database) commit(hash common.Hash, batch ethdb.Batch) error {
+	node, ok := db.nodes[hash]
+	if !ok {
+		// Not cached (either never was, or already flushed by Cap): assume
+		// it's already on disk.
+		return nil
+	}
+	for child := range node.children {
+		if err := db.commit(child, batch); err != nil {
+			return err
+		}
+	}
+	return batch.Put(hash[:], node.blob)
+}
+
+// Size returns the approximate size, in bytes, of the dirty nodes Cap has
+// not yet flushed. Nodes that Cap flushed but that are still held in memory
+// are not counted.
+func (db *Database) Size() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.nodesSize
+}
+
+// Get implements Database, so a *Database can be passed anywhere a Trie's
+// backing store is expected: it serves straight out of the cache before
+// ever touching disk.
+func (db *Database) Get(key []byte) ([]byte, error) {
+	return db.Node(common.BytesToHash(key))
+}
+
+// Put implements DatabaseWriter by journaling the node in memory rather
+// than writing it straight through to disk; callers that need it on disk
+// call Commit or Cap.
+func (db *Database) Put(key, value []byte) error {
+	db.Insert(common.BytesToHash(key), value)
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+func newTestDatabase(t *testing.T) (*Database, func()) {
+	dir, err := ioutil.TempDir("", "trie-database-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskdb, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return NewDatabase(diskdb), func() {
+		diskdb.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestCapShrinksSize checks that Cap actually bounds the cache's reported
+// size: before this fix, Cap flushed the oldest nodes to disk but never
+// advanced db.oldest past them, so a repeated Cap call re-summed the exact
+// same dirty bytes it had just "flushed" and Size() never dropped.
+func TestCapShrinksSize(t *testing.T) {
+	db, cleanup := newTestDatabase(t)
+	defer cleanup()
+
+	// Keys start at 1: the zero hash marks an empty flush list.
+	for i := 0; i < 100; i++ {
+		blob := make([]byte, 256)
+		blob[0] = byte(i)
+		db.Insert(common.BytesToHash([]byte{byte(i + 1)}), blob)
+	}
+	before := db.Size()
+	if before == 0 {
+		t.Fatal("expected a nonzero cache size after inserting nodes")
+	}
+	if err := db.Cap(before / 2); err != nil {
+		t.Fatal(err)
+	}
+	if after := db.Size(); after >= before {
+		t.Fatalf("Size() did not shrink: before=%d after=%d", before, after)
+	}
+
+	// Capping to zero must drain the flush list entirely.
+	if err := db.Cap(0); err != nil {
+		t.Fatal(err)
+	}
+	if after := db.Size(); after != 0 {
+		t.Fatalf("Size() after Cap(0) = %d, want 0", after)
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Iterator is a key/value trie iterator that traverses a Trie in pre-order.
+type Iterator struct {
+	nodeIt NodeIterator
+
+	Key   []byte // Key of the current key/value pair, or nil if the iterator is exhausted
+	Value []byte // Value of the current key/value pair
+}
+
+// NewIterator creates a new key/value iterator from a node iterator.
+func NewIterator(trie *Trie) *Iterator {
+	return &Iterator{nodeIt: NewNodeIterator(trie)}
+}
+
+// Next moves the iterator forward to the next key/value pair, skipping over
+// every internal node the underlying NodeIterator visits along the way.
+func (it *Iterator) Next() bool {
+	for it.nodeIt.Next(true) {
+		if it.nodeIt.Leaf() {
+			it.Key = it.nodeIt.LeafKey()
+			it.Value = it.nodeIt.LeafBlob()
+			return true
+		}
+	}
+	it.Key = nil
+	it.Value = nil
+	return false
+}
+
+// errIteratorEnd is stored in a nodeIterator's err field once it runs out of
+// nodes to visit; unlike any other error it's never returned from Error, so
+// callers only ever see a real error or nil.
+var errIteratorEnd = errors.New("end of iteration")
+
+// NodeIterator is an iterator that exposes every node of a trie, not just
+// its leaves: branch and extension nodes come back too, each identified by
+// its hash, parent hash and hex-encoded path. Diffing or pruning code can
+// use Next(false) to skip a subtrie entirely once it recognizes its hash,
+// instead of re-reading nodes it already knows about.
+type NodeIterator interface {
+	// Next moves the iterator to the next node. If descend is false, any
+	// children of the node the iterator is currently positioned at are
+	// skipped.
+	Next(descend bool) bool
+
+	// Error returns the error, if any, that halted iteration early. It is
+	// nil once the iterator has simply run out of nodes.
+	Error() error
+
+	// Hash returns the hash of the current node, or the zero hash if the
+	// node was small enough to be stored inline in its parent.
+	Hash() common.Hash
+
+	// Parent returns the hash of the closest ancestor of the current node
+	// that was itself stored as its own, separately hashed node, or the
+	// zero hash at the root.
+	Parent() common.Hash
+
+	// Path returns the hex-encoded (one nibble per byte) path from the
+	// root down to the current node.
+	Path() []byte
+
+	// Leaf returns true iff the current node is a trie value.
+	Leaf() bool
+
+	// LeafKey returns the key of the leaf the iterator is positioned at.
+	// It panics if the iterator is not positioned at a leaf.
+	LeafKey() []byte
+
+	// LeafBlob returns the content of the leaf the iterator is positioned
+	// at. It panics if the iterator is not positioned at a leaf.
+	LeafBlob() []byte
+}
+
+// nodeIteratorState is one level of a nodeIterator's explicit descent
+// stack: the (already resolved) node at that level, the hash it was
+// resolved from (zero if it was inlined), its parent's hash, which child
+// has been visited so far, and how long the shared path buffer was before
+// this level was pushed, so popping can cheaply restore it.
+type nodeIteratorState struct {
+	hash    common.Hash
+	node    node
+	parent  common.Hash
+	index   int
+	pathLen int
+}
+
+// nodeIterator is the pre-order NodeIterator backing NewNodeIterator.
+type nodeIterator struct {
+	trie  *Trie
+	stack []*nodeIteratorState
+	path  []byte
+	err   error
+}
+
+// NewNodeIterator returns a NodeIterator positioned before trie's root; the
+// first call to Next moves it onto the root itself.
+func NewNodeIterator(trie *Trie) NodeIterator {
+	if trie.Hash() == emptyRoot {
+		return &nodeIterator{err: errIteratorEnd}
+	}
+	return &nodeIterator{trie: trie}
+}
+
+func (it *nodeIterator) Hash() common.Hash {
+	if len(it.stack) == 0 {
+		return common.Hash{}
+	}
+	return it.stack[len(it.stack)-1].hash
+}
+
+func (it *nodeIterator) Parent() common.Hash {
+	if len(it.stack) == 0 {
+		return common.Hash{}
+	}
+	return it.stack[len(it.stack)-1].parent
+}
+
+func (it *nodeIterator) Leaf() bool {
+	if len(it.stack) == 0 {
+		return false
+	}
+	_, ok := it.stack[len(it.stack)-1].node.(valueNode)
+	return ok
+}
+
+func (it *nodeIterator) LeafKey() []byte {
+	if len(it.stack) > 0 {
+		if _, ok := it.stack[len(it.stack)-1].node.(valueNode); ok {
+			return hexToKeybytes(it.path)
+		}
+	}
+	panic("LeafKey called on non-leaf node")
+}
+
+func (it *nodeIterator) LeafBlob() []byte {
+	if len(it.stack) > 0 {
+		if n, ok := it.stack[len(it.stack)-1].node.(valueNode); ok {
+			return []byte(n)
+		}
+	}
+	panic("LeafBlob called on non-leaf node")
+}
+
+func (it *nodeIterator) Path() []byte {
+	return it.path
+}
+
+func (it *nodeIterator) Error() error {
+	if it.err == errIteratorEnd {
+		return nil
+	}
+	return it.err
+}
+
+func (it *nodeIterator) Next(descend bool) bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.stack) == 0 {
+		resolved, hash, err := it.resolve(it.trie.root)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if resolved == nil {
+			it.err = errIteratorEnd
+			return false
+		}
+		it.stack = append(it.stack, &nodeIteratorState{node: resolved, hash: hash})
+		return true
+	}
+	if !descend {
+		it.pop()
+	}
+	for len(it.stack) > 0 {
+		parent := it.stack[len(it.stack)-1]
+		child, nibble, ok := nextChild(parent)
+		if !ok {
+			it.pop()
+			continue
+		}
+		resolved, hash, err := it.resolve(child)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.path = append(it.path[:parent.pathLen], nibble...)
+		it.stack = append(it.stack, &nodeIteratorState{
+			node:    resolved,
+			hash:    hash,
+			parent:  parent.hash,
+			pathLen: len(it.path),
+		})
+		return true
+	}
+	it.err = errIteratorEnd
+	return false
+}
+
+// pop discards the current top-of-stack frame, rewinding the path buffer
+// to what it was before that frame was pushed.
+func (it *nodeIterator) pop() {
+	n := len(it.stack) - 1
+	it.path = it.path[:it.stack[n].pathLen]
+	it.stack = it.stack[:n]
+}
+
+// nextChild advances parent.index to the next not-yet-visited child of
+// parent.node, returning it along with the nibble(s) to append to the
+// path to reach it. ok is false once every child has been visited.
+func nextChild(parent *nodeIteratorState) (n node, nibble []byte, ok bool) {
+	switch pn := parent.node.(type) {
+	case fullNode:
+		for i := parent.index + 1; i < 17; i++ {
+			parent.index = i
+			if pn[i] == nil {
+				continue
+			}
+			if i == 16 {
+				// The 17th slot holds this branch's own value, not a
+				// further-nested child; it sits at the branch's path.
+				return pn[i], nil, true
+			}
+			return pn[i], []byte{byte(i)}, true
+		}
+		return nil, nil, false
+	case shortNode:
+		if parent.index < 0 {
+			parent.index = 0
+			return pn.Val, pn.Key, true
+		}
+		return nil, nil, false
+	default:
+		// hashNode (never stays on the stack, see resolve) and valueNode
+		// (a leaf) have no children.
+		return nil, nil, false
+	}
+}
+
+// resolve loads the node n refers to if it's a hashNode, returning the hash
+// it was resolved from so the caller can record it on the new stack frame;
+// any other node type is returned unchanged with a zero hash.
+func (it *nodeIterator) resolve(n node) (node, common.Hash, error) {
+	if hash, ok := n.(hashNode); ok {
+		resolved, err := it.trie.resolveHash(hash, nil, it.path)
+		if err != nil {
+			return nil, common.Hash{}, err
+		}
+		return resolved, common.BytesToHash(hash), nil
+	}
+	return n, common.Hash{}, nil
+}
+
+// hexToKeybytes turns a hex-encoded (one nibble per byte) path, optionally
+// terminator-suffixed as compactHexDecode produces, back into the raw key
+// bytes it came from.
+func hexToKeybytes(hex []byte) []byte {
+	if hasTerm(hex) {
+		hex = hex[:len(hex)-1]
+	}
+	if len(hex)&1 != 0 {
+		panic("can't convert hex key of odd length")
+	}
+	key := make([]byte, len(hex)/2)
+	for bi, ni := 0, 0; ni < len(hex); bi, ni = bi+1, ni+2 {
+		key[bi] = hex[ni]<<4 | hex[ni+1]
+	}
+	return key
+}
+
+// hasTerm reports whether a hex-encoded path ends in the 0x10 terminator
+// nibble compactHexDecode appends to mark where a value is stored.
+func hasTerm(s []byte) bool {
+	return len(s) > 0 && s[len(s)-1] == 16
+}
+
+// NewDifferenceIterator constructs a NodeIterator over every node reachable
+// from b that isn't also reachable from a, identified by matching (path,
+// hash) pairs: two subtries with the same hash at the same path are
+// identical, so the difference walk never has to descend into either.
+// *count is incremented once per node difference advances through, so a
+// caller can tell how much work the walk actually did.
+func NewDifferenceIterator(a, b NodeIterator) (NodeIterator, *int) {
+	a.Next(true)
+	it := &differenceIterator{a: a, b: b}
+	return it, &it.count
+}
+
+type differenceIterator struct {
+	a, b  NodeIterator
+	eof   bool
+	count int
+}
+
+func (it *differenceIterator) Hash() common.Hash   { return it.b.Hash() }
+func (it *differenceIterator) Parent() common.Hash { return it.b.Parent() }
+func (it *differenceIterator) Leaf() bool          { return it.b.Leaf() }
+func (it *differenceIterator) LeafKey() []byte     { return it.b.LeafKey() }
+func (it *differenceIterator) LeafBlob() []byte    { return it.b.LeafBlob() }
+func (it *differenceIterator) Path() []byte        { return it.b.Path() }
+
+func (it *differenceIterator) Error() error {
+	if err := it.a.Error(); err != nil {
+		return err
+	}
+	return it.b.Error()
+}
+
+func (it *differenceIterator) Next(descend bool) bool {
+	if it.eof {
+		return false
+	}
+	for {
+		if !it.b.Next(descend) {
+			return false
+		}
+		it.count++
+		switch bytes.Compare(it.a.Path(), it.b.Path()) {
+		case -1:
+			// a is behind b along the walk: catch it up before comparing.
+			if !it.a.Next(true) {
+				it.eof = true
+			}
+			descend = true
+			continue
+		case 0:
+			if (it.a.Hash() != common.Hash{}) && it.a.Hash() == it.b.Hash() {
+				// Same subtrie on both sides: it's not part of the
+				// difference, and there's no point descending into it.
+				descend = false
+				continue
+			}
+			return true
+		default:
+			// b is ahead of a at a path a doesn't have: always new.
+			descend = true
+			return true
+		}
+	}
+}
+
+// NewUnionIterator constructs a NodeIterator over the union of iters,
+// visiting each distinct (path, hash) pair exactly once regardless of how
+// many of iters reach it. *count is incremented once per node visited.
+func NewUnionIterator(iters []NodeIterator) (NodeIterator, *int) {
+	h := make(priorityQueue, 0, len(iters))
+	for _, it := range iters {
+		if it.Next(true) {
+			h = append(h, it)
+		}
+	}
+	heap.Init(&h)
+	ui := &unionIterator{items: &h}
+	return ui, &ui.count
+}
+
+type unionIterator struct {
+	items *priorityQueue
+	count int
+}
+
+func (it *unionIterator) Hash() common.Hash   { return (*it.items)[0].Hash() }
+func (it *unionIterator) Parent() common.Hash { return (*it.items)[0].Parent() }
+func (it *unionIterator) Leaf() bool          { return (*it.items)[0].Leaf() }
+func (it *unionIterator) LeafKey() []byte     { return (*it.items)[0].LeafKey() }
+func (it *unionIterator) LeafBlob() []byte    { return (*it.items)[0].LeafBlob() }
+func (it *unionIterator) Path() []byte        { return (*it.items)[0].Path() }
+
+func (it *unionIterator) Error() error {
+	for _, sub := range *it.items {
+		if err := sub.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *unionIterator) Next(descend bool) bool {
+	if len(*it.items) == 0 {
+		return false
+	}
+	least := heap.Pop(it.items).(NodeIterator)
+	it.count++
+
+	// Every other iterator currently sitting on the exact node we just
+	// consumed is a duplicate: advance it too (without double-counting or
+	// returning it separately) instead of visiting it again later.
+	for len(*it.items) > 0 && bytes.Equal((*it.items)[0].Path(), least.Path()) && (*it.items)[0].Hash() == least.Hash() {
+		dup := heap.Pop(it.items).(NodeIterator)
+		if dup.Next(descend) {
+			heap.Push(it.items, dup)
+		}
+	}
+	if least.Next(descend) {
+		heap.Push(it.items, least)
+	}
+	return len(*it.items) > 0
+}
+
+// priorityQueue is a container/heap of NodeIterators ordered by path, so
+// unionIterator can always advance whichever one is currently furthest
+// behind in the walk.
+type priorityQueue []NodeIterator
+
+func (q priorityQueue) Len() int            { return len(q) }
+func (q priorityQueue) Less(i, j int) bool  { return bytes.Compare(q[i].Path(), q[j].Path()) < 0 }
+func (q priorityQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *priorityQueue) Push(x interface{}) { *q = append(*q, x.(NodeIterator)) }
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
@@ -0,0 +1,211 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SyncResult is the answer to one of Sync's Missing requests: the raw,
+// RLP-encoded node data a peer returned for Hash.
+type SyncResult struct {
+	Hash common.Hash
+	Data []byte
+}
+
+// request is the bookkeeping Sync keeps for a single node it's waiting on:
+// which requests, if any, can't be committed until this one is (because
+// they reference it as a child), and how many of its own children are
+// still outstanding.
+type request struct {
+	hash    common.Hash
+	depth   int // distance from the sync root; used to prioritize shallow nodes
+	data    []byte
+	parents []*request
+	deps    int
+}
+
+// syncPriorityQueue is a container/heap of not-yet-requested nodes, ordered
+// shallowest first: a node near the root is far more likely to unblock a
+// large part of the remaining trie than one buried deep in a subtrie that
+// hasn't even been reached yet.
+type syncPriorityQueue []*request
+
+func (q syncPriorityQueue) Len() int           { return len(q) }
+func (q syncPriorityQueue) Less(i, j int) bool { return q[i].depth < q[j].depth }
+func (q syncPriorityQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *syncPriorityQueue) Push(x interface{}) { *q = append(*q, x.(*request)) }
+
+func (q *syncPriorityQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
+
+// Sync schedules retrieval of the nodes of a trie this node doesn't have
+// locally yet, rooted at a hash obtained some other way (e.g. from a
+// block header) -- the core piece fast/snap sync builds on to pull down
+// an entire recent state trie from peers instead of deriving it by
+// replaying every block that built it.
+//
+// A Sync is not safe for concurrent use.
+type Sync struct {
+	database Database                // local store consulted before scheduling a node as missing
+	membatch map[common.Hash][]byte  // retrieved, fully-resolved nodes not yet written to database
+	requests map[common.Hash]*request // every hash currently scheduled, queued or in flight
+	queue    syncPriorityQueue        // subset of requests not yet handed out by Missing
+}
+
+// NewSync creates a Sync that will retrieve the trie rooted at root,
+// consulting database first in case root (or any of its descendants) is
+// already available locally.
+func NewSync(root common.Hash, database Database) *Sync {
+	ts := &Sync{
+		database: database,
+		membatch: make(map[common.Hash][]byte),
+		requests: make(map[common.Hash]*request),
+	}
+	ts.schedule(root, 0, nil)
+	return ts
+}
+
+// schedule registers hash as needed by parent (the trie root, if parent is
+// nil), unless it's the empty root, already retrieved, already scheduled
+// (in which case parent is just added to the existing request's waiters),
+// or already present in the local database.
+func (s *Sync) schedule(hash common.Hash, depth int, parent *request) {
+	if hash == emptyRoot {
+		return
+	}
+	if _, ok := s.membatch[hash]; ok {
+		return
+	}
+	if req, ok := s.requests[hash]; ok {
+		if parent != nil {
+			req.parents = append(req.parents, parent)
+			parent.deps++
+		}
+		return
+	}
+	if blob, _ := s.database.Get(hash[:]); len(blob) > 0 {
+		return
+	}
+	req := &request{hash: hash, depth: depth}
+	if parent != nil {
+		req.parents = append(req.parents, parent)
+		parent.deps++
+	}
+	s.requests[hash] = req
+	heap.Push(&s.queue, req)
+}
+
+// Missing returns up to max currently-needed node hashes (all of them, if
+// max is zero), shallowest first. Each one returned is removed from the
+// queue but stays tracked in s.requests until Process resolves it, so a
+// second call to Missing never hands out the same in-flight hash twice.
+func (s *Sync) Missing(max int) []common.Hash {
+	var hashes []common.Hash
+	for len(s.queue) > 0 && (max == 0 || len(hashes) < max) {
+		hashes = append(hashes, heap.Pop(&s.queue).(*request).hash)
+	}
+	return hashes
+}
+
+// Process verifies that each result actually hashes to the key it was
+// requested under, decodes it, schedules retrieval of whatever hashNode
+// children it references, and commits it (and, transitively, any parent
+// left waiting on only this child) into the in-memory batch once nothing
+// more is blocking it. It returns how many results verified and were
+// processed; a non-nil error means that count stops short of len(results).
+func (s *Sync) Process(results []SyncResult) (int, error) {
+	committed := 0
+	for _, result := range results {
+		req, ok := s.requests[result.Hash]
+		if !ok {
+			return committed, fmt.Errorf("trie sync: not requested: %x", result.Hash)
+		}
+		if crypto.Keccak256Hash(result.Data) != result.Hash {
+			return committed, fmt.Errorf("trie sync: hash mismatch for %x", result.Hash)
+		}
+		req.data = result.Data
+		n := mustDecodeNode(hashNode(result.Hash[:]), result.Data)
+		s.scheduleChildren(req, n)
+		committed++
+
+		if req.deps == 0 {
+			s.commit(req)
+		}
+	}
+	return committed, nil
+}
+
+// scheduleChildren schedules retrieval of every hashNode n references,
+// recording req as their parent.
+func (s *Sync) scheduleChildren(req *request, n node) {
+	switch n := n.(type) {
+	case shortNode:
+		if child, ok := n.Val.(hashNode); ok {
+			s.schedule(common.BytesToHash(child), req.depth+1, req)
+		}
+	case fullNode:
+		for i := 0; i < 16; i++ {
+			if child, ok := n[i].(hashNode); ok {
+				s.schedule(common.BytesToHash(child), req.depth+1, req)
+			}
+		}
+	}
+}
+
+// commit moves req's retrieved data into the in-memory batch and, if that
+// was the last dependency any of its parents were waiting on, recursively
+// commits them too.
+func (s *Sync) commit(req *request) {
+	s.membatch[req.hash] = req.data
+	delete(s.requests, req.hash)
+	for _, parent := range req.parents {
+		parent.deps--
+		if parent.deps == 0 {
+			s.commit(parent)
+		}
+	}
+}
+
+// Pending returns the number of nodes Sync currently tracks: queued or
+// in-flight ones, plus retrieved ones still waiting on their children.
+// Children that have not been discovered yet are not counted.
+func (s *Sync) Pending() int {
+	return len(s.requests)
+}
+
+// Commit writes every node accumulated in the in-memory batch so far to
+// db and clears the batch.
+func (s *Sync) Commit(db DatabaseWriter) error {
+	for hash, data := range s.membatch {
+		if err := db.Put(hash[:], data); err != nil {
+			return err
+		}
+	}
+	s.membatch = make(map[common.Hash][]byte)
+	return nil
+}
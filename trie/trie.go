@@ -21,6 +21,8 @@ import (
 	"bytes"
 	"fmt"
 	"hash"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -30,11 +32,20 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
-const defaultCacheCapacity = 800
+// defaultCacheLimit is how many CommitTo generations old a decoded node
+// may be before a trie evicts it from its cache.
+const defaultCacheLimit = 120
 
 var (
-	// The global cache stores decoded trie nodes by hash as they get loaded.
-	globalCache = newARC(defaultCacheCapacity)
+	// cacheLimit is the package-wide default consulted by new tries;
+	// tune it with SetCacheLimit.
+	cacheLimit uint16 = defaultCacheLimit
+
+	// cacheMisses and cacheUnloads are running totals across every trie,
+	// exposed through CacheMisses and CacheUnloads so callers can judge
+	// whether cacheLimit is set too low.
+	cacheMisses  uint64
+	cacheUnloads uint64
 
 	// This is the known root hash of an empty trie.
 	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
@@ -43,9 +54,24 @@ var (
 	emptyState = crypto.Keccak256Hash(nil)
 )
 
-// ClearGlobalCache clears the global trie cache
-func ClearGlobalCache() {
-	globalCache.Clear()
+// SetCacheLimit sets the number of CommitTo generations a decoded trie
+// node may age through, across every trie, before it's evicted from its
+// trie's cache. It's not safe to call concurrently with trie operations.
+func SetCacheLimit(l uint16) {
+	cacheLimit = l
+}
+
+// CacheMisses returns the number of times, across every trie, that
+// resolving a node hash found nothing cached and had to decode it from
+// the database.
+func CacheMisses() uint64 {
+	return atomic.LoadUint64(&cacheMisses)
+}
+
+// CacheUnloads returns the number of cached nodes, across every trie,
+// evicted for having aged past the current cache limit.
+func CacheUnloads() uint64 {
+	return atomic.LoadUint64(&cacheUnloads)
 }
 
 // Database must be implemented by backing stores for the trie.
@@ -72,7 +98,20 @@ type Trie struct {
 	root         node
 	db           Database
 	originalRoot common.Hash
-	*hasher
+
+	// cachegen is the current generation, bumped on every CommitTo; cache
+	// entries older than cacheLimit generations are evicted there, so a
+	// long-lived trie's memory use stays bounded without needing an
+	// explicit Reset between commits.
+	cachegen uint16
+	cache    map[common.Hash]cacheEntry
+}
+
+// cacheEntry is a single decoded node held in a Trie's cache, tagged with
+// the generation it was decoded in.
+type cacheEntry struct {
+	node node
+	gen  uint16
 }
 
 // New creates a trie with an existing root node from db.
@@ -146,6 +185,112 @@ func (t *Trie) TryGet(key []byte) ([]byte, error) {
 	return tn.(valueNode), nil
 }
 
+// Prove writes the Merkle proof for key into proofDb: every RLP-encoded
+// trie node visited on the way from the root down to key's value (or to
+// wherever the trie establishes its absence), keyed by its own keccak
+// hash. It walks the trie exactly like TryGet, except that each hashNode
+// it resolves is written to proofDb instead of being cached for later
+// lookups.
+//
+// fromLevel skips writing the first fromLevel resolved nodes, for a
+// caller that already holds a shorter proof along the same path (e.g. a
+// previous, shallower eth_getProof call) and only needs the remainder.
+//
+// If the database is missing a node along the way, Prove returns an
+// error; proofDb is left holding whatever prefix of the proof it managed
+// to write.
+func (t *Trie) Prove(key []byte, fromLevel uint, proofDb DatabaseWriter) error {
+	key = compactHexDecode(key)
+	pos := 0
+	tn := t.root
+	for pos < len(key) {
+		switch n := tn.(type) {
+		case shortNode:
+			if len(key)-pos < len(n.Key) || !bytes.Equal(n.Key, key[pos:pos+len(n.Key)]) {
+				// The trie doesn't contain key; the proof collected so far
+				// establishes its absence.
+				return nil
+			}
+			tn = n.Val
+			pos += len(n.Key)
+		case fullNode:
+			tn = n[key[pos]]
+			pos++
+		case nil:
+			return nil
+		case hashNode:
+			enc, err := t.db.Get(n)
+			if err != nil || enc == nil {
+				return fmt.Errorf("trie: missing node %x along proof path for %x", []byte(n), key)
+			}
+			if fromLevel > 0 {
+				fromLevel--
+			} else if err := proofDb.Put(crypto.Keccak256(enc), enc); err != nil {
+				return err
+			}
+			tn = mustDecodeNode(n, enc)
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", tn, tn))
+		}
+	}
+	return nil
+}
+
+// VerifyProof checks that proofDb holds a valid Merkle proof for key
+// against rootHash, as written by Prove, reconstructing the path node by
+// node straight out of proofDb instead of requiring the full trie. Each
+// node is hash-checked against the hash referenced by its parent before
+// being decoded. It returns the proven value, or a nil value with a nil
+// error if the proof proves key's absence from the trie.
+func VerifyProof(rootHash common.Hash, key []byte, proofDb Database) (value []byte, err error) {
+	key = compactHexDecode(key)
+	wantHash := rootHash.Bytes()
+	for i := 0; ; i++ {
+		buf, _ := proofDb.Get(wantHash)
+		if buf == nil {
+			return nil, fmt.Errorf("key index %d: proof node %x not found in proof db", i, wantHash)
+		}
+		if !bytes.Equal(crypto.Keccak256(buf), wantHash) {
+			return nil, fmt.Errorf("key index %d: proof node hash mismatch", i)
+		}
+		n := mustDecodeNode(hashNode(wantHash), buf)
+		keyrest, cld := get(n, key)
+		switch cld := cld.(type) {
+		case nil:
+			// The trie doesn't contain the key.
+			return nil, nil
+		case hashNode:
+			key = keyrest
+			wantHash = cld
+		case valueNode:
+			return cld, nil
+		}
+	}
+}
+
+// get looks up key within the single already-decoded node n, stopping as
+// soon as it either runs out of embedded nodes (returning the hashNode it
+// needs next) or resolves to a value (or the key's absence).
+func get(n node, key []byte) ([]byte, node) {
+	for {
+		switch nd := n.(type) {
+		case shortNode:
+			if len(key) < len(nd.Key) || !bytes.Equal(nd.Key, key[:len(nd.Key)]) {
+				return nil, nil
+			}
+			n, key = nd.Val, key[len(nd.Key):]
+		case fullNode:
+			n, key = nd[key[0]], key[1:]
+		case hashNode:
+			return key, nd
+		case nil:
+			return key, nil
+		case valueNode:
+			return nil, nd
+		}
+	}
+}
+
 // Update associates key with value in the trie. Subsequent calls to
 // Get will return value. If value has length zero, any existing value
 // is deleted from the trie and calls to Get will return nil.
@@ -386,14 +531,16 @@ func (t *Trie) resolve(n node, prefix, suffix []byte) (node, error) {
 }
 
 func (t *Trie) resolveHash(n hashNode, prefix, suffix []byte) (node, error) {
-	if v, ok := globalCache.Get(n); ok {
-		return v, nil
+	hash := common.BytesToHash(n)
+	if entry, ok := t.cache[hash]; ok {
+		return entry.node, nil
 	}
+	atomic.AddUint64(&cacheMisses, 1)
 	enc, err := t.db.Get(n)
 	if err != nil || enc == nil {
 		return nil, &MissingNodeError{
 			RootHash:  t.originalRoot,
-			NodeHash:  common.BytesToHash(n),
+			NodeHash:  hash,
 			Key:       compactHexEncode(append(prefix, suffix...)),
 			PrefixLen: len(prefix),
 			SuffixLen: len(suffix),
@@ -401,27 +548,61 @@ func (t *Trie) resolveHash(n hashNode, prefix, suffix []byte) (node, error) {
 	}
 	dec := mustDecodeNode(n, enc)
 	if dec != nil {
-		globalCache.Put(n, dec)
+		t.cacheNode(hash, dec)
 	}
 	return dec, nil
 }
 
+// cacheNode adds a freshly-decoded node to the trie's cache, tagged with
+// the trie's current generation.
+func (t *Trie) cacheNode(hash common.Hash, n node) {
+	if t.cache == nil {
+		t.cache = make(map[common.Hash]cacheEntry)
+	}
+	t.cache[hash] = cacheEntry{node: n, gen: t.cachegen}
+}
+
+// Reset drops every node the trie has cached, so the next access to each
+// one re-decodes it from the database. Use it to bound memory use on a
+// long-lived trie that CommitTo won't otherwise get a chance to age nodes
+// out of, e.g. one that's never written back to disk.
+func (t *Trie) Reset() {
+	t.cache = nil
+}
+
+// evictAged drops every cached node older than cacheLimit generations.
+// It's called after every CommitTo, since that's the only event that
+// advances a trie's generation counter.
+func (t *Trie) evictAged() {
+	for hash, entry := range t.cache {
+		if t.cachegen-entry.gen > cacheLimit {
+			delete(t.cache, hash)
+			atomic.AddUint64(&cacheUnloads, 1)
+		}
+	}
+}
+
 // Root returns the root hash of the trie.
 // Deprecated: use Hash instead.
 func (t *Trie) Root() []byte { return t.Hash().Bytes() }
 
-// Hash returns the root hash of the trie. It does not write to the
-// database and can be used even if the trie doesn't have one.
+// Hash returns the root hash of the trie. It neither writes to the
+// database nor mutates the trie itself, so it can be called on a trie
+// with no database, or interleaved with further reads and writes.
 func (t *Trie) Hash() common.Hash {
-	root, _ := t.hashRoot(nil)
-	return common.BytesToHash(root.(hashNode))
+	if t.root == nil {
+		return emptyRoot
+	}
+	hashed, _ := newHasher().hash(t.root, true)
+	return common.BytesToHash(hashed.(hashNode))
 }
 
 // Commit writes all nodes to the trie's database.
 // Nodes are stored with their sha3 hash as the key.
 //
-// Committing flushes nodes from memory.
-// Subsequent Get calls will load nodes from the database.
+// Committing collapses every node bigger than a hash into a hashNode in
+// the live tree, so subsequent Get calls load them back from the database
+// instead of re-walking the already-persisted subtrie.
 func (t *Trie) Commit() (root common.Hash, err error) {
 	if t.db == nil {
 		panic("Commit called on trie with nil database")
@@ -432,29 +613,28 @@ func (t *Trie) Commit() (root common.Hash, err error) {
 // CommitTo writes all nodes to the given database.
 // Nodes are stored with their sha3 hash as the key.
 //
-// Committing flushes nodes from memory. Subsequent Get calls will
-// load nodes from the trie's database. Calling code must ensure that
-// the changes made to db are written back to the trie's attached
-// database before using the trie.
+// Committing collapses every node bigger than a hash into a hashNode in
+// the live tree. Calling code must ensure that the changes made to db are
+// written back to the trie's attached database before using the trie.
 func (t *Trie) CommitTo(db DatabaseWriter) (root common.Hash, err error) {
-	n, err := t.hashRoot(db)
-	if err != nil {
-		return (common.Hash{}), err
-	}
-	t.root = n
-	return common.BytesToHash(n.(hashNode)), nil
-}
-
-func (t *Trie) hashRoot(db DatabaseWriter) (node, error) {
 	if t.root == nil {
-		return hashNode(emptyRoot.Bytes()), nil
+		return emptyRoot, nil
 	}
-	if t.hasher == nil {
-		t.hasher = newHasher()
+	collapsed, err := newCommitter(db).Commit(t.root)
+	if err != nil {
+		return common.Hash{}, err
 	}
-	return t.hasher.hash(t.root, db, true)
+	t.root = collapsed
+	t.cachegen++
+	t.evictAged()
+	return common.BytesToHash(collapsed.(hashNode)), nil
 }
 
+// hasher computes node hashes only: it never writes to a database and
+// never mutates the node it's given, so it's safe to call on a live trie
+// between other operations (unlike committer, it builds a fresh collapsed
+// copy of every shortNode/fullNode it visits rather than reusing their
+// backing slices).
 type hasher struct {
 	tmp *bytes.Buffer
 	sha hash.Hash
@@ -464,56 +644,59 @@ func newHasher() *hasher {
 	return &hasher{tmp: new(bytes.Buffer), sha: sha3.NewKeccak256()}
 }
 
-func (h *hasher) hash(n node, db DatabaseWriter, force bool) (node, error) {
-	hashed, err := h.replaceChildren(n, db)
+func (h *hasher) hash(n node, force bool) (node, error) {
+	collapsed, err := h.replaceChildren(n)
 	if err != nil {
 		return hashNode{}, err
 	}
-	if n, err = h.store(hashed, db, force); err != nil {
-		return hashNode{}, err
-	}
-	return n, nil
+	return h.store(collapsed, force)
 }
 
-// hashChildren replaces child nodes of n with their hashes if the encoded
-// size of the child is larger than a hash.
-func (h *hasher) replaceChildren(n node, db DatabaseWriter) (node, error) {
-	var err error
+// replaceChildren returns a copy of n with every child replaced by its
+// hash, without ever writing into n's own Key/child slices.
+func (h *hasher) replaceChildren(n node) (node, error) {
 	switch n := n.(type) {
 	case shortNode:
-		n.Key = compactEncode(n.Key)
+		collapsed := shortNode{Key: compactEncode(n.Key), Val: n.Val}
 		if _, ok := n.Val.(valueNode); !ok {
-			if n.Val, err = h.hash(n.Val, db, false); err != nil {
+			child, err := h.hash(n.Val, false)
+			if err != nil {
 				return n, err
 			}
+			collapsed.Val = child
 		}
-		if n.Val == nil {
+		if collapsed.Val == nil {
 			// Ensure that nil children are encoded as empty strings.
-			n.Val = valueNode(nil)
+			collapsed.Val = valueNode(nil)
 		}
-		return n, nil
+		return collapsed, nil
 	case fullNode:
+		var collapsed fullNode
 		for i := 0; i < 16; i++ {
 			if n[i] != nil {
-				if n[i], err = h.hash(n[i], db, false); err != nil {
+				child, err := h.hash(n[i], false)
+				if err != nil {
 					return n, err
 				}
+				collapsed[i] = child
 			} else {
 				// Ensure that nil children are encoded as empty strings.
-				n[i] = valueNode(nil)
+				collapsed[i] = valueNode(nil)
 			}
 		}
-		if n[16] == nil {
-			n[16] = valueNode(nil)
+		if n[16] != nil {
+			collapsed[16] = n[16]
+		} else {
+			collapsed[16] = valueNode(nil)
 		}
-		return n, nil
+		return collapsed, nil
 	default:
 		return n, nil
 	}
 }
 
-func (h *hasher) store(n node, db DatabaseWriter, force bool) (node, error) {
-	// Don't store hashes or empty nodes.
+func (h *hasher) store(n node, force bool) (node, error) {
+	// Don't hash hashes or empty nodes.
 	if _, isHash := n.(hashNode); n == nil || isHash {
 		return n, nil
 	}
@@ -525,13 +708,153 @@ func (h *hasher) store(n node, db DatabaseWriter, force bool) (node, error) {
 		// Nodes smaller than 32 bytes are stored inside their parent.
 		return n, nil
 	}
-	// Larger nodes are replaced by their hash and stored in the database.
 	h.sha.Reset()
 	h.sha.Write(h.tmp.Bytes())
-	key := hashNode(h.sha.Sum(nil))
-	if db != nil {
-		err := db.Put(key, h.tmp.Bytes())
-		return key, err
+	return hashNode(h.sha.Sum(nil)), nil
+}
+
+// committer commits a trie to its database: like hasher it hashes every
+// node bigger than 32 bytes, but it also writes the encoding to the
+// database and collapses the node into the returned hashNode. A commit
+// walks the root's immediate children in parallel -- one goroutine per
+// child, keyed by its nibble in the root fullNode -- since they're
+// independent subtries, and funnels the resulting (hash, blob) pairs
+// through a channel to a single writer goroutine, since most
+// DatabaseWriter implementations aren't safe for concurrent Put calls.
+type committer struct {
+	db DatabaseWriter
+
+	results  chan committedNode
+	writeWg  sync.WaitGroup
+	writeErr error // only ever touched by the writer goroutine
+}
+
+// committedNode is a single RLP-encoded node ready to be written to disk
+// under its own hash.
+type committedNode struct {
+	hash hashNode
+	blob []byte
+}
+
+func newCommitter(db DatabaseWriter) *committer {
+	c := &committer{db: db, results: make(chan committedNode, 64)}
+	c.writeWg.Add(1)
+	go c.writeLoop()
+	return c
+}
+
+func (c *committer) writeLoop() {
+	defer c.writeWg.Done()
+	for r := range c.results {
+		if c.writeErr != nil {
+			continue // drain the channel so a racing commit never blocks
+		}
+		if err := c.db.Put(r.hash, r.blob); err != nil {
+			c.writeErr = err
+		}
+	}
+}
+
+// Commit hashes and stores n, returning its collapsed replacement in the
+// live tree.
+func (c *committer) Commit(n node) (node, error) {
+	collapsed, err := c.commit(n, true)
+	close(c.results)
+	c.writeWg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if c.writeErr != nil {
+		return nil, c.writeErr
+	}
+	return collapsed, nil
+}
+
+func (c *committer) commit(n node, force bool) (node, error) {
+	switch n := n.(type) {
+	case shortNode:
+		child, err := c.commitChild(n.Val)
+		if err != nil {
+			return n, err
+		}
+		return c.store(shortNode{compactEncode(n.Key), child}, force)
+	case fullNode:
+		collapsed, err := c.commitChildren(n)
+		if err != nil {
+			return n, err
+		}
+		return c.store(collapsed, force)
+	default:
+		// hashNode, valueNode, nil: already as small as it gets.
+		return n, nil
+	}
+}
+
+// commitChildren commits every one of a fullNode's 16 children in its own
+// goroutine, keyed by nibble, then waits for all of them before returning.
+func (c *committer) commitChildren(n fullNode) (fullNode, error) {
+	var (
+		wg   sync.WaitGroup
+		errs [16]error
+	)
+	for i, child := range n {
+		if i == 16 {
+			break // the 17th slot holds a value, not a child subtrie
+		}
+		if child == nil {
+			n[i] = valueNode(nil)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, child node) {
+			defer wg.Done()
+			committed, err := c.commitChild(child)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			n[i] = committed
+		}(i, child)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return n, err
+		}
+	}
+	if n[16] == nil {
+		n[16] = valueNode(nil)
+	}
+	return n, nil
+}
+
+func (c *committer) commitChild(n node) (node, error) {
+	if n == nil {
+		return valueNode(nil), nil
+	}
+	if _, ok := n.(valueNode); ok {
+		return n, nil
+	}
+	return c.commit(n, false)
+}
+
+func (c *committer) store(n node, force bool) (node, error) {
+	// Don't store hashes or empty nodes.
+	if _, isHash := n.(hashNode); n == nil || isHash {
+		return n, nil
+	}
+	var tmp bytes.Buffer
+	if err := rlp.Encode(&tmp, n); err != nil {
+		panic("encode error: " + err.Error())
+	}
+	if tmp.Len() < 32 && !force {
+		// Nodes smaller than 32 bytes are stored inside their parent.
+		return n, nil
 	}
-	return key, nil
+	// Larger nodes are replaced by their hash and handed to the writer
+	// goroutine to store, so the caller never blocks on db.Put directly.
+	blob := append([]byte(nil), tmp.Bytes()...)
+	hash := hashNode(crypto.Keccak256(blob))
+	c.results <- committedNode{hash: hash, blob: blob}
+	return hash, nil
 }
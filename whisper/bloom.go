@@ -0,0 +1,69 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the topic bloom filter used by peers to advertise their interest
+// in a subset of topics without revealing the topics themselves, letting a
+// sender prune envelopes that no connected peer could possibly want.
+
+package whisper
+
+// bloomFilterSize is the size, in bytes, of the topic bloom filter (512 bits).
+const bloomFilterSize = 64
+
+// TopicToBloom derives the bloom filter contribution of a single topic:
+// three 9-bit indices, one per overlapping pair of the topic's 4 bytes
+// ((0,1), (1,2), (2,3)) reduced mod the filter's bit width, each setting one
+// bit within a bloomFilterSize-byte filter. ORing the contributions of every
+// topic an envelope carries yields that envelope's Bloom; a peer's
+// advertised filter matches an envelope only if all three of a topic's bits
+// are set in it.
+func TopicToBloom(topic Topic) []byte {
+	bloom := make([]byte, bloomFilterSize)
+	for i := 0; i < 3; i++ {
+		idx := (uint16(topic[i])<<8 | uint16(topic[i+1])) % (bloomFilterSize * 8)
+		bloom[idx/8] |= 1 << (idx % 8)
+	}
+	return bloom
+}
+
+// BloomFilterMatch reports whether every bit set in bloom is also set in
+// filter, i.e. whether an envelope carrying bloom could possibly be of
+// interest to a peer advertising filter. A nil filter matches everything,
+// signalling a "full node" peer with no topic restriction.
+func BloomFilterMatch(filter, bloom []byte) bool {
+	if filter == nil {
+		return true
+	}
+	for i := 0; i < bloomFilterSize; i++ {
+		f := filter[i]
+		if (f | bloom[i]) != f {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomCombine ORs a set of per-topic bloom filters into a single combined
+// filter.
+func bloomCombine(blooms ...[]byte) []byte {
+	combined := make([]byte, bloomFilterSize)
+	for _, bloom := range blooms {
+		for i := 0; i < bloomFilterSize; i++ {
+			combined[i] |= bloom[i]
+		}
+	}
+	return combined
+}
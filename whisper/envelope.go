@@ -23,6 +23,7 @@ import (
 	"crypto/ecdsa"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -31,27 +32,38 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
+// EnvelopeVersion is the current wire format version written by this node.
+// Version 0 is the original, unversioned PoC-1 layout (still accepted on
+// read for backward compatibility); version 1 adds the leading Version
+// field exercised by this package's PoW, symmetric-key and bloom filter
+// additions, so those can keep evolving without forking the whole protocol.
+const EnvelopeVersion = uint8(1)
+
 // Envelope represents a clear-text data packet to transmit through the Whisper
 // network. Its contents may or may not be encrypted and signed.
 type Envelope struct {
-	Expiry uint32 // Whisper protocol specifies int32, really should be int64
-	TTL    uint32 // ^^^^^^
-	Topics []Topic
-	Data   []byte
-	Nonce  uint32
+	Version uint8
+	Expiry  uint32 // Whisper protocol specifies int32, really should be int64
+	TTL     uint32 // ^^^^^^
+	Topics  []Topic
+	Data    []byte
+	Nonce   uint32
 
-	hash common.Hash // Cached hash of the envelope to avoid rehashing every time
+	hash  common.Hash // Cached hash of the envelope to avoid rehashing every time
+	pow   float64     // Cached proof of work of the envelope to avoid recalculating every time
+	bloom []byte      // Cached topic bloom filter to avoid recomputing every time
 }
 
 // NewEnvelope wraps a Whisper message with expiration and destination data
 // included into an envelope for network forwarding.
 func NewEnvelope(ttl time.Duration, topics []Topic, msg *Message) *Envelope {
 	return &Envelope{
-		Expiry: uint32(time.Now().Add(ttl).Unix()),
-		TTL:    uint32(ttl.Seconds()),
-		Topics: topics,
-		Data:   msg.bytes(),
-		Nonce:  0,
+		Version: EnvelopeVersion,
+		Expiry:  uint32(time.Now().Add(ttl).Unix()),
+		TTL:     uint32(ttl.Seconds()),
+		Topics:  topics,
+		Data:    msg.bytes(),
+		Nonce:   0,
 	}
 }
 
@@ -77,12 +89,103 @@ func (self *Envelope) Seal(pow time.Duration) {
 
 // rlpWithoutNonce returns the RLP encoded envelope contents, except the nonce.
 func (self *Envelope) rlpWithoutNonce() []byte {
-	enc, _ := rlp.EncodeToBytes([]interface{}{self.Expiry, self.TTL, self.Topics, self.Data})
+	enc, _ := rlp.EncodeToBytes([]interface{}{self.Version, self.Expiry, self.TTL, self.Topics, self.Data})
 	return enc
 }
 
-// Open extracts the message contained within a potentially encrypted envelope.
+// SealWithTarget iterates nonces, starting from zero, until the envelope's
+// normalized proof of work reaches targetPoW or maxTime elapses, whichever
+// comes first. Unlike Seal, which just maximizes effort spent within a time
+// budget, this allows a sender to aim for a specific, peer-verifiable PoW
+// value regardless of the envelope's size or TTL.
+func (self *Envelope) SealWithTarget(targetPoW float64, maxTime time.Duration) error {
+	d := make([]byte, 64)
+	copy(d[:32], self.rlpWithoutNonce())
+
+	ttl := float64(self.TTL)
+	if ttl == 0 {
+		ttl = 1
+	}
+
+	origNonce := self.Nonce
+	finish := time.Now().Add(maxTime).UnixNano()
+	for nonce := uint32(0); ; nonce++ {
+		binary.BigEndian.PutUint32(d[60:], nonce)
+		firstBit := common.FirstBitSet(common.BigD(crypto.Keccak256(d)))
+
+		// The RLP size depends on the nonce's encoded width, so measure it as PoW does.
+		self.Nonce = nonce
+		if math.Pow(2, float64(firstBit))/(float64(self.Size())*ttl) >= targetPoW {
+			self.pow = 0 // invalidate the cache, it will be recomputed on first use
+			return nil
+		}
+		if time.Now().UnixNano() > finish {
+			self.Nonce = origNonce
+			return fmt.Errorf("failed to reach PoW target %f within %v", targetPoW, maxTime)
+		}
+	}
+}
+
+// PoW returns the normalized proof of work of the envelope, defined as
+// 2^firstBitSet(keccak256(d)), where d is 64 bytes holding the first 32 bytes
+// of rlpWithoutNonce and the big-endian nonce in its last 4, divided by the size of
+// the envelope (RLP-encoded, nonce included) in bytes and its advertised TTL
+// in seconds. This makes the cost of seeding spam proportional to both the
+// size and the lifetime of an envelope, rather than just the hash difficulty.
+func (self *Envelope) PoW() float64 {
+	if self.pow == 0 {
+		self.calculatePoW()
+	}
+	return self.pow
+}
+
+// calculatePoW computes the envelope's proof of work and caches the result.
+func (self *Envelope) calculatePoW() {
+	d := make([]byte, 64)
+	copy(d[:32], self.rlpWithoutNonce())
+	binary.BigEndian.PutUint32(d[60:], self.Nonce)
+	firstBit := common.FirstBitSet(common.BigD(crypto.Keccak256(d)))
+
+	size := float64(self.Size())
+
+	ttl := float64(self.TTL)
+	if ttl == 0 {
+		ttl = 1
+	}
+	self.pow = math.Pow(2, float64(firstBit)) / (size * ttl)
+}
+
+// NewSymmetricEnvelope wraps a Whisper message intended for symmetric-key,
+// multicast-style delivery: msg is AES-256-GCM sealed under symKey before
+// being embedded into the envelope, so no per-recipient ECIES wrapping is
+// required to reach a whole group sharing the key.
+func NewSymmetricEnvelope(ttl time.Duration, topics []Topic, msg *Message, symKey []byte) (*Envelope, error) {
+	if err := msg.encryptSym(symKey); err != nil {
+		return nil, err
+	}
+	return NewEnvelope(ttl, topics, msg), nil
+}
+
+// OpenKeys bundles the identities an Envelope may be opened with: an ECDSA
+// private key for the asymmetric (ECIES) path, a raw 32-byte key for the
+// symmetric (AES-256-GCM) path, or both, in which case the envelope's flags
+// decide which one actually applies.
+type OpenKeys struct {
+	Asym *ecdsa.PrivateKey
+	Sym  []byte
+}
+
+// Open extracts the message contained within a potentially encrypted envelope,
+// using key for the ECIES-encrypted path. It is a convenience wrapper around
+// OpenWith for the common case of a single asymmetric identity.
 func (self *Envelope) Open(key *ecdsa.PrivateKey) (msg *Message, err error) {
+	return self.OpenWith(OpenKeys{Asym: key})
+}
+
+// OpenWith extracts the message contained within a potentially encrypted
+// envelope, trying the symmetric key first when the envelope's symKeyFlag is
+// set, and falling back to the ECIES-encrypted path otherwise.
+func (self *Envelope) OpenWith(keys OpenKeys) (msg *Message, err error) {
 	// Split open the payload into a message construct
 	data := self.Data
 
@@ -94,6 +197,18 @@ func (self *Envelope) Open(key *ecdsa.PrivateKey) (msg *Message, err error) {
 	}
 	data = data[1:]
 
+	if message.Flags&paddingFlag == paddingFlag {
+		padLen, consumed, err := decodePaddingLength(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open envelope, bad padding: %v", err)
+		}
+		data = data[consumed:]
+		if len(data) < padLen {
+			return nil, fmt.Errorf("unable to open envelope, padding longer than remaining data")
+		}
+		message.Padding, data = data[:padLen], data[padLen:]
+	}
+
 	if message.Flags&signatureFlag == signatureFlag {
 		if len(data) < signatureLength {
 			return nil, fmt.Errorf("unable to open envelope. First bit set but len(data) < len(signature)")
@@ -102,11 +217,22 @@ func (self *Envelope) Open(key *ecdsa.PrivateKey) (msg *Message, err error) {
 	}
 	message.Payload = data
 
+	// Symmetrically encrypted messages are self-contained: no ECIES fallback applies.
+	if message.Flags&symKeyFlag == symKeyFlag {
+		if len(keys.Sym) != symKeyLength {
+			return nil, fmt.Errorf("unable to open envelope, no matching symmetric key")
+		}
+		if err := message.decryptSym(keys.Sym); err != nil {
+			return nil, fmt.Errorf("unable to open envelope, decrypt failed: %v", err)
+		}
+		return message, nil
+	}
+
 	// Decrypt the message, if requested
-	if key == nil {
+	if keys.Asym == nil {
 		return message, nil
 	}
-	err = message.decrypt(key)
+	err = message.decrypt(keys.Asym)
 	switch err {
 	case nil:
 		return message, nil
@@ -119,6 +245,29 @@ func (self *Envelope) Open(key *ecdsa.PrivateKey) (msg *Message, err error) {
 	}
 }
 
+// Bloom returns the combined topic bloom filter of the envelope, i.e. the
+// bitwise OR of TopicToBloom over every topic it carries. Peers use this to
+// decide, without inspecting the (possibly encrypted) payload, whether an
+// envelope could match their advertised interest.
+func (self *Envelope) Bloom() []byte {
+	if self.bloom == nil {
+		blooms := make([][]byte, len(self.Topics))
+		for i, topic := range self.Topics {
+			blooms[i] = TopicToBloom(topic)
+		}
+		self.bloom = bloomCombine(blooms...)
+	}
+	return self.bloom
+}
+
+// Size returns the RLP-encoded size, in bytes, of the envelope (nonce
+// included), re-encoding it on every call. Both PoW normalization and pool capacity
+// accounting key off of this value.
+func (self *Envelope) Size() int {
+	enc, _ := rlp.EncodeToBytes(self)
+	return len(enc)
+}
+
 // Hash returns the SHA3 hash of the envelope, calculating it if not yet done.
 func (self *Envelope) Hash() common.Hash {
 	if (self.hash == common.Hash{}) {
@@ -140,8 +289,43 @@ func (self *Envelope) DecodeRLP(s *rlp.Stream) error {
 	// rlp.Decoder so we can reuse the Envelope struct definition.
 	type rlpenv Envelope
 	if err := rlp.DecodeBytes(raw, (*rlpenv)(self)); err != nil {
-		return err
+		// Fall back to the pre-version (v0) wire format: a 5-element list
+		// without the leading Version field, kept readable so old peers
+		// aren't forced onto the new format in lockstep.
+		var legacy struct {
+			Expiry uint32
+			TTL    uint32
+			Topics []Topic
+			Data   []byte
+			Nonce  uint32
+		}
+		if err := rlp.DecodeBytes(raw, &legacy); err != nil {
+			return err
+		}
+		self.Version = 0
+		self.Expiry, self.TTL, self.Topics, self.Data, self.Nonce = legacy.Expiry, legacy.TTL, legacy.Topics, legacy.Data, legacy.Nonce
 	}
 	self.hash = crypto.Keccak256Hash(raw)
 	return nil
 }
+
+// SupportedVersions lists the envelope wire format versions this node can
+// both read and write, in ascending order.
+var SupportedVersions = []uint8{0, EnvelopeVersion}
+
+// negotiateVersion picks the highest envelope format version present in both
+// SupportedVersions and the versions bitmap advertised by a remote peer
+// during the whisper handshake, so that Whisper.broadcast can downgrade to
+// whatever a given peer actually understands. It returns 0 (the always-on
+// legacy format) if there is no overlap.
+func negotiateVersion(remote []uint8) uint8 {
+	best := uint8(0)
+	for _, v := range SupportedVersions {
+		for _, r := range remote {
+			if v == r && v > best {
+				best = v
+			}
+		}
+	}
+	return best
+}
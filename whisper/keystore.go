@@ -0,0 +1,113 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the optional on-disk persistence of whisper identities, so a
+// restarted node can still decrypt envelopes still in flight that were
+// addressed to a pre-restart identity.
+
+package whisper
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// KeyStore persists whisper identities across restarts. Whisper.Start calls
+// LoadKeys once to repopulate self.keys; NewIdentity and InjectIdentity call
+// StoreKey as new identities are added. A nil KeyStore (the default from
+// New(nil)) leaves identities in-memory only, exactly as before this
+// abstraction existed.
+type KeyStore interface {
+	LoadKeys() ([]*ecdsa.PrivateKey, error)
+	StoreKey(*ecdsa.PrivateKey) error
+	DeleteKey(*ecdsa.PublicKey) error
+}
+
+// fileKeyStore is the default KeyStore: every identity is encrypted with a
+// single, store-wide passphrase and written as a Web3 Secret Storage v3 key
+// file, the same format and scrypt cost (N=2^18, r=8, p=1) go-ethereum's
+// account keystore uses, reusing its EncryptKey/DecryptKey directly. Unlike
+// account keys, files are named deterministically by address rather than by
+// creation timestamp, so DeleteKey can remove the right one without having
+// to decrypt every file in the directory first.
+type fileKeyStore struct {
+	keydir     string
+	passphrase string
+}
+
+// NewFileKeyStore creates a KeyStore that persists identities as encrypted
+// key files under keydir, protected by passphrase.
+func NewFileKeyStore(keydir, passphrase string) KeyStore {
+	return &fileKeyStore{keydir: keydir, passphrase: passphrase}
+}
+
+func (ks *fileKeyStore) path(pub *ecdsa.PublicKey) string {
+	return filepath.Join(ks.keydir, fmt.Sprintf("whisper--%x.json", crypto.PubkeyToAddress(*pub)))
+}
+
+// StoreKey encrypts key with the store's passphrase and writes it under
+// keydir, creating the directory if it doesn't already exist.
+func (ks *fileKeyStore) StoreKey(key *ecdsa.PrivateKey) error {
+	if err := os.MkdirAll(ks.keydir, 0700); err != nil {
+		return err
+	}
+	content, err := accounts.EncryptKey(accounts.NewKeyFromECDSA(key), ks.passphrase, accounts.StandardScryptN, accounts.StandardScryptP)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ks.path(&key.PublicKey), content, 0600)
+}
+
+// DeleteKey removes the key file for pub, if any. A missing file is not
+// treated as an error.
+func (ks *fileKeyStore) DeleteKey(pub *ecdsa.PublicKey) error {
+	if err := os.Remove(ks.path(pub)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// LoadKeys decrypts and returns every key file found under keydir. Files
+// that fail to decrypt with the store's passphrase, or aren't key files at
+// all, are silently skipped.
+func (ks *fileKeyStore) LoadKeys() ([]*ecdsa.PrivateKey, error) {
+	files, err := ioutil.ReadDir(ks.keydir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []*ecdsa.PrivateKey
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(ks.keydir, file.Name()))
+		if err != nil {
+			continue
+		}
+		key, err := accounts.DecryptKey(content, ks.passphrase)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key.PrivateKey)
+	}
+	return keys, nil
+}
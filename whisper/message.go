@@ -0,0 +1,318 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the Whisper protocol Message element. For formal details please see
+// the specs at https://github.com/ethereum/wiki/wiki/Whisper-PoC-1-Protocol-Spec#messages.
+
+package whisper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+const (
+	symKeyFlag   = byte(1 << 6)
+	symKeyIVSize = 12 // AES-256-GCM standard nonce size
+	symKeyLength = 32 // AES-256
+
+	paddingFlag = byte(1 << 5)
+)
+
+// Message represents a decrypted, plain-text Whisper message to or from a
+// client. It is the payload half of an Envelope, once extracted, verified
+// and decrypted.
+type Message struct {
+	Flags     byte
+	Padding   []byte
+	Signature []byte
+	Sent      time.Time
+	TTL       time.Duration
+	To        *ecdsa.PublicKey
+	SymKeyID  string
+	Hash      common.Hash
+	Payload   []byte
+}
+
+// Options specifies the parameters used when wrapping a Message into a
+// sealed Envelope: who signs it, who it is addressed to (asymmetrically or
+// symmetrically), how long it should live for, and how it should be padded.
+//
+// PadTo rounds the final wire size of the message up to the next multiple of
+// PadTo bytes (e.g. 256), hiding the true payload size from network
+// observers behind a fixed bucket. PadRandom additionally (or instead) adds
+// a uniformly random 0-255 extra bytes on top, so that messages padded to
+// the same bucket can't be correlated by their exact size either.
+type Options struct {
+	From      *ecdsa.PrivateKey
+	To        *ecdsa.PublicKey
+	SymKeyID  string
+	SymKey    []byte
+	TTL       time.Duration
+	Topics    []Topic
+	PadTo     int
+	PadRandom bool
+}
+
+// NewMessage creates and initializes a non-secured message with the given
+// cleartext payload, ready to be signed and/or encrypted before sealing.
+func NewMessage(payload []byte) *Message {
+	return &Message{Flags: 0, Payload: payload, Sent: time.Now()}
+}
+
+// Wrap signs, optionally encrypts and seals the message into an Envelope,
+// spending pow as the proof-of-work effort budget.
+func (self *Message) Wrap(pow time.Duration, options Options) (*Envelope, error) {
+	if options.TTL == 0 {
+		options.TTL = DefaultTTL
+	}
+	self.TTL = options.TTL
+
+	if options.From != nil {
+		if err := self.sign(options.From); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case options.To != nil:
+		if err := self.encrypt(options.To); err != nil {
+			return nil, err
+		}
+	case len(options.SymKey) == symKeyLength:
+		self.SymKeyID = options.SymKeyID
+		if err := self.encryptSym(options.SymKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := self.pad(options); err != nil {
+		return nil, err
+	}
+	envelope := NewEnvelope(options.TTL, options.Topics, self)
+	envelope.Seal(pow)
+	return envelope, nil
+}
+
+// sign calculates and sets the cryptographic signature for the message,
+// also setting the sign flag.
+func (self *Message) sign(key *ecdsa.PrivateKey) (err error) {
+	self.Flags |= signatureFlag
+	self.Signature, err = crypto.Sign(self.hash(), key)
+	return err
+}
+
+// Recover retrieves the public key of the message signer, or nil if the
+// message isn't signed or the signature is corrupt.
+func (self *Message) Recover() *ecdsa.PublicKey {
+	if self.Signature == nil {
+		return nil
+	}
+	defer func() { recover() }() // in case of invalid signature
+
+	pub, err := crypto.SigToPub(self.hash(), self.Signature)
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// encrypt asymmetrically encrypts the payload of the message for a single
+// recipient using ECIES.
+func (self *Message) encrypt(key *ecdsa.PublicKey) (err error) {
+	self.Payload, err = ecies.Encrypt(crand.Reader, ecies.ImportECDSAPublic(key), self.Payload, nil, nil)
+	return err
+}
+
+// encryptSym encrypts the payload of the message with AES-256-GCM under the
+// given 32-byte symmetric key, setting the symmetric-key flag. A fresh random
+// nonce is generated per message and appended to the ciphertext so that
+// receivers holding the same key can recover it.
+func (self *Message) encryptSym(key []byte) error {
+	if len(key) != symKeyLength {
+		return fmt.Errorf("invalid symmetric key size: got %d, want %d", len(key), symKeyLength)
+	}
+	self.Flags |= symKeyFlag
+
+	gcm, err := makeGCMCipher(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, symKeyIVSize)
+	if _, err := crand.Read(nonce); err != nil {
+		return err
+	}
+	self.Payload = append(gcm.Seal(nil, nonce, self.Payload, nil), nonce...)
+	return nil
+}
+
+// decrypt asymmetrically decrypts the payload of the message with key,
+// mirroring the ECIES encryption performed by encrypt.
+func (self *Message) decrypt(key *ecdsa.PrivateKey) error {
+	plain, err := ecies.ImportECDSA(key).Decrypt(crand.Reader, self.Payload, nil, nil)
+	if err != nil {
+		return err
+	}
+	self.Payload = plain
+	return nil
+}
+
+// decryptSym reverses encryptSym, validating and stripping the trailing nonce
+// before decrypting the AES-256-GCM ciphertext in place.
+func (self *Message) decryptSym(key []byte) error {
+	if len(self.Payload) < symKeyIVSize {
+		return fmt.Errorf("missing salt or invalid payload in symmetric message")
+	}
+	gcm, err := makeGCMCipher(key)
+	if err != nil {
+		return err
+	}
+	salt, ciphertext := self.Payload[len(self.Payload)-symKeyIVSize:], self.Payload[:len(self.Payload)-symKeyIVSize]
+
+	plain, err := gcm.Open(nil, salt, ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("unable to open symmetrically encrypted payload: %v", err)
+	}
+	self.Payload = plain
+	return nil
+}
+
+// hash returns the cryptographic hash of the message's payload, used both for
+// signing and signature recovery.
+func (self *Message) hash() []byte {
+	return crypto.Keccak256(self.Payload)
+}
+
+// pad computes and stores the random padding requested by options, run after
+// signing/encryption so the padding can account for their exact size impact.
+// It sets paddingFlag whenever any padding ends up being added.
+func (self *Message) pad(options Options) error {
+	if options.PadTo <= 0 && !options.PadRandom {
+		return nil
+	}
+	size := 0
+	if options.PadTo > 0 {
+		base := 1 + len(self.Signature) + len(self.Payload)
+		size = paddedSize(base, options.PadTo, 0)
+		if size == 0 {
+			// base plus the smallest possible padding field was already an
+			// exact multiple of PadTo; add a full extra PadTo bytes rather
+			// than skip the field, so a message's wire size never betrays
+			// that it happened to need no padding at all.
+			size = paddedSize(base, options.PadTo, options.PadTo)
+		}
+	}
+	if options.PadRandom {
+		extra, err := crand.Int(crand.Reader, big.NewInt(256))
+		if err != nil {
+			return err
+		}
+		size += int(extra.Int64())
+	}
+	if size == 0 {
+		return nil
+	}
+	self.Flags |= paddingFlag
+	self.Padding = make([]byte, size)
+	if _, err := crand.Read(self.Padding); err != nil {
+		return err
+	}
+	return nil
+}
+
+// paddedSize returns the smallest size no less than start such that base plus
+// a length-prefixed padding field of that size (flags/signature/payload plus
+// encodePaddingLength(size) plus size itself) is an exact multiple of padTo.
+// A plain "size = padTo - base%padTo" isn't enough, because
+// encodePaddingLength's own prefix grows from 2 to 3 or 4 bytes as size
+// grows past 256 or 65536, which can in turn push the total past the next
+// multiple again; when that happens, the size rem bytes lower (same prefix
+// width) is taken instead of stepping on to a later multiple.
+func paddedSize(base, padTo, start int) int {
+	size := start
+	for {
+		total := base + len(encodePaddingLength(size)) + size
+		rem := total % padTo
+		if rem == 0 {
+			return size
+		}
+		if back := size - rem; back >= start && len(encodePaddingLength(back)) == len(encodePaddingLength(size)) {
+			return back
+		}
+		size += padTo - rem
+	}
+}
+
+// encodePaddingLength encodes n as a self-describing, 2-to-4 byte prefix: a
+// leading byte counting the big-endian length bytes that follow (1-3 of
+// them), sized to the smallest encoding that fits n.
+func encodePaddingLength(n int) []byte {
+	switch {
+	case n < 1<<8:
+		return []byte{1, byte(n)}
+	case n < 1<<16:
+		return []byte{2, byte(n >> 8), byte(n)}
+	default:
+		return []byte{3, byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+}
+
+// decodePaddingLength parses a prefix written by encodePaddingLength,
+// returning the decoded length and the number of bytes the prefix occupied.
+func decodePaddingLength(data []byte) (length, consumed int, err error) {
+	if len(data) < 1 {
+		return 0, 0, fmt.Errorf("truncated padding length prefix")
+	}
+	n := int(data[0])
+	if n < 1 || n > 3 || len(data) < 1+n {
+		return 0, 0, fmt.Errorf("invalid padding length prefix")
+	}
+	for _, b := range data[1 : 1+n] {
+		length = length<<8 | int(b)
+	}
+	return length, 1 + n, nil
+}
+
+// bytes flattens the message into its wire representation: a flags byte,
+// the padding field (if any), the signature (if any) and finally the
+// (possibly encrypted) payload.
+func (self *Message) bytes() []byte {
+	data := make([]byte, 1, 1+4+len(self.Padding)+len(self.Signature)+len(self.Payload))
+	data[0] = self.Flags
+	if self.Flags&paddingFlag == paddingFlag {
+		data = append(data, encodePaddingLength(len(self.Padding))...)
+		data = append(data, self.Padding...)
+	}
+	data = append(data, self.Signature...)
+	data = append(data, self.Payload...)
+	return data
+}
+
+// makeGCMCipher derives an AES-256-GCM AEAD from a raw 32-byte symmetric key.
+func makeGCMCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,151 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package whisper
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestMessageSymmetricRoundTrip checks that encryptSym/decryptSym recover the
+// original payload under the same key, and that decryptSym rejects the
+// ciphertext under a different key instead of silently returning garbage.
+func TestMessageSymmetricRoundTrip(t *testing.T) {
+	key := make([]byte, symKeyLength)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	wrongKey := make([]byte, symKeyLength)
+	for i := range wrongKey {
+		wrongKey[i] = byte(i + 1)
+	}
+	payload := []byte("a whisper message, symmetrically sealed")
+
+	msg := &Message{Payload: append([]byte(nil), payload...)}
+	if err := msg.encryptSym(key); err != nil {
+		t.Fatalf("encryptSym() error = %v", err)
+	}
+	if bytes.Equal(msg.Payload, payload) {
+		t.Fatal("encryptSym() left the payload in clear text")
+	}
+	if msg.Flags&symKeyFlag == 0 {
+		t.Fatal("encryptSym() did not set symKeyFlag")
+	}
+
+	sealed := append([]byte(nil), msg.Payload...)
+	if err := msg.decryptSym(wrongKey); err == nil {
+		t.Fatal("decryptSym() succeeded under the wrong key, want error")
+	}
+	msg.Payload = sealed
+	if err := msg.decryptSym(key); err != nil {
+		t.Fatalf("decryptSym() error = %v", err)
+	}
+	if !bytes.Equal(msg.Payload, payload) {
+		t.Fatalf("decryptSym() = %x, want %x", msg.Payload, payload)
+	}
+}
+
+// TestMessageAsymmetricRoundTrip checks that encrypt/decrypt recover the
+// original payload under the recipient's private key, and that decrypt fails
+// under an unrelated key rather than returning the wrong plaintext.
+func TestMessageAsymmetricRoundTrip(t *testing.T) {
+	recipient, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("a whisper message, asymmetrically sealed")
+
+	msg := &Message{Payload: append([]byte(nil), payload...)}
+	if err := msg.encrypt(&recipient.PublicKey); err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	if bytes.Equal(msg.Payload, payload) {
+		t.Fatal("encrypt() left the payload in clear text")
+	}
+
+	sealed := append([]byte(nil), msg.Payload...)
+	if err := msg.decrypt(other); err == nil {
+		t.Fatal("decrypt() succeeded under an unrelated key, want error")
+	}
+	msg.Payload = sealed
+	if err := msg.decrypt(recipient); err != nil {
+		t.Fatalf("decrypt() error = %v", err)
+	}
+	if !bytes.Equal(msg.Payload, payload) {
+		t.Fatalf("decrypt() = %x, want %x", msg.Payload, payload)
+	}
+}
+
+// wireSize is the length self.bytes() would produce once pad has run,
+// without actually invoking it (self.bytes() needs signature/payload/padding
+// already set, which is exactly what these tests are checking the size of).
+func wireSize(msg *Message) int {
+	size := 1 + len(msg.Signature) + len(msg.Payload)
+	if msg.Flags&paddingFlag == paddingFlag {
+		size += len(encodePaddingLength(len(msg.Padding))) + len(msg.Padding)
+	}
+	return size
+}
+
+// TestPadAlignsToPadTo checks that pad() always leaves the message's wire
+// size an exact multiple of PadTo, including the two edge cases that used to
+// break it: a payload that lands exactly on a PadTo boundary (the padding
+// field used to be skipped entirely, undershooting by its own prefix length)
+// and a payload large enough that the padding length itself needs a 3- or
+// 4-byte prefix instead of the 2-byte one accounted for.
+func TestPadAlignsToPadTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   int
+		signature int
+		padTo     int
+	}{
+		{"ordinary", 10, 65, 256},
+		{"exact boundary", 253, 0, 256},
+		{"large padding needs wider prefix", 10, 0, 512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Payload: make([]byte, tt.payload), Signature: make([]byte, tt.signature)}
+			if err := msg.pad(Options{PadTo: tt.padTo}); err != nil {
+				t.Fatal(err)
+			}
+			if size := wireSize(msg); size%tt.padTo != 0 {
+				t.Fatalf("wire size = %d, not a multiple of PadTo=%d", size, tt.padTo)
+			}
+		})
+	}
+}
+
+// TestPadAlwaysAddsField checks that pad(), once PadTo is set, never leaves
+// paddingFlag unset -- even when the message's unpadded size already lands
+// on a PadTo boundary, which used to make pad() skip the field altogether.
+func TestPadAlwaysAddsField(t *testing.T) {
+	msg := &Message{Payload: make([]byte, 253)}
+	if err := msg.pad(Options{PadTo: 256}); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Flags&paddingFlag == 0 {
+		t.Fatal("paddingFlag not set")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the bounded envelope pool used by the Whisper node: an
+// expiry-ordered heap that lets TTL cleanup pop only what's actually due
+// instead of scanning every pooled envelope, and a pow-per-byte-ordered heap
+// that decides what to evict first once a capacity limit is hit.
+
+package whisper
+
+import (
+	"container/heap"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+// expiryEntry is a single envelope's hash and expiry timestamp, as tracked by
+// expiryHeap. The envelope itself may already be gone from self.messages by
+// the time this entry is popped, evicted early under capacity pressure;
+// expire() treats that as a no-op rather than an error.
+type expiryEntry struct {
+	hash   common.Hash
+	expiry uint32
+}
+
+// expiryHeap is a container/heap.Interface ordering pooled envelopes by
+// ascending expiry timestamp, so expire() can pop exactly the envelopes due
+// for removal in O(log n) per entry instead of scanning the whole pool.
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiry < h[j].expiry }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(*expiryEntry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// envelopeHeapEntry is a pooled envelope together with its pow-per-byte,
+// cached at insert time so eviction ordering doesn't repeatedly recompute it,
+// and its current index in the owning envelopeHeap, kept up to date by
+// Push/Pop/Swap so removeFromEvictionQueue can heap.Remove it directly
+// without a linear scan.
+type envelopeHeapEntry struct {
+	envelope   *Envelope
+	powPerByte float64
+	index      int
+}
+
+// envelopeHeap is a container/heap.Interface ordering pooled envelopes by
+// ascending pow-per-byte, so the weakest (cheapest-per-byte, i.e. worst
+// spam-economics) envelope is always at the root and is the first one
+// evicted under memory pressure -- a large low-PoW envelope is worse for the
+// pool than a small one with the same raw PoW.
+type envelopeHeap []*envelopeHeapEntry
+
+func (h envelopeHeap) Len() int           { return len(h) }
+func (h envelopeHeap) Less(i, j int) bool { return h[i].powPerByte < h[j].powPerByte }
+func (h envelopeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *envelopeHeap) Push(x interface{}) {
+	entry := x.(*envelopeHeapEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *envelopeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// Stats summarizes the current state of the envelope pool.
+type Stats struct {
+	Bytes     int // Total RLP-encoded size of all pooled envelopes
+	Envelopes int // Number of envelopes currently pooled
+	Expired   int // Envelopes dropped so far for exceeding their TTL
+	Evicted   int // Envelopes dropped so far to stay within the configured capacity
+}
+
+// Stats returns a snapshot of the envelope pool's current size and
+// cumulative eviction counts.
+func (self *Whisper) Stats() Stats {
+	self.poolMu.RLock()
+	defer self.poolMu.RUnlock()
+	return Stats{
+		Bytes:     self.poolBytes,
+		Envelopes: len(self.messages),
+		Expired:   self.expiredCount,
+		Evicted:   self.evictedCount,
+	}
+}
+
+// SetMaxEnvelopeBytes bounds the total RLP-encoded size, in bytes, of the
+// envelopes the node will pool simultaneously. A value of 0 (the default)
+// disables the limit.
+func (self *Whisper) SetMaxEnvelopeBytes(n int) {
+	self.poolMu.Lock()
+	defer self.poolMu.Unlock()
+	self.maxEnvelopeBytes = n
+}
+
+// SetMaxEnvelopeCount bounds the number of envelopes the node will pool
+// simultaneously. A value of 0 (the default) disables the limit.
+func (self *Whisper) SetMaxEnvelopeCount(n int) {
+	self.poolMu.Lock()
+	defer self.poolMu.Unlock()
+	self.maxEnvelopeCount = n
+}
+
+// SetMaxMessageSize bounds the RLP-encoded size, in bytes, of any single
+// envelope this node will accept into the pool; add() refuses anything
+// larger outright rather than letting it in and evicting it again. A value
+// of 0 (the default) disables the limit.
+func (self *Whisper) SetMaxMessageSize(n int) {
+	self.poolMu.Lock()
+	defer self.poolMu.Unlock()
+	self.maxMessageSize = n
+}
+
+// trackForEviction indexes a newly pooled envelope into the pow-per-byte
+// ordered heap and enforces the configured capacity limits, evicting the
+// weakest envelopes first until the pool is back within bounds. Callers must
+// hold poolMu.
+func (self *Whisper) trackForEviction(envelope *Envelope) {
+	size := envelope.Size()
+	entry := &envelopeHeapEntry{envelope: envelope, powPerByte: envelope.PoW() / float64(size)}
+	heap.Push(&self.envelopeQueue, entry)
+	self.envelopeEntries[envelope.Hash()] = entry
+	self.poolBytes += size
+
+	for (self.maxEnvelopeCount > 0 && len(self.messages) > self.maxEnvelopeCount) ||
+		(self.maxEnvelopeBytes > 0 && self.poolBytes > self.maxEnvelopeBytes) {
+		if self.envelopeQueue.Len() == 0 {
+			break
+		}
+		weakest := self.envelopeQueue[0]
+		self.evict(weakest.envelope.Hash())
+	}
+}
+
+// evict removes an envelope from the message pool and its envelopeQueue
+// eviction entry, logging an "expired-by-eviction" notice. Callers must hold
+// poolMu.
+func (self *Whisper) evict(hash common.Hash) {
+	envelope, ok := self.messages[hash]
+	if !ok {
+		return
+	}
+	self.poolBytes -= envelope.Size()
+	delete(self.messages, hash)
+	self.removeFromEvictionQueue(hash)
+	self.evictedCount++
+	glog.V(logger.Debug).Infof("whisper envelope %x expired-by-eviction (PoW %f, pool over capacity)", hash, envelope.PoW())
+}
+
+// removeFromEvictionQueue drops hash's entry from envelopeQueue, wherever it
+// currently sits. Unlike expiryQueue -- which expire() drains on its own as
+// time passes, so a capacity-evicted envelope's leftover entry there is
+// harmless and lazily skipped -- envelopeQueue is otherwise only popped by
+// trackForEviction's capacity loop, which stays inert whenever
+// maxEnvelopeCount/maxEnvelopeBytes are left at their default of 0. Without
+// this, an envelope removed by expire() would leave a permanent dead slot in
+// envelopeQueue that nothing ever pops. Callers must hold poolMu.
+func (self *Whisper) removeFromEvictionQueue(hash common.Hash) {
+	entry, ok := self.envelopeEntries[hash]
+	if !ok {
+		return
+	}
+	heap.Remove(&self.envelopeQueue, entry.index)
+	delete(self.envelopeEntries, hash)
+}
@@ -17,7 +17,10 @@
 package whisper
 
 import (
+	"container/heap"
 	"crypto/ecdsa"
+	crand "crypto/rand"
+	"fmt"
 	"sync"
 	"time"
 
@@ -28,14 +31,16 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/rpc"
-
-	"gopkg.in/fatih/set.v0"
 )
 
 const (
-	statusCode   = 0x00
-	messagesCode = 0x01
+	statusCode        = 0x00
+	messagesCode      = 0x01
+	bloomFilterExCode = 0x02 // advertises a peer's topic bloom filter changing mid-session
+	p2pRequestCode    = 0x03 // asks a trusted peer's mail server for historic messages
+	p2pMessageCode    = 0x04 // delivers historic messages directly, bypassing PoW/expiry checks
 
 	protocolVersion uint64 = 0x02
 	protocolName           = "shh"
@@ -58,34 +63,91 @@ type MessageEvent struct {
 	Message *Message
 }
 
+// MailServer is the interface a node implements to serve historic envelopes
+// -- ones that have already expired out of the normal pool -- to peers that
+// request them over the trusted p2pRequestCode/p2pMessageCode channel. A
+// requester encrypts a time-range/topic query as the payload of request,
+// signed with its own identity; the server decides what, if anything, to
+// send back as p2pMessageCode envelopes.
+type MailServer interface {
+	DeliverMail(peer *p2p.Peer, request *Envelope)
+}
+
 // Whisper represents a dark communication interface through the Ethereum
 // network, using its very own P2P communication layer.
 type Whisper struct {
 	protocol p2p.Protocol
 	filters  *filter.Filters
 
-	keys map[string]*ecdsa.PrivateKey
+	keys     map[string]*ecdsa.PrivateKey
+	keyStore KeyStore // Optional on-disk persistence for keys; nil means in-memory only
+
+	symKeys   map[string][]byte // Symmetric (AES-256-GCM) identities, keyed by caller-chosen name
+	symKeysMu sync.RWMutex      // Mutex to sync the symmetric key map
+
+	minPoW   float64      // Minimum accepted envelope PoW; see SetMinimumPoW
+	minPoWMu sync.RWMutex // Mutex to sync minPoW
 
 	messages    map[common.Hash]*Envelope // Pool of messages currently tracked by this node
-	expirations map[uint32]*set.SetNonTS  // Message expiration pool (TODO: something lighter)
-	poolMu      sync.RWMutex              // Mutex to sync the message and expiration pools
+	expiryQueue expiryHeap                // Pooled envelopes ordered by ascending Expiry, for O(log n) expiry
+	poolMu      sync.RWMutex              // Mutex to sync the message pool and both of its heaps
+
+	envelopeQueue    envelopeHeap                       // Pooled envelopes ordered by ascending PoW-per-byte, for capacity eviction
+	envelopeEntries  map[common.Hash]*envelopeHeapEntry // envelopeQueue's entries, keyed by hash, so an envelope leaving the pool via expire() can drop its heap entry directly instead of leaking it
+	poolBytes        int                                // Running total of the RLP-encoded size of pooled envelopes
+	maxEnvelopeBytes int                                // Soft cap on poolBytes, 0 means unbounded
+	maxEnvelopeCount int                                // Soft cap on len(messages), 0 means unbounded
+	maxMessageSize   int                                // Hard cap on a single envelope's size; oversized envelopes are refused outright
+
+	expiredCount int // Envelopes dropped so far for exceeding their TTL
+	evictedCount int // Envelopes dropped so far to stay within the configured capacity
 
 	peers  map[*peer]struct{} // Set of currently active peers
 	peerMu sync.RWMutex       // Mutex to sync the active peer set
 
+	bloomFilters   map[*peer][]byte // Per-peer advertised topic bloom filters
+	bloomFiltersMu sync.RWMutex     // Mutex to sync the per-peer filter map
+
+	powFilters   map[*peer]float64 // Per-peer advertised minimum PoW, for outbound filtering
+	powFiltersMu sync.RWMutex      // Mutex to sync the per-peer PoW map
+
+	filterTopics   map[int][]Topic // Topics behind each currently installed filter, keyed by filter id
+	installedBloom []byte          // Aggregate bloom of filterTopics, recomputed on Watch/Unwatch
+	bloomOverride  []byte          // Explicit override set via SetBloomFilter, if any
+	bloomMu        sync.RWMutex    // Mutex to sync filterTopics, installedBloom and bloomOverride
+
+	mailServer   MailServer   // Registered historic message source, if any; see RegisterServer
+	mailServerMu sync.RWMutex // Mutex to sync mailServer
+
+	trustedPeers   map[discover.NodeID]struct{} // Peers allowed to use the direct mail-server codes
+	trustedPeersMu sync.RWMutex                 // Mutex to sync trustedPeers
+
+	p2pPeers   map[*p2p.Peer]p2p.MsgWriter // Connected peers' writers, for direct (non-broadcast) delivery
+	p2pPeersMu sync.RWMutex                // Mutex to sync p2pPeers
+
 	quit chan struct{}
 }
 
 // New creates a Whisper client ready to communicate through the Ethereum P2P
-// network.
-func New() *Whisper {
+// network. ks, if non-nil, is consulted by Start to repopulate identities
+// left over from a previous run, and is written to as new identities are
+// created; passing nil keeps identities in-memory only, as before ks
+// existed.
+func New(ks KeyStore) *Whisper {
 	whisper := &Whisper{
-		filters:     filter.New(),
-		keys:        make(map[string]*ecdsa.PrivateKey),
-		messages:    make(map[common.Hash]*Envelope),
-		expirations: make(map[uint32]*set.SetNonTS),
-		peers:       make(map[*peer]struct{}),
-		quit:        make(chan struct{}),
+		filters:         filter.New(),
+		keys:            make(map[string]*ecdsa.PrivateKey),
+		keyStore:        ks,
+		symKeys:         make(map[string][]byte),
+		messages:        make(map[common.Hash]*Envelope),
+		envelopeEntries: make(map[common.Hash]*envelopeHeapEntry),
+		peers:           make(map[*peer]struct{}),
+		bloomFilters:    make(map[*peer][]byte),
+		powFilters:      make(map[*peer]float64),
+		filterTopics:    make(map[int][]Topic),
+		trustedPeers:    make(map[discover.NodeID]struct{}),
+		p2pPeers:        make(map[*p2p.Peer]p2p.MsgWriter),
+		quit:            make(chan struct{}),
 	}
 	whisper.filters.Start()
 
@@ -93,7 +155,7 @@ func New() *Whisper {
 	whisper.protocol = p2p.Protocol{
 		Name:    protocolName,
 		Version: uint(protocolVersion),
-		Length:  2,
+		Length:  5,
 		Run:     whisper.handlePeer,
 	}
 
@@ -129,11 +191,23 @@ func (self *Whisper) NewIdentity() *ecdsa.PrivateKey {
 	if err != nil {
 		panic(err)
 	}
-	self.keys[string(crypto.FromECDSAPub(&key.PublicKey))] = key
-
+	if err := self.InjectIdentity(key); err != nil {
+		glog.V(logger.Error).Infof("failed to persist new identity: %v", err)
+	}
 	return key
 }
 
+// InjectIdentity imports an externally-generated private key into the known
+// identities for message decryption, without generating a new one. Like
+// NewIdentity, it's also persisted through the configured KeyStore, if any.
+func (self *Whisper) InjectIdentity(key *ecdsa.PrivateKey) error {
+	self.keys[string(crypto.FromECDSAPub(&key.PublicKey))] = key
+	if self.keyStore == nil {
+		return nil
+	}
+	return self.keyStore.StoreKey(key)
+}
+
 // HasIdentity checks if the the whisper node is configured with the private key
 // of the specified public pair.
 func (self *Whisper) HasIdentity(key *ecdsa.PublicKey) bool {
@@ -145,6 +219,114 @@ func (self *Whisper) GetIdentity(key *ecdsa.PublicKey) *ecdsa.PrivateKey {
 	return self.keys[string(crypto.FromECDSAPub(key))]
 }
 
+// GenerateSymKey creates a new random 32-byte AES-256-GCM symmetric key and
+// registers it under name. It returns only an error; the key itself is
+// retrieved with GetSymKey, e.g. to hand it out of band to whoever else
+// should be able to read or write envelopes under that name.
+func (self *Whisper) GenerateSymKey(name string) error {
+	key := make([]byte, symKeyLength)
+	if _, err := crand.Read(key); err != nil {
+		return err
+	}
+	return self.AddSymKey(name, key)
+}
+
+// AddSymKey registers an existing symmetric key under name, so it is tried
+// against every symmetrically-encrypted envelope that arrives and can be
+// referenced from Options.SymKeyID when sealing new ones.
+func (self *Whisper) AddSymKey(name string, key []byte) error {
+	if len(key) != symKeyLength {
+		return fmt.Errorf("invalid symmetric key size: got %d, want %d", len(key), symKeyLength)
+	}
+	self.symKeysMu.Lock()
+	defer self.symKeysMu.Unlock()
+	if _, ok := self.symKeys[name]; ok {
+		return fmt.Errorf("symmetric key %q already exists", name)
+	}
+	self.symKeys[name] = key
+	return nil
+}
+
+// GetSymKey retrieves the symmetric key registered under name.
+func (self *Whisper) GetSymKey(name string) ([]byte, error) {
+	self.symKeysMu.RLock()
+	defer self.symKeysMu.RUnlock()
+	key, ok := self.symKeys[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown symmetric key: %q", name)
+	}
+	return key, nil
+}
+
+// DeleteSymKey removes the symmetric key registered under name, if any.
+func (self *Whisper) DeleteSymKey(name string) {
+	self.symKeysMu.Lock()
+	defer self.symKeysMu.Unlock()
+	delete(self.symKeys, name)
+}
+
+// MinPoW returns the minimum envelope PoW this node currently requires,
+// below which incoming and locally injected envelopes are silently dropped.
+func (self *Whisper) MinPoW() float64 {
+	self.minPoWMu.RLock()
+	defer self.minPoWMu.RUnlock()
+	return self.minPoW
+}
+
+// SetMinimumPoW sets the minimum envelope PoW this node requires. Raising it
+// only affects envelopes seen from this point on; nothing already pooled is
+// evicted retroactively.
+func (self *Whisper) SetMinimumPoW(pow float64) {
+	self.minPoWMu.Lock()
+	defer self.minPoWMu.Unlock()
+	self.minPoW = pow
+}
+
+// RegisterServer registers srv as the node's mail server, to answer historic
+// message requests arriving from trusted peers over p2pRequestCode. Passing
+// nil unregisters whatever server was previously set.
+func (self *Whisper) RegisterServer(srv MailServer) {
+	self.mailServerMu.Lock()
+	defer self.mailServerMu.Unlock()
+	self.mailServer = srv
+}
+
+// AllowP2PMessagesFromPeer marks enode as trusted, letting it exchange
+// p2pRequestCode/p2pMessageCode messages with this node directly, bypassing
+// the normal PoW and expiry checks that apply to ordinary envelope gossip.
+// This is what lets a designated mail server peer hand back envelopes that
+// have already expired out of the regular pool.
+func (self *Whisper) AllowP2PMessagesFromPeer(enode *discover.Node) {
+	self.trustedPeersMu.Lock()
+	defer self.trustedPeersMu.Unlock()
+	self.trustedPeers[enode.ID] = struct{}{}
+}
+
+// isTrustedPeer reports whether peer was previously allow-listed via
+// AllowP2PMessagesFromPeer.
+func (self *Whisper) isTrustedPeer(peer *p2p.Peer) bool {
+	self.trustedPeersMu.RLock()
+	defer self.trustedPeersMu.RUnlock()
+	_, ok := self.trustedPeers[peer.ID()]
+	return ok
+}
+
+// RequestHistoricMessages asks peer's mail server for the historic messages
+// matching envelope -- typically a time-range/topic query encrypted and
+// signed with the requester's own identity -- over the direct, PoW-exempt
+// p2pRequestCode channel. peer must already be connected and trusted via
+// AllowP2PMessagesFromPeer on both ends, or the mail server has no reason to
+// answer.
+func (self *Whisper) RequestHistoricMessages(peer *p2p.Peer, envelope *Envelope) error {
+	self.p2pPeersMu.RLock()
+	rw, ok := self.p2pPeers[peer]
+	self.p2pPeersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("whisper: not connected to peer %v", peer)
+	}
+	return p2p.Send(rw, p2pRequestCode, envelope)
+}
+
 // Watch installs a new message handler to run in case a matching packet arrives
 // from the whisper network.
 func (self *Whisper) Watch(options Filter) int {
@@ -156,12 +338,65 @@ func (self *Whisper) Watch(options Filter) int {
 			options.Fn(data.(*Message))
 		},
 	}
-	return self.filters.Install(filter)
+	id := self.filters.Install(filter)
+
+	self.bloomMu.Lock()
+	self.filterTopics[id] = options.Topics
+	self.updateInstalledBloomLocked()
+	self.bloomMu.Unlock()
+
+	return id
 }
 
 // Unwatch removes an installed message handler.
 func (self *Whisper) Unwatch(id int) {
 	self.filters.Uninstall(id)
+
+	self.bloomMu.Lock()
+	delete(self.filterTopics, id)
+	self.updateInstalledBloomLocked()
+	self.bloomMu.Unlock()
+}
+
+// updateInstalledBloomLocked recomputes installedBloom from the topics of
+// every currently installed filter. Callers must hold bloomMu.
+func (self *Whisper) updateInstalledBloomLocked() {
+	var blooms [][]byte
+	for _, topics := range self.filterTopics {
+		for _, topic := range topics {
+			blooms = append(blooms, TopicToBloom(topic))
+		}
+	}
+	if len(blooms) == 0 {
+		self.installedBloom = nil
+		return
+	}
+	self.installedBloom = bloomCombine(blooms...)
+}
+
+// BloomFilter returns the topic bloom filter this node advertises to its
+// peers: the explicit override set via SetBloomFilter, if any, or otherwise
+// the aggregate bloom of every locally installed filter's topics. A nil
+// return means the node is advertising a "full node" interest in every
+// topic.
+func (self *Whisper) BloomFilter() []byte {
+	self.bloomMu.RLock()
+	defer self.bloomMu.RUnlock()
+	if self.bloomOverride != nil {
+		return self.bloomOverride
+	}
+	return self.installedBloom
+}
+
+// SetBloomFilter overrides the bloom filter this node advertises to peers,
+// e.g. for a light-client relay that wants to advertise interest in a
+// narrower (or wider) topic set than what it actually has filters installed
+// for. Passing nil reverts to advertising the aggregate of installed
+// filters.
+func (self *Whisper) SetBloomFilter(bloom []byte) {
+	self.bloomMu.Lock()
+	defer self.bloomMu.Unlock()
+	self.bloomOverride = bloom
 }
 
 // Send injects a message into the whisper send queue, to be distributed in the
@@ -173,6 +408,16 @@ func (self *Whisper) Send(envelope *Envelope) error {
 // Start implements node.Service, starting the background data propagation thread
 // of the Whisper protocol.
 func (self *Whisper) Start(*p2p.Server) error {
+	if self.keyStore != nil {
+		keys, err := self.keyStore.LoadKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			self.keys[string(crypto.FromECDSAPub(&key.PublicKey))] = key
+		}
+		glog.V(logger.Info).Infof("Whisper loaded %d identities from disk", len(keys))
+	}
 	glog.V(logger.Info).Infoln("Whisper started")
 	go self.update()
 	return nil
@@ -186,6 +431,51 @@ func (self *Whisper) Stop() error {
 	return nil
 }
 
+// SetBloomFilterFor records the topic bloom filter advertised by a connected
+// peer, so that outbound envelope forwarding to that peer can be pruned to
+// envelopes it could plausibly want. A nil filter marks the peer as a "full
+// node" interested in every envelope.
+func (self *Whisper) SetBloomFilterFor(p *peer, filter []byte) {
+	self.bloomFiltersMu.Lock()
+	defer self.bloomFiltersMu.Unlock()
+	self.bloomFilters[p] = filter
+}
+
+// SetPoWFilterFor records the minimum PoW advertised by a connected peer, so
+// that outbound envelope forwarding to that peer can skip whatever it
+// already considers too cheap to be worth relaying.
+func (self *Whisper) SetPoWFilterFor(p *peer, minPoW float64) {
+	self.powFiltersMu.Lock()
+	defer self.powFiltersMu.Unlock()
+	self.powFilters[p] = minPoW
+}
+
+// envelopesFor returns the subset of envelopes currently pooled that match
+// the topic bloom filter last advertised by p and meet its advertised
+// minimum PoW, if any.
+func (self *Whisper) envelopesFor(p *peer) []*Envelope {
+	self.bloomFiltersMu.RLock()
+	filter := self.bloomFilters[p]
+	self.bloomFiltersMu.RUnlock()
+
+	self.powFiltersMu.RLock()
+	minPoW := self.powFilters[p]
+	self.powFiltersMu.RUnlock()
+
+	all := self.envelopes()
+	matched := make([]*Envelope, 0, len(all))
+	for _, envelope := range all {
+		if envelope.PoW() < minPoW {
+			continue
+		}
+		if filter != nil && !BloomFilterMatch(filter, envelope.Bloom()) {
+			continue
+		}
+		matched = append(matched, envelope)
+	}
+	return matched
+}
+
 // Messages retrieves all the currently pooled messages matching a filter id.
 func (self *Whisper) Messages(id int) []*Message {
 	messages := make([]*Message, 0)
@@ -211,10 +501,26 @@ func (self *Whisper) handlePeer(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
 	self.peers[whisperPeer] = struct{}{}
 	self.peerMu.Unlock()
 
+	self.p2pPeersMu.Lock()
+	self.p2pPeers[peer] = rw
+	self.p2pPeersMu.Unlock()
+
 	defer func() {
 		self.peerMu.Lock()
 		delete(self.peers, whisperPeer)
 		self.peerMu.Unlock()
+
+		self.bloomFiltersMu.Lock()
+		delete(self.bloomFilters, whisperPeer)
+		self.bloomFiltersMu.Unlock()
+
+		self.powFiltersMu.Lock()
+		delete(self.powFilters, whisperPeer)
+		self.powFiltersMu.Unlock()
+
+		self.p2pPeersMu.Lock()
+		delete(self.p2pPeers, peer)
+		self.p2pPeersMu.Unlock()
 	}()
 
 	// Run the peer handshake and state updates
@@ -231,18 +537,57 @@ func (self *Whisper) handlePeer(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
 		if err != nil {
 			return err
 		}
-		var envelopes []*Envelope
-		if err := packet.Decode(&envelopes); err != nil {
-			glog.V(logger.Info).Infof("%v: failed to decode envelope: %v", peer, err)
-			continue
-		}
-		// Inject all envelopes into the internal pool
-		for _, envelope := range envelopes {
-			if err := self.add(envelope); err != nil {
-				// TODO Punish peer here. Invalid envelope.
-				glog.V(logger.Debug).Infof("%v: failed to pool envelope: %v", peer, err)
+		switch packet.Code {
+		case p2pMessageCode:
+			// Historic envelopes delivered directly by a trusted mail server:
+			// skip the pool entirely and go straight to local subscribers,
+			// since they're expected to already be past their normal expiry.
+			if !self.isTrustedPeer(peer) {
+				glog.V(logger.Warn).Infof("%v: dropping direct message from untrusted peer", peer)
+				continue
+			}
+			var envelopes []*Envelope
+			if err := packet.Decode(&envelopes); err != nil {
+				glog.V(logger.Info).Infof("%v: failed to decode direct message: %v", peer, err)
+				continue
+			}
+			for _, envelope := range envelopes {
+				self.postEvent(envelope)
+			}
+
+		case p2pRequestCode:
+			// A trusted peer is asking the registered mail server for
+			// historic messages matching its request envelope.
+			if !self.isTrustedPeer(peer) {
+				glog.V(logger.Warn).Infof("%v: dropping history request from untrusted peer", peer)
+				continue
+			}
+			var request Envelope
+			if err := packet.Decode(&request); err != nil {
+				glog.V(logger.Info).Infof("%v: failed to decode history request: %v", peer, err)
+				continue
+			}
+			self.mailServerMu.RLock()
+			server := self.mailServer
+			self.mailServerMu.RUnlock()
+			if server != nil {
+				server.DeliverMail(peer, &request)
+			}
+
+		default:
+			var envelopes []*Envelope
+			if err := packet.Decode(&envelopes); err != nil {
+				glog.V(logger.Info).Infof("%v: failed to decode envelope: %v", peer, err)
+				continue
+			}
+			// Inject all envelopes into the internal pool
+			for _, envelope := range envelopes {
+				if err := self.add(envelope); err != nil {
+					// TODO Punish peer here. Invalid envelope.
+					glog.V(logger.Debug).Infof("%v: failed to pool envelope: %v", peer, err)
+				}
+				whisperPeer.mark(envelope)
 			}
-			whisperPeer.mark(envelope)
 		}
 	}
 }
@@ -258,6 +603,15 @@ func (self *Whisper) add(envelope *Envelope) error {
 	if envelope.Expiry <= uint32(time.Now().Unix()) {
 		return nil
 	}
+	// drop envelopes that don't meet the configured proof-of-work floor
+	if minPoW := self.MinPoW(); envelope.PoW() < minPoW {
+		glog.V(logger.Debug).Infof("envelope with insufficient PoW dropped: %f < %f", envelope.PoW(), minPoW)
+		return nil
+	}
+	// refuse envelopes too large to be worth pooling at all, regardless of PoW
+	if self.maxMessageSize > 0 && envelope.Size() > self.maxMessageSize {
+		return fmt.Errorf("envelope size %d exceeds maxMessageSize %d", envelope.Size(), self.maxMessageSize)
+	}
 
 	// Insert the message into the tracked pool
 	hash := envelope.Hash()
@@ -266,19 +620,14 @@ func (self *Whisper) add(envelope *Envelope) error {
 		return nil
 	}
 	self.messages[hash] = envelope
+	self.trackForEviction(envelope)
+	heap.Push(&self.expiryQueue, &expiryEntry{hash: hash, expiry: envelope.Expiry})
 
-	// Insert the message into the expiration pool for later removal
-	if self.expirations[envelope.Expiry] == nil {
-		self.expirations[envelope.Expiry] = set.NewNonTS()
-	}
-	if !self.expirations[envelope.Expiry].Has(hash) {
-		self.expirations[envelope.Expiry].Add(hash)
-
-		// Notify the local node of a message arrival
-		go self.postEvent(envelope)
-	}
 	glog.V(logger.Detail).Infof("cached whisper envelope %x\n", envelope)
 
+	// Notify the local node of a message arrival
+	go self.postEvent(envelope)
+
 	return nil
 }
 
@@ -294,13 +643,27 @@ func (self *Whisper) postEvent(envelope *Envelope) {
 // returning the decrypted message and the key used to achieve it. If not keys
 // are configured, open will return the payload as if non encrypted.
 func (self *Whisper) open(envelope *Envelope) *Message {
+	self.symKeysMu.RLock()
+	symKeys := make(map[string][]byte, len(self.symKeys))
+	for name, key := range self.symKeys {
+		symKeys[name] = key
+	}
+	self.symKeysMu.RUnlock()
+
 	// Short circuit if no identity is set, and assume clear-text
-	if len(self.keys) == 0 {
+	if len(self.keys) == 0 && len(symKeys) == 0 {
 		if message, err := envelope.Open(nil); err == nil {
 			return message
 		}
 	}
-	// Iterate over the keys and try to decrypt the message
+	// Iterate over the symmetric keys and try to decrypt the message
+	for name, key := range symKeys {
+		if message, err := envelope.OpenWith(OpenKeys{Sym: key}); err == nil {
+			message.SymKeyID = name
+			return message
+		}
+	}
+	// Iterate over the asymmetric keys and try to decrypt the message
 	for _, key := range self.keys {
 		message, err := envelope.Open(key)
 		if err == nil {
@@ -352,17 +715,16 @@ func (self *Whisper) expire() {
 	defer self.poolMu.Unlock()
 
 	now := uint32(time.Now().Unix())
-	for then, hashSet := range self.expirations {
-		// Short circuit if a future time
-		if then > now {
-			continue
+	for self.expiryQueue.Len() > 0 && self.expiryQueue[0].expiry <= now {
+		entry := heap.Pop(&self.expiryQueue).(*expiryEntry)
+		// The envelope may already be gone, evicted early under capacity
+		// pressure; expiryQueue entries are only ever lazily cleaned up here.
+		if envelope, ok := self.messages[entry.hash]; ok {
+			self.poolBytes -= envelope.Size()
+			delete(self.messages, entry.hash)
+			self.removeFromEvictionQueue(entry.hash)
+			self.expiredCount++
 		}
-		// Dump all expired messages and remove timestamp
-		hashSet.Each(func(v interface{}) bool {
-			delete(self.messages, v.(common.Hash))
-			return true
-		})
-		self.expirations[then].Clear()
 	}
 }
 
@@ -0,0 +1,49 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package whisper
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// TestAllowP2PMessagesFromPeerRegistersOnlyThatPeer checks that
+// AllowP2PMessagesFromPeer records exactly the enode it was given as
+// trusted, leaving every other node ungated -- the property handlePeer's
+// p2pRequestCode/p2pMessageCode dispatch relies on to keep historic-message
+// delivery restricted to explicitly allow-listed mail-server peers.
+//
+// isTrustedPeer itself takes a live *p2p.Peer, which this trimmed tree has
+// no way to construct outside of a real connection, so this test covers the
+// registration side of the gate rather than handlePeer's dispatch.
+func TestAllowP2PMessagesFromPeerRegistersOnlyThatPeer(t *testing.T) {
+	w := New(nil)
+
+	var trusted, untrusted discover.NodeID
+	trusted[0] = 1
+	untrusted[0] = 2
+
+	w.AllowP2PMessagesFromPeer(&discover.Node{ID: trusted})
+
+	if _, ok := w.trustedPeers[trusted]; !ok {
+		t.Error("trustedPeers does not contain the allow-listed node")
+	}
+	if _, ok := w.trustedPeers[untrusted]; ok {
+		t.Error("trustedPeers contains a node that was never allow-listed")
+	}
+}